@@ -0,0 +1,13 @@
+package apps_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestApps(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Apps Suite")
+}
@@ -0,0 +1,70 @@
+package apps
+
+import (
+	"fmt"
+	"net/url"
+
+	plugin_models "code.cloudfoundry.org/cli/plugin/models"
+)
+
+// v3 caps page sizes at 5000 resources
+const perPage = 5000
+
+type client interface {
+	Get(path string, v interface{}) error
+}
+
+type cliConn interface {
+	GetCurrentSpace() (plugin_models.Space, error)
+}
+
+type appsPage struct {
+	Pagination struct {
+		Next *struct {
+			Href string `json:"href"`
+		} `json:"next"`
+	} `json:"pagination"`
+	Resources []struct {
+		Name string `json:"name"`
+	} `json:"resources"`
+}
+
+// Selector finds the apps of the targeted space by their Cloud Controller v3
+// labels.
+type Selector struct {
+	client  client
+	cliConn cliConn
+}
+
+func NewSelector(client client, conn cliConn) *Selector {
+	return &Selector{client: client, cliConn: conn}
+}
+
+// SelectApps returns the names of the apps matching a label selector, e.g.
+// team=payments,tier=prod. The Cloud Controller validates the selector.
+func (s *Selector) SelectApps(labelSelector string) ([]string, error) {
+	space, err := s.cliConn.GetCurrentSpace()
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	path := fmt.Sprintf("/v3/apps?space_guids=%s&label_selector=%s&per_page=%d", space.Guid, url.QueryEscape(labelSelector), perPage)
+	for path != "" {
+		var page appsPage
+		err := s.client.Get(path, &page)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, app := range page.Resources {
+			names = append(names, app.Name)
+		}
+
+		path = ""
+		if page.Pagination.Next != nil {
+			path = page.Pagination.Next.Href
+		}
+	}
+	return names, nil
+}
@@ -0,0 +1,71 @@
+package apps_test
+
+import (
+	"encoding/json"
+	"errors"
+
+	plugin_models "code.cloudfoundry.org/cli/plugin/models"
+	"github.com/pivotal-cf/metric-registrar-cli/apps"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Selector", func() {
+	var client *mockClient
+
+	BeforeEach(func() {
+		client = &mockClient{
+			paths: make(chan string, 10),
+			responses: []string{
+				`{"pagination": {"next": {"href": "/v3/apps?page=2"}}, "resources": [{"name": "payments-api"}]}`,
+				`{"pagination": {"next": null}, "resources": [{"name": "payments-worker"}]}`,
+			},
+		}
+	})
+
+	It("returns the names of the space's apps matching the label selector", func() {
+		names, err := apps.NewSelector(client, client).SelectApps("team=payments,tier in (prod)")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(names).To(Equal([]string{"payments-api", "payments-worker"}))
+
+		Expect(client.paths).To(Receive(Equal("/v3/apps?space_guids=space-guid&label_selector=team%3Dpayments%2Ctier+in+%28prod%29&per_page=5000")))
+		Expect(client.paths).To(Receive(Equal("/v3/apps?page=2")))
+	})
+
+	It("returns an error if getting the space fails", func() {
+		client.spaceErr = errors.New("expected")
+
+		_, err := apps.NewSelector(client, client).SelectApps("team=payments")
+		Expect(err).To(MatchError("expected"))
+	})
+
+	It("returns the Cloud Controller error", func() {
+		client.err = errors.New("expected")
+
+		_, err := apps.NewSelector(client, client).SelectApps("team=payments")
+		Expect(err).To(MatchError("expected"))
+	})
+})
+
+type mockClient struct {
+	paths     chan string
+	responses []string
+	err       error
+	spaceErr  error
+}
+
+func (c *mockClient) Get(path string, v interface{}) error {
+	c.paths <- path
+	if c.err != nil {
+		return c.err
+	}
+
+	response := c.responses[0]
+	c.responses = c.responses[1:]
+	return json.Unmarshal([]byte(response), v)
+}
+
+func (c *mockClient) GetCurrentSpace() (plugin_models.Space, error) {
+	return plugin_models.Space{SpaceFields: plugin_models.SpaceFields{Guid: "space-guid"}}, c.spaceErr
+}
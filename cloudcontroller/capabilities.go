@@ -0,0 +1,134 @@
+package cloudcontroller
+
+import (
+	"fmt"
+	"strings"
+)
+
+type getter interface {
+	Get(path string, v interface{}) error
+}
+
+// Feature is a Cloud Controller v3 resource the plugin depends on. It is
+// named after the resource's link in the /v3 root document.
+type Feature string
+
+const (
+	ServiceInstances          Feature = "service_instances"
+	ServiceCredentialBindings Feature = "service_credential_bindings"
+	Routes                    Feature = "routes"
+	Apps                      Feature = "apps"
+)
+
+// Capabilities records which Cloud Controller APIs and features are
+// available on the targeted foundation.
+type Capabilities struct {
+	V2        bool
+	V2Version string
+	V3        bool
+	V3Version string
+	Platform  string
+
+	v3Resources map[string]bool
+}
+
+type link struct {
+	Href string `json:"href"`
+	Meta struct {
+		Version string `json:"version"`
+	} `json:"meta"`
+}
+
+type rootResponse struct {
+	Links struct {
+		CloudControllerV2 *link `json:"cloud_controller_v2"`
+		CloudControllerV3 *link `json:"cloud_controller_v3"`
+	} `json:"links"`
+}
+
+type v3RootResponse struct {
+	Links map[string]*link `json:"links"`
+}
+
+type infoResponse struct {
+	Name string `json:"name"`
+}
+
+// Probe reads the API root and, when v3 is available, the /v3 root and
+// /v3/info documents to determine what the Cloud Controller supports.
+func Probe(client getter) (Capabilities, error) {
+	var root rootResponse
+	err := client.Get("/", &root)
+	if err != nil {
+		return Capabilities{}, fmt.Errorf("unable to read Cloud Controller API root: %s", err)
+	}
+
+	c := Capabilities{v3Resources: map[string]bool{}}
+	if l := root.Links.CloudControllerV2; l != nil {
+		c.V2 = true
+		c.V2Version = l.Meta.Version
+	}
+	if l := root.Links.CloudControllerV3; l != nil {
+		c.V3 = true
+		c.V3Version = l.Meta.Version
+	}
+
+	if !c.V3 {
+		return c, nil
+	}
+
+	var v3Root v3RootResponse
+	err = client.Get("/v3", &v3Root)
+	if err != nil {
+		return Capabilities{}, fmt.Errorf("unable to read Cloud Controller v3 root: %s", err)
+	}
+	for name, l := range v3Root.Links {
+		if l != nil {
+			c.v3Resources[name] = true
+		}
+	}
+
+	var info infoResponse
+	err = client.Get("/v3/info", &info)
+	if err != nil {
+		return Capabilities{}, fmt.Errorf("unable to read Cloud Controller info: %s", err)
+	}
+	c.Platform = info.Name
+
+	return c, nil
+}
+
+// Supports reports whether the v3 API exposes the given feature.
+func (c Capabilities) Supports(f Feature) bool {
+	return c.V3 && c.v3Resources[string(f)]
+}
+
+// Require returns an error naming the first of the given features that is
+// not supported.
+func (c Capabilities) Require(features ...Feature) error {
+	for _, f := range features {
+		if !c.Supports(f) {
+			return fmt.Errorf("the Cloud Controller (%s) does not support the v3 %s API", c, f)
+		}
+	}
+	return nil
+}
+
+func (c Capabilities) String() string {
+	var apis []string
+	if c.V2 {
+		apis = append(apis, strings.TrimSpace("v2 "+c.V2Version))
+	}
+	if c.V3 {
+		apis = append(apis, strings.TrimSpace("v3 "+c.V3Version))
+	}
+	if len(apis) == 0 {
+		apis = append(apis, "no known API versions")
+	}
+
+	description := strings.Join(apis, ", ")
+	if c.Platform != "" {
+		description = c.Platform + ": " + description
+	}
+	return description
+}
@@ -0,0 +1,160 @@
+package cloudcontroller_test
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/pivotal-cf/metric-registrar-cli/cloudcontroller"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Capabilities", func() {
+	Describe("Probe", func() {
+		It("records v2 and v3 availability and features", func() {
+			client := newMockCliConnection()
+
+			caps, err := cloudcontroller.Probe(client)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(caps.V2).To(BeTrue())
+			Expect(caps.V2Version).To(Equal("2.150.0"))
+			Expect(caps.V3).To(BeTrue())
+			Expect(caps.V3Version).To(Equal("3.85.0"))
+			Expect(caps.Platform).To(Equal("cf-deployment"))
+			Expect(caps.Supports(cloudcontroller.ServiceCredentialBindings)).To(BeTrue())
+			Expect(caps.Supports(cloudcontroller.Routes)).To(BeTrue())
+			Expect(caps.Require(cloudcontroller.ServiceInstances, cloudcontroller.Routes)).To(Succeed())
+		})
+
+		It("handles v3-only foundations", func() {
+			client := newMockCliConnection()
+			client.responses["/"] = v3OnlyRoot
+			client.responses["/v3/info"] = `{"name": "korifi"}`
+
+			caps, err := cloudcontroller.Probe(client)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(caps.V2).To(BeFalse())
+			Expect(caps.V3).To(BeTrue())
+			Expect(caps.Platform).To(Equal("korifi"))
+			Expect(caps.String()).To(Equal("korifi: v3 3.117.0"))
+		})
+
+		It("doesn't read v3 documents when v3 is unavailable", func() {
+			client := newMockCliConnection()
+			client.responses["/"] = v2OnlyRoot
+			client.errors["/v3"] = errors.New("unexpected")
+
+			caps, err := cloudcontroller.Probe(client)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(caps.V2).To(BeTrue())
+			Expect(caps.V3).To(BeFalse())
+			Expect(caps.Supports(cloudcontroller.Routes)).To(BeFalse())
+		})
+
+		It("reports missing features", func() {
+			client := newMockCliConnection()
+			client.responses["/v3"] = `{"links": {"apps": {"href": "https://api.example.com/v3/apps"}, "routes": null}}`
+
+			caps, err := cloudcontroller.Probe(client)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(caps.Supports(cloudcontroller.Routes)).To(BeFalse())
+			Expect(caps.Require(cloudcontroller.Routes)).To(MatchError(
+				"the Cloud Controller (cf-deployment: v2 2.150.0, v3 3.85.0) does not support the v3 routes API",
+			))
+		})
+
+		DescribeTable("errors", func(modify func(*mockClient)) {
+			client := newMockCliConnection()
+			modify(client)
+
+			_, err := cloudcontroller.Probe(client)
+			Expect(err).To(HaveOccurred())
+		},
+			Entry("reading the root fails", func(c *mockClient) {
+				c.errors["/"] = errors.New("expected")
+			}),
+			Entry("the root is invalid JSON", func(c *mockClient) {
+				c.responses["/"] = `{invalid]`
+			}),
+			Entry("reading the v3 root fails", func(c *mockClient) {
+				c.errors["/v3"] = errors.New("expected")
+			}),
+			Entry("reading v3 info fails", func(c *mockClient) {
+				c.errors["/v3/info"] = errors.New("expected")
+			}),
+		)
+	})
+})
+
+type mockClient struct {
+	responses map[string]string
+	errors    map[string]error
+}
+
+func newMockCliConnection() *mockClient {
+	return &mockClient{
+		responses: map[string]string{
+			"/":        validRoot,
+			"/v3":      validV3Root,
+			"/v3/info": `{"name": "cf-deployment", "build": "", "version": 0}`,
+		},
+		errors: map[string]error{},
+	}
+}
+
+func (c *mockClient) Get(path string, v interface{}) error {
+	Expect(c.responses).To(HaveKey(path))
+	if c.errors[path] != nil {
+		return c.errors[path]
+	}
+
+	return json.Unmarshal([]byte(c.responses[path]), v)
+}
+
+const (
+	validRoot = `{
+  "links": {
+    "self": {"href": "https://api.example.com"},
+    "cloud_controller_v2": {
+      "href": "https://api.example.com/v2",
+      "meta": {"version": "2.150.0"}
+    },
+    "cloud_controller_v3": {
+      "href": "https://api.example.com/v3",
+      "meta": {"version": "3.85.0"}
+    }
+  }
+}`
+
+	v3OnlyRoot = `{
+  "links": {
+    "self": {"href": "https://api.example.com"},
+    "cloud_controller_v2": null,
+    "cloud_controller_v3": {
+      "href": "https://api.example.com/v3",
+      "meta": {"version": "3.117.0"}
+    }
+  }
+}`
+
+	v2OnlyRoot = `{
+  "links": {
+    "self": {"href": "https://api.example.com"},
+    "cloud_controller_v2": {
+      "href": "https://api.example.com/v2",
+      "meta": {"version": "2.100.0"}
+    }
+  }
+}`
+
+	validV3Root = `{
+  "links": {
+    "self": {"href": "https://api.example.com/v3"},
+    "apps": {"href": "https://api.example.com/v3/apps"},
+    "routes": {"href": "https://api.example.com/v3/routes"},
+    "service_instances": {"href": "https://api.example.com/v3/service_instances"},
+    "service_credential_bindings": {"href": "https://api.example.com/v3/service_credential_bindings"}
+  }
+}`
+)
@@ -0,0 +1,317 @@
+package cloudcontroller
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultMaxRetries = 3
+	DefaultTimeout    = 60 * time.Second
+
+	baseBackoff  = 500 * time.Millisecond
+	maxBackoff   = 30 * time.Second
+	maxRetryWait = 2 * time.Minute
+)
+
+type Option func(*options)
+
+type options struct {
+	maxRetries int
+	timeout    time.Duration
+	sleep      func(time.Duration)
+}
+
+// WithMaxRetries limits how many times a request that failed transiently is
+// retried.
+func WithMaxRetries(n int) Option {
+	return func(o *options) {
+		o.maxRetries = n
+	}
+}
+
+// WithTimeout limits how long each request may take, including reading the
+// response.
+func WithTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.timeout = d
+	}
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		maxRetries: DefaultMaxRetries,
+		timeout:    DefaultTimeout,
+		sleep:      time.Sleep,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.maxRetries < 0 {
+		o.maxRetries = 0
+	}
+	return o
+}
+
+// Connection is the part of the plugin connection the client needs to reach
+// the targeted Cloud Controller.
+type Connection interface {
+	ApiEndpoint() (string, error)
+	AccessToken() (string, error)
+	IsSSLDisabled() (bool, error)
+}
+
+// Client makes requests to the Cloud Controller with the CLI's current API
+// endpoint and access token. It is safe for concurrent use.
+type Client struct {
+	conn       Connection
+	endpoint   *url.URL
+	httpClient *http.Client
+	options    options
+
+	mu    sync.Mutex
+	token string
+}
+
+func NewClient(conn Connection, opts ...Option) (*Client, error) {
+	o := newOptions(opts)
+
+	endpoint, err := conn.ApiEndpoint()
+	if err != nil {
+		return nil, err
+	}
+	if endpoint == "" {
+		return nil, fmt.Errorf("no API endpoint set, use 'cf login' or 'cf api' to target a foundation")
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid API endpoint %q: %s", endpoint, err)
+	}
+
+	sslDisabled, err := conn.IsSSLDisabled()
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: sslDisabled}
+
+	return &Client{
+		conn:     conn,
+		endpoint: u,
+		httpClient: &http.Client{
+			Transport: transport,
+			Timeout:   o.timeout,
+		},
+		options: o,
+	}, nil
+}
+
+// Get decodes the JSON response for path into v.
+func (c *Client) Get(path string, v interface{}) error {
+	return c.Do(http.MethodGet, path, nil, v)
+}
+
+// Do sends body as JSON to path and decodes the JSON response into v. Either
+// may be nil. Responses with an error status are returned as typed errors.
+// Transient failures are retried with backoff where repeating the request is
+// safe.
+func (c *Client) Do(method, path string, body, v interface{}) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		payload, err = json.Marshal(body)
+		if err != nil {
+			return err
+		}
+	}
+
+	u, err := c.endpoint.Parse(path)
+	if err != nil {
+		return err
+	}
+
+	var resp response
+	for attempt := 0; ; attempt++ {
+		resp, err = c.authorizedSend(method, u.String(), payload)
+		if attempt >= c.options.maxRetries || !retryable(method, resp.status, err) {
+			break
+		}
+		c.options.sleep(retryWait(attempt, resp.header))
+	}
+	if err != nil {
+		return err
+	}
+
+	if resp.status < 200 || resp.status > 299 {
+		return responseError(resp.status, resp.body)
+	}
+
+	if v == nil || len(bytes.TrimSpace(resp.body)) == 0 {
+		return nil
+	}
+	return json.Unmarshal(resp.body, v)
+}
+
+type response struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+func (c *Client) authorizedSend(method, u string, payload []byte) (response, error) {
+	token, err := c.accessToken(false)
+	if err != nil {
+		return response{}, err
+	}
+
+	resp, err := c.send(method, u, payload, token)
+	if err != nil || resp.status != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	// the CLI refreshes expired tokens when asked for one, so retry once
+	// with a fresh token
+	token, err = c.accessToken(true)
+	if err != nil {
+		return response{}, err
+	}
+	return c.send(method, u, payload, token)
+}
+
+func (c *Client) send(method, u string, payload []byte, token string) (response, error) {
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return response{}, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", token)
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return response{}, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return response{}, err
+	}
+
+	return response{status: resp.StatusCode, header: resp.Header, body: respBody}, nil
+}
+
+// retryable reports whether a failed request can be repeated. Rate limited
+// requests were rejected before being processed, so any method is retried.
+// Other failures may have happened after the Cloud Controller acted on the
+// request, so only idempotent methods are retried.
+func retryable(method string, status int, err error) bool {
+	if status == http.StatusTooManyRequests {
+		return true
+	}
+
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+	default:
+		return false
+	}
+
+	if err != nil {
+		// only connection failures are transient; a bad certificate or
+		// access token won't be fixed by trying again
+		var urlErr *url.Error
+		var certErr *tls.CertificateVerificationError
+		return errors.As(err, &urlErr) && !errors.As(err, &certErr)
+	}
+
+	switch status {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryWait honors the server's Retry-After or rate limit reset time and
+// otherwise backs off exponentially with full jitter.
+func retryWait(attempt int, header http.Header) time.Duration {
+	if d, ok := serverWait(header); ok {
+		if d < 0 {
+			return 0
+		}
+		if d > maxRetryWait {
+			return maxRetryWait
+		}
+		return d
+	}
+
+	backoff := maxBackoff
+	if attempt < 16 {
+		backoff = baseBackoff << attempt
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	return time.Duration(rand.Int63n(int64(backoff) + 1))
+}
+
+func serverWait(header http.Header) (time.Duration, bool) {
+	if v := header.Get("Retry-After"); v != "" {
+		if seconds, err := strconv.Atoi(v); err == nil {
+			return time.Duration(seconds) * time.Second, true
+		}
+		if t, err := http.ParseTime(v); err == nil {
+			return time.Until(t), true
+		}
+	}
+
+	if header.Get("X-RateLimit-Remaining") == "0" {
+		if reset, err := strconv.ParseInt(header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+			return time.Until(time.Unix(reset, 0)), true
+		}
+	}
+
+	return 0, false
+}
+
+func (c *Client) accessToken(refresh bool) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token != "" && !refresh {
+		return c.token, nil
+	}
+
+	token, err := c.conn.AccessToken()
+	if err != nil {
+		return "", err
+	}
+	if token == "" {
+		return "", fmt.Errorf("not logged in, use 'cf login' to log in")
+	}
+	if !strings.Contains(token, " ") {
+		token = "bearer " + token
+	}
+
+	c.token = token
+	return token, nil
+}
@@ -0,0 +1,352 @@
+package cloudcontroller_test
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"time"
+
+	"github.com/pivotal-cf/metric-registrar-cli/cloudcontroller"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Client", func() {
+	var (
+		server   *httptest.Server
+		conn     *mockConnection
+		requests chan *recordedRequest
+		handler  http.HandlerFunc
+		sleeps   []time.Duration
+		sleep    cloudcontroller.Option
+	)
+
+	BeforeEach(func() {
+		requests = make(chan *recordedRequest, 10)
+		sleeps = nil
+		sleep = cloudcontroller.WithSleep(func(d time.Duration) {
+			sleeps = append(sleeps, d)
+		})
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"name": "cf-deployment"}`))
+		}
+
+		server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			requests <- &recordedRequest{
+				method:        r.Method,
+				uri:           r.URL.RequestURI(),
+				authorization: r.Header.Get("Authorization"),
+				contentType:   r.Header.Get("Content-Type"),
+				body:          string(body),
+			}
+			handler(w, r)
+		}))
+
+		conn = &mockConnection{
+			endpoint:    server.URL,
+			tokens:      []string{"bearer token"},
+			sslDisabled: true,
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("gets JSON from the API endpoint with the access token", func() {
+		client, err := cloudcontroller.NewClient(conn, sleep)
+		Expect(err).ToNot(HaveOccurred())
+
+		var info struct {
+			Name string `json:"name"`
+		}
+		Expect(client.Get("/v3/info?foo=bar", &info)).To(Succeed())
+		Expect(info.Name).To(Equal("cf-deployment"))
+
+		var r *recordedRequest
+		Expect(requests).To(Receive(&r))
+		Expect(r.method).To(Equal(http.MethodGet))
+		Expect(r.uri).To(Equal("/v3/info?foo=bar"))
+		Expect(r.authorization).To(Equal("bearer token"))
+	})
+
+	It("follows absolute links", func() {
+		client, err := cloudcontroller.NewClient(conn, sleep)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(client.Get(server.URL+"/v3/apps?page=2", nil)).To(Succeed())
+
+		var r *recordedRequest
+		Expect(requests).To(Receive(&r))
+		Expect(r.uri).To(Equal("/v3/apps?page=2"))
+	})
+
+	It("sends bodies as JSON", func() {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusCreated)
+		}
+		client, err := cloudcontroller.NewClient(conn, sleep)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(client.Do(http.MethodPut, "/v2/apps/app-guid", map[string][]int{"ports": {8080}}, nil)).To(Succeed())
+
+		var r *recordedRequest
+		Expect(requests).To(Receive(&r))
+		Expect(r.method).To(Equal(http.MethodPut))
+		Expect(r.contentType).To(Equal("application/json"))
+		Expect(r.body).To(MatchJSON(`{"ports": [8080]}`))
+	})
+
+	It("retries once with a refreshed token when unauthorized", func() {
+		conn.tokens = []string{"bearer expired", "bearer fresh"}
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "bearer fresh" {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`{"errors": [{"code": 1000, "title": "CF-InvalidAuthToken", "detail": "Invalid Auth Token"}]}`))
+				return
+			}
+			w.Write([]byte(`{}`))
+		}
+		client, err := cloudcontroller.NewClient(conn, sleep)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(client.Get("/v3/apps", nil)).To(Succeed())
+		Expect(requests).To(HaveLen(2))
+	})
+
+	It("returns typed errors for error statuses", func() {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors": [{"code": 10010, "title": "CF-ResourceNotFound", "detail": "App not found"}]}`))
+		}
+		client, err := cloudcontroller.NewClient(conn, sleep)
+		Expect(err).ToNot(HaveOccurred())
+
+		err = client.Get("/v3/apps/app-guid", nil)
+
+		var notFound cloudcontroller.NotFoundError
+		Expect(errors.As(err, &notFound)).To(BeTrue())
+		Expect(notFound.StatusCode).To(Equal(http.StatusNotFound))
+		Expect(err).To(MatchError("cloud controller error CF-ResourceNotFound: App not found"))
+	})
+
+	It("classifies errors by status when the body doesn't describe them", func() {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+		client, err := cloudcontroller.NewClient(conn, sleep)
+		Expect(err).ToNot(HaveOccurred())
+
+		err = client.Get("/v3/apps", nil)
+		Expect(errors.As(err, &cloudcontroller.RateLimitedError{})).To(BeTrue())
+		Expect(err).To(MatchError("cloud controller error Too Many Requests"))
+	})
+
+	Describe("retries", func() {
+		It("retries reads that fail transiently with exponential backoff", func() {
+			attempts := 0
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				attempts++
+				if attempts < 3 {
+					w.WriteHeader(http.StatusBadGateway)
+					return
+				}
+				w.Write([]byte(`{"name": "cf-deployment"}`))
+			}
+			client, err := cloudcontroller.NewClient(conn, sleep)
+			Expect(err).ToNot(HaveOccurred())
+
+			var info struct {
+				Name string `json:"name"`
+			}
+			Expect(client.Get("/v3/info", &info)).To(Succeed())
+			Expect(info.Name).To(Equal("cf-deployment"))
+			Expect(requests).To(HaveLen(3))
+			Expect(sleeps).To(HaveLen(2))
+			Expect(sleeps[0]).To(BeNumerically("<=", 500*time.Millisecond))
+			Expect(sleeps[1]).To(BeNumerically("<=", time.Second))
+		})
+
+		It("gives up after the maximum number of retries", func() {
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+			client, err := cloudcontroller.NewClient(conn, sleep, cloudcontroller.WithMaxRetries(2))
+			Expect(err).ToNot(HaveOccurred())
+
+			err = client.Get("/v3/info", nil)
+			Expect(err).To(MatchError("cloud controller error Service Unavailable"))
+			Expect(requests).To(HaveLen(3))
+		})
+
+		It("honors Retry-After", func() {
+			attempts := 0
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				attempts++
+				if attempts == 1 {
+					w.Header().Set("Retry-After", "7")
+					w.WriteHeader(http.StatusTooManyRequests)
+					return
+				}
+				w.Write([]byte(`{}`))
+			}
+			client, err := cloudcontroller.NewClient(conn, sleep)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(client.Get("/v3/info", nil)).To(Succeed())
+			Expect(sleeps).To(Equal([]time.Duration{7 * time.Second}))
+		})
+
+		It("waits for the rate limit to reset", func() {
+			attempts := 0
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				attempts++
+				if attempts == 1 {
+					w.Header().Set("X-RateLimit-Remaining", "0")
+					w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10))
+					w.WriteHeader(http.StatusTooManyRequests)
+					return
+				}
+				w.Write([]byte(`{}`))
+			}
+			client, err := cloudcontroller.NewClient(conn, sleep)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(client.Get("/v3/info", nil)).To(Succeed())
+			Expect(sleeps).To(HaveLen(1))
+			Expect(sleeps[0]).To(BeNumerically("~", time.Minute, 2*time.Second))
+		})
+
+		It("retries rate limited writes", func() {
+			attempts := 0
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				attempts++
+				if attempts == 1 {
+					w.WriteHeader(http.StatusTooManyRequests)
+					return
+				}
+				w.WriteHeader(http.StatusCreated)
+			}
+			client, err := cloudcontroller.NewClient(conn, sleep)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(client.Do(http.MethodPost, "/v3/routes/route-guid/destinations", map[string]string{}, nil)).To(Succeed())
+			Expect(requests).To(HaveLen(2))
+		})
+
+		It("doesn't retry writes that may have been processed", func() {
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusBadGateway)
+			}
+			client, err := cloudcontroller.NewClient(conn, sleep)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(client.Do(http.MethodPost, "/v3/routes/route-guid/destinations", map[string]string{}, nil)).ToNot(Succeed())
+			Expect(requests).To(HaveLen(1))
+			Expect(sleeps).To(BeEmpty())
+		})
+
+		It("retries idempotent writes", func() {
+			attempts := 0
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				attempts++
+				if attempts == 1 {
+					w.WriteHeader(http.StatusGatewayTimeout)
+					return
+				}
+				w.WriteHeader(http.StatusCreated)
+			}
+			client, err := cloudcontroller.NewClient(conn, sleep)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(client.Do(http.MethodPut, "/v2/apps/app-guid", map[string][]int{"ports": {8080}}, nil)).To(Succeed())
+			Expect(requests).To(HaveLen(2))
+		})
+
+		It("doesn't retry certificate errors", func() {
+			conn.sslDisabled = false
+			client, err := cloudcontroller.NewClient(conn, sleep)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(client.Get("/v3/info", nil)).ToNot(Succeed())
+			Expect(sleeps).To(BeEmpty())
+		})
+
+		It("times out slow requests", func() {
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(100 * time.Millisecond)
+			}
+			client, err := cloudcontroller.NewClient(conn, sleep, cloudcontroller.WithTimeout(10*time.Millisecond), cloudcontroller.WithMaxRetries(0))
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(client.Get("/v3/info", nil)).ToNot(Succeed())
+		})
+	})
+
+	It("verifies certificates unless SSL validation is disabled", func() {
+		conn.sslDisabled = false
+		client, err := cloudcontroller.NewClient(conn, sleep)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(client.Get("/v3/info", nil)).ToNot(Succeed())
+	})
+
+	DescribeTable("errors", func(modify func(*mockConnection)) {
+		modify(conn)
+
+		client, err := cloudcontroller.NewClient(conn, sleep)
+		if err == nil {
+			err = client.Get("/v3/info", nil)
+		}
+		Expect(err).To(HaveOccurred())
+	},
+		Entry("getting the endpoint fails", func(c *mockConnection) {
+			c.endpointErr = errors.New("expected")
+		}),
+		Entry("no endpoint is set", func(c *mockConnection) {
+			c.endpoint = ""
+		}),
+		Entry("getting the token fails", func(c *mockConnection) {
+			c.tokenErr = errors.New("expected")
+		}),
+		Entry("not logged in", func(c *mockConnection) {
+			c.tokens = []string{""}
+		}),
+	)
+})
+
+type recordedRequest struct {
+	method        string
+	uri           string
+	authorization string
+	contentType   string
+	body          string
+}
+
+type mockConnection struct {
+	endpoint    string
+	endpointErr error
+	tokens      []string
+	tokenErr    error
+	sslDisabled bool
+}
+
+func (c *mockConnection) ApiEndpoint() (string, error) {
+	return c.endpoint, c.endpointErr
+}
+
+func (c *mockConnection) AccessToken() (string, error) {
+	token := c.tokens[0]
+	if len(c.tokens) > 1 {
+		c.tokens = c.tokens[1:]
+	}
+	return token, c.tokenErr
+}
+
+func (c *mockConnection) IsSSLDisabled() (bool, error) {
+	return c.sslDisabled, nil
+}
@@ -0,0 +1,125 @@
+package cloudcontroller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// APIError is an error the Cloud Controller reported in a response.
+type APIError struct {
+	StatusCode int
+	Code       int
+	Title      string
+	Detail     string
+}
+
+func (e APIError) Error() string {
+	if e.Detail == "" {
+		return fmt.Sprintf("cloud controller error %s", e.Title)
+	}
+	return fmt.Sprintf("cloud controller error %s: %s", e.Title, e.Detail)
+}
+
+type NotFoundError struct {
+	APIError
+}
+
+func (e NotFoundError) Unwrap() error {
+	return e.APIError
+}
+
+type NotAuthorizedError struct {
+	APIError
+}
+
+func (e NotAuthorizedError) Unwrap() error {
+	return e.APIError
+}
+
+type RateLimitedError struct {
+	APIError
+}
+
+func (e RateLimitedError) Unwrap() error {
+	return e.APIError
+}
+
+type errorResponse struct {
+	// v2
+	Code        int    `json:"code"`
+	ErrorCode   string `json:"error_code"`
+	Description string `json:"description"`
+
+	// v3
+	Errors []struct {
+		Code   int    `json:"code"`
+		Title  string `json:"title"`
+		Detail string `json:"detail"`
+	} `json:"errors"`
+
+	// UAA, when the token is rejected before reaching the Cloud Controller
+	UAAError            string `json:"error"`
+	UAAErrorDescription string `json:"error_description"`
+}
+
+// CheckResponse returns a typed error if body is a v2, v3 or UAA error
+// payload. Bodies that are not JSON objects are left for the caller to
+// report.
+func CheckResponse(body []byte) error {
+	var resp errorResponse
+	if json.Unmarshal(body, &resp) != nil {
+		return nil
+	}
+
+	switch {
+	case resp.ErrorCode != "":
+		return classify(APIError{Code: resp.Code, Title: resp.ErrorCode, Detail: resp.Description})
+	case len(resp.Errors) > 0:
+		var details []string
+		for _, e := range resp.Errors {
+			details = append(details, e.Detail)
+		}
+		return classify(APIError{Code: resp.Errors[0].Code, Title: resp.Errors[0].Title, Detail: strings.Join(details, "; ")})
+	case resp.UAAError != "":
+		return NotAuthorizedError{APIError{Title: resp.UAAError, Detail: resp.UAAErrorDescription}}
+	}
+
+	return nil
+}
+
+// responseError builds the error for a response with an error status,
+// falling back to the status text when the body doesn't describe the error.
+func responseError(status int, body []byte) error {
+	var e APIError
+	err := CheckResponse(body)
+	if !errors.As(err, &e) {
+		e = APIError{Title: http.StatusText(status)}
+	}
+	e.StatusCode = status
+
+	return classify(e)
+}
+
+func classify(e APIError) error {
+	switch {
+	case e.StatusCode == http.StatusUnauthorized,
+		e.StatusCode == http.StatusForbidden,
+		e.Title == "CF-NotAuthorized",
+		e.Title == "CF-NotAuthenticated",
+		e.Title == "CF-InvalidAuthToken":
+		return NotAuthorizedError{e}
+	case e.StatusCode == http.StatusTooManyRequests,
+		e.Title == "CF-RateLimitExceeded",
+		e.Title == "CF-RateLimitV2APIExceeded":
+		return RateLimitedError{e}
+	case e.StatusCode == http.StatusNotFound,
+		// v2 names these per resource, e.g. CF-AppNotFound
+		strings.HasSuffix(e.Title, "NotFound"):
+		return NotFoundError{e}
+	}
+
+	return e
+}
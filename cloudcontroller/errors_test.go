@@ -0,0 +1,84 @@
+package cloudcontroller_test
+
+import (
+	"errors"
+
+	"github.com/pivotal-cf/metric-registrar-cli/cloudcontroller"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Errors", func() {
+	Describe("CheckResponse", func() {
+		It("ignores successful responses", func() {
+			Expect(cloudcontroller.CheckResponse([]byte(`{"entity": {"ports": [8080]}}`))).To(Succeed())
+			Expect(cloudcontroller.CheckResponse([]byte(`{"resources": [], "errors": []}`))).To(Succeed())
+			Expect(cloudcontroller.CheckResponse([]byte(``))).To(Succeed())
+			Expect(cloudcontroller.CheckResponse([]byte(`[1, 2]`))).To(Succeed())
+		})
+
+		It("parses v2 errors", func() {
+			err := cloudcontroller.CheckResponse([]byte(`{
+  "code": 100004,
+  "description": "The app could not be found: app-guid",
+  "error_code": "CF-AppNotFound"
+}`))
+
+			var notFound cloudcontroller.NotFoundError
+			Expect(errors.As(err, &notFound)).To(BeTrue())
+			Expect(notFound.Code).To(Equal(100004))
+			Expect(err).To(MatchError("cloud controller error CF-AppNotFound: The app could not be found: app-guid"))
+		})
+
+		It("parses v3 errors", func() {
+			err := cloudcontroller.CheckResponse([]byte(`{
+  "errors": [
+    {"code": 10010, "title": "CF-ResourceNotFound", "detail": "App not found"},
+    {"code": 10010, "title": "CF-ResourceNotFound", "detail": "Route not found"}
+  ]
+}`))
+
+			Expect(errors.As(err, &cloudcontroller.NotFoundError{})).To(BeTrue())
+			Expect(err).To(MatchError("cloud controller error CF-ResourceNotFound: App not found; Route not found"))
+		})
+
+		It("parses UAA errors as not authorized", func() {
+			err := cloudcontroller.CheckResponse([]byte(`{"error": "invalid_token", "error_description": "Invalid access token"}`))
+
+			Expect(errors.As(err, &cloudcontroller.NotAuthorizedError{})).To(BeTrue())
+		})
+
+		DescribeTable("classifies errors", func(title string, matches func(error) bool) {
+			err := cloudcontroller.CheckResponse([]byte(`{"errors": [{"code": 1, "title": "` + title + `", "detail": "detail"}]}`))
+
+			Expect(err).To(HaveOccurred())
+			Expect(matches(err)).To(BeTrue())
+
+			var apiErr cloudcontroller.APIError
+			Expect(errors.As(err, &apiErr)).To(BeTrue())
+			Expect(apiErr.Title).To(Equal(title))
+		},
+			Entry("not authorized", "CF-NotAuthorized", func(err error) bool {
+				return errors.As(err, &cloudcontroller.NotAuthorizedError{})
+			}),
+			Entry("not authenticated", "CF-NotAuthenticated", func(err error) bool {
+				return errors.As(err, &cloudcontroller.NotAuthorizedError{})
+			}),
+			Entry("invalid token", "CF-InvalidAuthToken", func(err error) bool {
+				return errors.As(err, &cloudcontroller.NotAuthorizedError{})
+			}),
+			Entry("rate limited", "CF-RateLimitExceeded", func(err error) bool {
+				return errors.As(err, &cloudcontroller.RateLimitedError{})
+			}),
+			Entry("v2 not found", "CF-RouteNotFound", func(err error) bool {
+				return errors.As(err, &cloudcontroller.NotFoundError{})
+			}),
+			Entry("anything else", "CF-UnprocessableEntity", func(err error) bool {
+				return !errors.As(err, &cloudcontroller.NotFoundError{}) &&
+					!errors.As(err, &cloudcontroller.NotAuthorizedError{}) &&
+					!errors.As(err, &cloudcontroller.RateLimitedError{})
+			}),
+		)
+	})
+})
@@ -0,0 +1,10 @@
+package cloudcontroller
+
+import "time"
+
+// WithSleep replaces the function used to wait between retries.
+func WithSleep(sleep func(time.Duration)) Option {
+	return func(o *options) {
+		o.sleep = sleep
+	}
+}
@@ -0,0 +1,122 @@
+package command
+
+import (
+	"errors"
+
+	"github.com/pivotal-cf/metric-registrar-cli/apps"
+	"github.com/pivotal-cf/metric-registrar-cli/cloudcontroller"
+	"github.com/pivotal-cf/metric-registrar-cli/ports"
+	"github.com/pivotal-cf/metric-registrar-cli/registrations"
+
+	"code.cloudfoundry.org/cli/plugin"
+)
+
+// newFetcher prefers the v3 API because v2 is being removed from
+// foundations, and falls back to v2 where v3 lacks the needed resources.
+func newFetcher(client *cloudcontroller.Client, conn plugin.CliConnection, caps cloudcontroller.Capabilities, opts ...registrations.Option) registrationFetcher {
+	required := []cloudcontroller.Feature{
+		cloudcontroller.ServiceInstances,
+		cloudcontroller.ServiceCredentialBindings,
+	}
+
+	if caps.Require(required...) == nil {
+		return registrations.NewV3Fetcher(client, conn, opts...)
+	}
+	if caps.V2 {
+		return registrations.NewFetcher(client, conn, opts...)
+	}
+	return unsupported{err: caps.Require(required...)}
+}
+
+// newPortManager prefers v2 app ports where they exist because they expose a
+// container port without mapping it on a route. Without v2 the port is mapped
+// on an internal route the plugin owns.
+// Opened ports are recorded in v3 app metadata.
+func newPortManager(client *cloudcontroller.Client, caps cloudcontroller.Capabilities) portManager {
+	var ownership portOwnership = unsupported{err: errPortOwnershipUnavailable}
+	if caps.Supports(cloudcontroller.Apps) {
+		ownership = ports.NewOwnership(client)
+	}
+
+	if caps.V2 {
+		return trackedPortManager{appPorts: ports.NewManager(client), portOwnership: ownership}
+	}
+	if caps.Supports(cloudcontroller.Routes) {
+		return trackedPortManager{appPorts: ports.NewV3Manager(client), portOwnership: ownership}
+	}
+	return unsupported{err: caps.Require(cloudcontroller.Routes)}
+}
+
+// newAppSelector needs v3 apps, labels only exist in the v3 API.
+func newAppSelector(client *cloudcontroller.Client, conn plugin.CliConnection, caps cloudcontroller.Capabilities) appSelector {
+	if caps.Supports(cloudcontroller.Apps) {
+		return apps.NewSelector(client, conn)
+	}
+	return unsupported{err: caps.Require(cloudcontroller.Apps)}
+}
+
+type appPorts interface {
+	GetPortsForApp(string) ([]int, error)
+	SetPortsForApp(string, []int) error
+}
+
+type portOwnership interface {
+	GetOpenedPortsForApp(string) ([]int, error)
+	SetOpenedPortsForApp(string, []int) error
+}
+
+// errPortOwnershipUnavailable means the opened ports can't be recorded
+// because the foundation has no v3 apps. Ports are then closed when their
+// registrations are removed, whoever opened them.
+var errPortOwnershipUnavailable = errors.New("the Cloud Controller can't record which ports the plugin opened")
+
+type trackedPortManager struct {
+	appPorts
+	portOwnership
+}
+
+// unsupported stands in for a backend the Cloud Controller can't provide so
+// that only the commands which need it fail.
+type unsupported struct {
+	err error
+}
+
+func (u unsupported) Fetch(string, string) ([]registrations.Registration, error) {
+	return nil, u.err
+}
+
+func (u unsupported) FetchAll(...string) (map[string][]registrations.Registration, error) {
+	return nil, u.err
+}
+
+func (u unsupported) FetchInstances(...string) ([]registrations.Registration, error) {
+	return nil, u.err
+}
+
+func (u unsupported) FetchUnbound(...string) ([]registrations.Registration, error) {
+	return nil, u.err
+}
+
+func (u unsupported) FetchUnparseable(...string) (map[string][]registrations.Registration, error) {
+	return nil, u.err
+}
+
+func (u unsupported) GetPortsForApp(string) ([]int, error) {
+	return nil, u.err
+}
+
+func (u unsupported) SetPortsForApp(string, []int) error {
+	return u.err
+}
+
+func (u unsupported) GetOpenedPortsForApp(string) ([]int, error) {
+	return nil, u.err
+}
+
+func (u unsupported) SetOpenedPortsForApp(string, []int) error {
+	return u.err
+}
+
+func (u unsupported) SelectApps(string) ([]string, error) {
+	return nil, u.err
+}
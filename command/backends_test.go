@@ -0,0 +1,107 @@
+package command_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/pivotal-cf/metric-registrar-cli/apps"
+	"github.com/pivotal-cf/metric-registrar-cli/cloudcontroller"
+	"github.com/pivotal-cf/metric-registrar-cli/command"
+	"github.com/pivotal-cf/metric-registrar-cli/ports"
+	"github.com/pivotal-cf/metric-registrar-cli/registrations"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Backends", func() {
+	var (
+		v2Only = foundation{v2: true}
+		mixed  = foundation{v2: true, v3: []cloudcontroller.Feature{
+			cloudcontroller.ServiceInstances, cloudcontroller.Apps, cloudcontroller.Routes,
+		}}
+		both = foundation{v2: true, v3: []cloudcontroller.Feature{
+			cloudcontroller.ServiceInstances, cloudcontroller.ServiceCredentialBindings, cloudcontroller.Apps, cloudcontroller.Routes,
+		}}
+		korifi = foundation{platform: "korifi", v3: []cloudcontroller.Feature{
+			cloudcontroller.ServiceInstances, cloudcontroller.ServiceCredentialBindings, cloudcontroller.Apps, cloudcontroller.Routes,
+		}}
+		v3Partial = foundation{v3: []cloudcontroller.Feature{cloudcontroller.ServiceInstances}}
+	)
+
+	DescribeTable("the registration fetcher", func(f foundation, expected interface{}) {
+		Expect(command.NewFetcher(nil, nil, f.capabilities())).To(BeAssignableToTypeOf(expected))
+	},
+		Entry("v2 only", v2Only, &registrations.Fetcher{}),
+		Entry("v3 without service credential bindings falls back to v2", mixed, &registrations.Fetcher{}),
+		Entry("v2 and v3 prefer v3", both, &registrations.V3Fetcher{}),
+		Entry("v3 only", korifi, &registrations.V3Fetcher{}),
+	)
+
+	DescribeTable("the port manager", func(f foundation, expected interface{}, ownership bool) {
+		pm := command.NewPortManager(nil, f.capabilities())
+		Expect(command.AppPorts(pm)).To(BeAssignableToTypeOf(expected))
+
+		if !ownership {
+			_, err := pm.GetOpenedPortsForApp("app-guid")
+			Expect(err).To(MatchError(command.ErrPortOwnershipUnavailable))
+		}
+	},
+		Entry("v2 only", v2Only, &ports.Manager{}, false),
+		Entry("v2 and v3 prefer v2 app ports", both, &ports.Manager{}, true),
+		Entry("v3 only", korifi, &ports.V3Manager{}, true),
+	)
+
+	DescribeTable("the app selector", func(f foundation, expected interface{}) {
+		Expect(command.NewAppSelector(nil, nil, f.capabilities())).To(BeAssignableToTypeOf(expected))
+	},
+		Entry("v2 and v3", both, &apps.Selector{}),
+		Entry("v3 only", korifi, &apps.Selector{}),
+	)
+
+	It("fails only when an unsupported backend is used", func() {
+		_, err := command.NewFetcher(nil, nil, v3Partial.capabilities()).Fetch("app-guid", "structured-format")
+		Expect(err).To(MatchError("the Cloud Controller (v3 3.117.0) does not support the v3 service_credential_bindings API"))
+
+		_, err = command.NewPortManager(nil, v3Partial.capabilities()).GetPortsForApp("app-guid")
+		Expect(err).To(MatchError("the Cloud Controller (v3 3.117.0) does not support the v3 routes API"))
+
+		_, err = command.NewAppSelector(nil, nil, v2Only.capabilities()).SelectApps("team=a")
+		Expect(err).To(MatchError("the Cloud Controller (v2 2.150.0) does not support the v3 apps API"))
+	})
+})
+
+// foundation describes the APIs a Cloud Controller offers.
+type foundation struct {
+	v2       bool
+	v3       []cloudcontroller.Feature
+	platform string
+}
+
+func (f foundation) capabilities() cloudcontroller.Capabilities {
+	caps, err := cloudcontroller.Probe(f)
+	Expect(err).ToNot(HaveOccurred())
+	return caps
+}
+
+// Get serves the root documents Probe reads.
+func (f foundation) Get(path string, v interface{}) error {
+	var links []string
+	switch path {
+	case "/":
+		if f.v2 {
+			links = append(links, `"cloud_controller_v2": {"meta": {"version": "2.150.0"}}`)
+		}
+		if len(f.v3) > 0 {
+			links = append(links, `"cloud_controller_v3": {"meta": {"version": "3.117.0"}}`)
+		}
+	case "/v3":
+		for _, feature := range f.v3 {
+			links = append(links, fmt.Sprintf(`"%s": {"href": "https://api.example.com/v3/%s"}`, feature, feature))
+		}
+	case "/v3/info":
+		return json.Unmarshal([]byte(fmt.Sprintf(`{"name": %q}`, f.platform)), v)
+	}
+	return json.Unmarshal([]byte(`{"links": {`+strings.Join(links, ", ")+`}}`), v)
+}
@@ -0,0 +1,462 @@
+package command
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/pivotal-cf/metric-registrar-cli/registrations"
+)
+
+// DefaultParallel is how many apps a batch works on at once.
+const DefaultParallel = 4
+
+const (
+	statusUnregistered    = "unregistered"
+	statusWouldUnregister = "would unregister"
+	statusFailed          = "failed"
+)
+
+// AppSelection is the apps a register or unregister command runs on: the
+// named apps, the apps listed in File one per line, the apps in the space
+// whose names match Glob or Regex, and the apps whose labels match
+// LabelSelector. Parallel bounds how many of them are processed at once.
+type AppSelection struct {
+	Names         []string
+	File          string
+	Glob          string
+	Regex         string
+	LabelSelector string
+	Parallel      int
+}
+
+// single reports whether exactly one app was named, which keeps the output
+// of a single registration as it has always been.
+func (s AppSelection) single() bool {
+	return len(s.Names) == 1 && s.File == "" && s.Glob == "" && s.Regex == "" && s.LabelSelector == ""
+}
+
+func RegisterLogFormatForApps(writer io.Writer, fetcher registrationFetcher, cliConn cliCommandRunner, selector appSelector, apps AppSelection, logFormat string, opts RegisterOptions) error {
+	if apps.single() {
+		return RegisterLogFormat(writer, fetcher, cliConn, apps.Names[0], logFormat, opts)
+	}
+
+	err := validateRegisterOutput(opts.Output)
+	if err != nil {
+		return err
+	}
+
+	return registerApps(writer, fetcher, cliConn, selector, apps, opts, func(w io.Writer, fetcher registrationFetcher, appName string) (registerResult, error) {
+		return registerLogFormat(w, fetcher, connPrintingTo(w, cliConn), appName, logFormat)
+	})
+}
+
+func RegisterMetricsEndpointForApps(writer io.Writer, fetcher registrationFetcher, cliConn cliCommandRunner, selector appSelector, portManager portManager, apps AppSelection, route, internalPort string, insecure bool, opts RegisterOptions) error {
+	if apps.single() {
+		return RegisterMetricsEndpoint(writer, fetcher, cliConn, portManager, apps.Names[0], route, internalPort, insecure, opts)
+	}
+
+	err := validateMetricsEndpointFlags(internalPort, insecure, opts)
+	if err != nil {
+		return err
+	}
+
+	return registerApps(writer, fetcher, cliConn, selector, apps, opts, func(w io.Writer, fetcher registrationFetcher, appName string) (registerResult, error) {
+		return registerMetricsEndpoint(w, fetcher, connPrintingTo(w, cliConn), portsPrintingTo(w, portManager), appName, route, internalPort, insecure)
+	})
+}
+
+func UnregisterLogFormatForApps(writer io.Writer, fetcher registrationFetcher, cliConn cliCommandRunner, selector appSelector, apps AppSelection, format string, opts UnregisterOptions) error {
+	if apps.single() {
+		return UnregisterLogFormat(writer, fetcher, cliConn, apps.Names[0], format, opts)
+	}
+
+	return unregisterApps(writer, fetcher, cliConn, selector, apps, func(w io.Writer, fetcher registrationFetcher, appName string) error {
+		return UnregisterLogFormat(w, fetcher, connPrintingTo(w, cliConn), appName, format, opts)
+	})
+}
+
+func UnregisterMetricsEndpointForApps(writer io.Writer, fetcher registrationFetcher, cliConn cliCommandRunner, selector appSelector, portManager portManager, apps AppSelection, path, port string, opts UnregisterOptions) error {
+	if apps.single() {
+		return UnregisterMetricsEndpoint(writer, fetcher, cliConn, portManager, apps.Names[0], path, port, opts)
+	}
+
+	return unregisterApps(writer, fetcher, cliConn, selector, apps, func(w io.Writer, fetcher registrationFetcher, appName string) error {
+		return UnregisterMetricsEndpoint(w, fetcher, connPrintingTo(w, cliConn), portsPrintingTo(w, portManager), appName, path, port, opts)
+	})
+}
+
+func registerApps(writer io.Writer, fetcher registrationFetcher, cliConn cliCommandRunner, selector appSelector, apps AppSelection, opts RegisterOptions, register func(io.Writer, registrationFetcher, string) (registerResult, error)) error {
+	names, err := selectApps(cliConn, selector, apps)
+	if err != nil {
+		return err
+	}
+
+	results := make([]registerResult, len(names))
+	outcomes := runForApps(names, apps.Parallel, newSnapshotFetcher(fetcher), func(w io.Writer, fetcher registrationFetcher, appName string) (string, error) {
+		result, err := register(w, fetcher, appName)
+		if err != nil {
+			result = registerResult{AppName: appName, Status: statusFailed, Error: err.Error()}
+		}
+		results[indexOf(names, appName)] = result
+		return result.Status, err
+	})
+
+	// the apps' own output would make the JSON unreadable, their errors are
+	// in the results
+	if opts.Output == "json" {
+		e := json.NewEncoder(writer)
+		e.SetIndent("", "  ")
+		err := e.Encode(results)
+		if err != nil {
+			return err
+		}
+		return batchError(outcomes)
+	}
+	return writeAppResults(writer, outcomes)
+}
+
+func unregisterApps(writer io.Writer, fetcher registrationFetcher, cliConn cliCommandRunner, selector appSelector, apps AppSelection, unregister func(io.Writer, registrationFetcher, string) error) error {
+	names, err := selectApps(cliConn, selector, apps)
+	if err != nil {
+		return err
+	}
+
+	outcomes := runForApps(names, apps.Parallel, newSnapshotFetcher(fetcher), func(w io.Writer, fetcher registrationFetcher, appName string) (string, error) {
+		err := unregister(w, fetcher, appName)
+		if err != nil {
+			return statusFailed, err
+		}
+		if isDryRun(cliConn) {
+			return statusWouldUnregister, nil
+		}
+		return statusUnregistered, nil
+	})
+	return writeAppResults(writer, outcomes)
+}
+
+// selectApps returns the names of the selected apps in the order they were
+// given, without duplicates.
+func selectApps(cliConn cliCommandRunner, selector appSelector, apps AppSelection) ([]string, error) {
+	if apps.Parallel < 1 {
+		return nil, fmt.Errorf("--parallel must be at least 1")
+	}
+
+	names := append([]string{}, apps.Names...)
+	if apps.File != "" {
+		fromFile, err := readAppsFile(apps.File)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, fromFile...)
+	}
+
+	if apps.Glob != "" || apps.Regex != "" {
+		matched, err := matchApps(cliConn, apps.Glob, apps.Regex)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, matched...)
+	}
+
+	if apps.LabelSelector != "" {
+		labelled, err := selector.SelectApps(apps.LabelSelector)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, labelled...)
+	}
+
+	seen := map[string]bool{}
+	var selected []string
+	for _, name := range names {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		selected = append(selected, name)
+	}
+
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("no apps selected")
+	}
+	return selected, nil
+}
+
+// readAppsFile reads one app name per line, skipping blank lines and
+// comments starting with #.
+func readAppsFile(file string) ([]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var names []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		names = append(names, line)
+	}
+	return names, scanner.Err()
+}
+
+func matchApps(cliConn cliCommandRunner, glob, regex string) ([]string, error) {
+	if glob != "" {
+		if _, err := path.Match(glob, ""); err != nil {
+			return nil, fmt.Errorf("invalid --app-glob %q: %s", glob, err)
+		}
+	}
+
+	var re *regexp.Regexp
+	if regex != "" {
+		var err error
+		re, err = regexp.Compile(regex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --app-regex %q: %s", regex, err)
+		}
+	}
+
+	apps, err := cliConn.GetApps()
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, app := range apps {
+		globMatch, _ := path.Match(glob, app.Name)
+		if (glob != "" && globMatch) || (re != nil && re.MatchString(app.Name)) {
+			names = append(names, app.Name)
+		}
+	}
+	return names, nil
+}
+
+// appOutcome is what happened to one app of a batch. Output holds what
+// processing the app printed, which is kept apart from the other apps'.
+type appOutcome struct {
+	appName string
+	status  string
+	err     error
+	output  bytes.Buffer
+}
+
+// runForApps runs fn for every app, at most parallel at a time, and returns
+// the outcomes in the order of the apps.
+func runForApps(appNames []string, parallel int, fetcher registrationFetcher, fn func(io.Writer, registrationFetcher, string) (string, error)) []*appOutcome {
+	outcomes := make([]*appOutcome, len(appNames))
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+
+	for i, appName := range appNames {
+		outcome := &appOutcome{appName: appName}
+		outcomes[i] = outcome
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			outcome.status, outcome.err = fn(&outcome.output, fetcher, outcome.appName)
+		}()
+	}
+	wg.Wait()
+
+	return outcomes
+}
+
+// writeAppResults prints what each app printed followed by a summary of
+// every app, and fails if any app did.
+func writeAppResults(writer io.Writer, outcomes []*appOutcome) error {
+	err := writeAppOutput(writer, outcomes)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(writer, 0, 8, 2, ' ', tabwriter.StripEscape)
+	writeFields(w, "App", "Result") //nolint:errcheck
+	for _, o := range outcomes {
+		result := o.status
+		if o.err != nil {
+			result = "failed: " + o.err.Error()
+		}
+		writeFields(w, o.appName, result) //nolint:errcheck
+	}
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+
+	return batchError(outcomes)
+}
+
+func writeAppOutput(writer io.Writer, outcomes []*appOutcome) error {
+	for _, o := range outcomes {
+		if o.output.Len() == 0 {
+			continue
+		}
+		_, err := fmt.Fprintf(writer, "%s:\n%s", o.appName, o.output.String())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func batchError(outcomes []*appOutcome) error {
+	failed := 0
+	for _, o := range outcomes {
+		if o.err != nil {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d apps failed", failed, len(outcomes))
+	}
+	return nil
+}
+
+func indexOf(names []string, name string) int {
+	for i, n := range names {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// batchSnapshot is implemented by fetchers shared by the apps of a batch.
+// Registering and unregistering report their changes to it so that the apps
+// see each other's services without fetching the space again.
+type batchSnapshot interface {
+	// lockServices must be held while looking up and creating a service so
+	// that two apps registering the same drain don't both create it.
+	lockServices() (unlock func())
+	serviceNames(cliConn cliCommandRunner) (serviceNames, error)
+	// unbound records that an app was unbound from the registration's
+	// service and returns how many apps are still bound to it.
+	unbound(registration registrations.Registration) int
+	// use records that an app of the batch is binding to the service,
+	// release that it no longer is. release reports whether the service
+	// was created by the batch and no app uses it anymore. Both must be
+	// called with the services lock held.
+	use(serviceName string, created bool)
+	release(serviceName string) bool
+}
+
+// snapshotFetcher answers the lookups of a batch from a single fetch of
+// the space.
+type snapshotFetcher struct {
+	registrationFetcher
+
+	services sync.Mutex
+
+	mu       sync.Mutex
+	byApp    map[string][]registrations.Registration
+	bindings map[string]int
+	names    *serviceNames
+
+	users   map[string]int
+	created map[string]bool
+}
+
+func newSnapshotFetcher(fetcher registrationFetcher) *snapshotFetcher {
+	return &snapshotFetcher{
+		registrationFetcher: fetcher,
+		bindings:            map[string]int{},
+		users:               map[string]int{},
+		created:             map[string]bool{},
+	}
+}
+
+func (f *snapshotFetcher) Fetch(appGuid, registrationType string) ([]registrations.Registration, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	err := f.load()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []registrations.Registration
+	for _, r := range f.byApp[appGuid] {
+		if r.Type == registrationType {
+			r.NumberOfBindings = f.bindings[r.Name]
+			result = append(result, r)
+		}
+	}
+	return result, nil
+}
+
+func (f *snapshotFetcher) load() error {
+	if f.byApp != nil {
+		return nil
+	}
+
+	byApp, err := f.registrationFetcher.FetchAll(structuredFormat, metricsEndpoint, secureEndpoint)
+	if err != nil {
+		return err
+	}
+
+	for _, regs := range byApp {
+		for _, r := range regs {
+			f.bindings[r.Name] = r.NumberOfBindings
+		}
+	}
+	f.byApp = byApp
+	return nil
+}
+
+func (f *snapshotFetcher) lockServices() func() {
+	f.services.Lock()
+	return f.services.Unlock
+}
+
+func (f *snapshotFetcher) serviceNames(cliConn cliCommandRunner) (serviceNames, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.names == nil {
+		names, err := loadServiceNames(cliConn, f.registrationFetcher, structuredFormat, metricsEndpoint, secureEndpoint)
+		if err != nil {
+			return serviceNames{}, err
+		}
+		f.names = &names
+	}
+	return *f.names, nil
+}
+
+func (f *snapshotFetcher) unbound(registration registrations.Registration) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	bindings, ok := f.bindings[registration.Name]
+	if !ok {
+		bindings = registration.NumberOfBindings
+	}
+	f.bindings[registration.Name] = bindings - 1
+	return bindings - 1
+}
+
+func (f *snapshotFetcher) use(serviceName string, created bool) {
+	f.users[serviceName]++
+	if created {
+		f.created[serviceName] = true
+	}
+}
+
+func (f *snapshotFetcher) release(serviceName string) bool {
+	f.users[serviceName]--
+	return f.users[serviceName] <= 0 && f.created[serviceName]
+}
@@ -0,0 +1,336 @@
+package command_test
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+
+	plugin_models "code.cloudfoundry.org/cli/plugin/models"
+	"github.com/pivotal-cf/metric-registrar-cli/command"
+	"github.com/pivotal-cf/metric-registrar-cli/registrations"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Batches", func() {
+	const jsonService = "structured-format-json-09cae105ae65ef6b"
+
+	var (
+		writer              *spyWriter
+		registrationFetcher *mockRegistrationFetcher
+		cliConn             *mockCliConnection
+		selector            *mockAppSelector
+		apps                command.AppSelection
+	)
+
+	BeforeEach(func() {
+		writer = newSpyWriter()
+		registrationFetcher = newMockRegistrationFetcher()
+		cliConn = newMockCliConnection()
+		cliConn.getAppResults = map[string]plugin_models.GetAppModel{
+			"app-a": {Name: "app-a", Guid: "a-guid"},
+			"app-b": {Name: "app-b", Guid: "b-guid"},
+			"app-c": {Name: "app-c", Guid: "c-guid"},
+		}
+		cliConn.getAppsResult = []plugin_models.GetAppsModel{
+			{Name: "app-a"}, {Name: "app-b"}, {Name: "app-c"}, {Name: "other"},
+		}
+		selector = newMockAppSelector()
+		apps = command.AppSelection{Names: []string{"app-a", "app-b"}, Parallel: 1}
+	})
+
+	Context("RegisterLogFormatForApps", func() {
+		It("registers every app with a single service", func() {
+			err := command.RegisterLogFormatForApps(writer, registrationFetcher, cliConn, selector, apps, "json", command.RegisterOptions{})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(cliConn.cliCommandsCalled).To(Receive(Equal([]string{"create-user-provided-service", jsonService, "-l", "structured-format://json"})))
+			Expect(cliConn.cliCommandsCalled).To(Receive(Equal([]string{"bind-service", "app-a", jsonService})))
+			Expect(cliConn.cliCommandsCalled).To(Receive(Equal([]string{"bind-service", "app-b", jsonService})))
+			Expect(cliConn.cliCommandsCalled).ToNot(Receive())
+			Expect(writer.lines()).To(Equal([]string{
+				"App    Result",
+				"app-a  registered",
+				"app-b  registered",
+				"",
+			}))
+		})
+
+		It("works on several apps at once", func() {
+			apps.Names = append(apps.Names, "app-c")
+			apps.Parallel = 3
+
+			err := command.RegisterLogFormatForApps(writer, registrationFetcher, cliConn, selector, apps, "json", command.RegisterOptions{})
+			Expect(err).ToNot(HaveOccurred())
+
+			var called [][]string
+			for len(cliConn.cliCommandsCalled) > 0 {
+				called = append(called, <-cliConn.cliCommandsCalled)
+			}
+			Expect(called).To(ConsistOf(
+				[]string{"create-user-provided-service", jsonService, "-l", "structured-format://json"},
+				[]string{"bind-service", "app-a", jsonService},
+				[]string{"bind-service", "app-b", jsonService},
+				[]string{"bind-service", "app-c", jsonService},
+			))
+		})
+
+		It("skips apps that are already registered", func() {
+			existing := registrations.Registration{Name: "json-service", Type: "structured-format", Config: "json", NumberOfBindings: 1}
+			registrationFetcher.registrations["b-guid"] = []registrations.Registration{existing}
+			registrationFetcher.instances = []registrations.Registration{existing}
+
+			err := command.RegisterLogFormatForApps(writer, registrationFetcher, cliConn, selector, apps, "json", command.RegisterOptions{})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(cliConn.cliCommandsCalled).To(Receive(Equal([]string{"bind-service", "app-a", "json-service"})))
+			Expect(cliConn.cliCommandsCalled).ToNot(Receive())
+			Expect(writer.lines()).To(ContainElement("app-b  already registered"))
+		})
+
+		It("continues after an app fails and reports it", func() {
+			apps.Names = []string{"app-a", "missing", "app-b"}
+
+			err := command.RegisterLogFormatForApps(writer, registrationFetcher, cliConn, selector, apps, "json", command.RegisterOptions{})
+			Expect(err).To(MatchError("1 of 3 apps failed"))
+
+			Expect(writer.lines()).To(Equal([]string{
+				"App      Result",
+				"app-a    registered",
+				"missing  failed: App missing not found",
+				"app-b    registered",
+				"",
+			}))
+		})
+
+		It("prints what each app's rollback did", func() {
+			cliConn.cliErrorCommand = "bind-service"
+
+			err := command.RegisterLogFormatForApps(writer, registrationFetcher, cliConn, selector, apps, "json", command.RegisterOptions{})
+			Expect(err).To(MatchError("2 of 2 apps failed"))
+
+			Expect(writer.lines()).To(Equal([]string{
+				"app-a:",
+				"Registering app-a failed, rolling back:",
+				"  delete service " + jsonService,
+				"app-b:",
+				"Registering app-b failed, rolling back:",
+				"  delete service " + jsonService,
+				"App    Result",
+				"app-a  failed: error",
+				"app-b  failed: error",
+				"",
+			}))
+		})
+
+		It("prints what a dry run would do under each app", func() {
+			registrationFetcher.instances = []registrations.Registration{
+				{Name: jsonService, Type: "structured-format", Config: "json"},
+			}
+			apps.Parallel = 2
+			dryRunWriter := newSpyWriter()
+
+			err := command.RegisterLogFormatForApps(writer, registrationFetcher, newDryRunConnection(cliConn, dryRunWriter), selector, apps, "json", command.RegisterOptions{})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(dryRunWriter.lines()).To(Equal([]string{""}))
+			Expect(writer.lines()).To(Equal([]string{
+				"app-a:",
+				"would run: cf bind-service app-a " + jsonService,
+				"app-b:",
+				"would run: cf bind-service app-b " + jsonService,
+				"App    Result",
+				"app-a  would register",
+				"app-b  would register",
+				"",
+			}))
+		})
+
+		Context("when both apps bind the service created for the batch", func() {
+			var failing map[string]bool
+
+			commands := func() [][]string {
+				var called [][]string
+				for len(cliConn.cliCommandsCalled) > 0 {
+					called = append(called, <-cliConn.cliCommandsCalled)
+				}
+				return called
+			}
+
+			BeforeEach(func() {
+				apps.Parallel = 2
+				failing = map[string]bool{}
+
+				// both apps have looked up the service before either bind
+				// returns, so one of them reused the service the other created
+				var bound sync.WaitGroup
+				bound.Add(2)
+				cliConn.cliCommandHook = func(args []string) error {
+					if args[0] != "bind-service" {
+						return nil
+					}
+					bound.Done()
+					bound.Wait()
+					if failing[args[1]] {
+						return errors.New("bind failed")
+					}
+					return nil
+				}
+			})
+
+			It("keeps the service if another app still uses it", func() {
+				failing["app-a"] = true
+
+				err := command.RegisterLogFormatForApps(writer, registrationFetcher, cliConn, selector, apps, "json", command.RegisterOptions{})
+				Expect(err).To(MatchError("1 of 2 apps failed"))
+
+				Expect(commands()).ToNot(ContainElement(ContainElement("delete-service")))
+				Expect(writer.lines()).To(ContainElement("app-a  failed: bind failed"))
+				Expect(writer.lines()).To(ContainElement("app-b  registered"))
+			})
+
+			It("deletes the service once no app of the batch uses it", func() {
+				failing["app-a"] = true
+				failing["app-b"] = true
+
+				err := command.RegisterLogFormatForApps(writer, registrationFetcher, cliConn, selector, apps, "json", command.RegisterOptions{})
+				Expect(err).To(MatchError("2 of 2 apps failed"))
+
+				var deleted [][]string
+				for _, c := range commands() {
+					if c[0] == "delete-service" {
+						deleted = append(deleted, c)
+					}
+				}
+				Expect(deleted).To(Equal([][]string{{"delete-service", jsonService, "-f"}}))
+			})
+		})
+
+		It("prints every app's result as JSON", func() {
+			apps.Names = []string{"app-a", "missing"}
+
+			err := command.RegisterLogFormatForApps(writer, registrationFetcher, cliConn, selector, apps, "json", command.RegisterOptions{Output: "json"})
+			Expect(err).To(MatchError("1 of 2 apps failed"))
+
+			var results []map[string]string
+			Expect(json.Unmarshal(writer.bytes, &results)).To(Succeed())
+			Expect(results).To(Equal([]map[string]string{
+				{"app_name": "app-a", "service_name": jsonService, "type": "structured-format", "config": "json", "status": "registered"},
+				{"app_name": "missing", "service_name": "", "type": "", "config": "", "status": "failed", "error": "App missing not found"},
+			}))
+		})
+
+		It("keeps the output of a single app", func() {
+			cliConn.getAppResults = nil
+			apps.Names = []string{"app-name"}
+
+			err := command.RegisterLogFormatForApps(writer, registrationFetcher, cliConn, selector, apps, "json", command.RegisterOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(writer.lines()).To(Equal([]string{""}))
+		})
+	})
+
+	Context("UnregisterLogFormatForApps", func() {
+		It("deletes a shared service once the last app is unbound", func() {
+			shared := registrations.Registration{Name: "json-service", Type: "structured-format", Config: "json", NumberOfBindings: 2}
+			registrationFetcher.registrations["a-guid"] = []registrations.Registration{shared}
+			registrationFetcher.registrations["b-guid"] = []registrations.Registration{shared}
+
+			err := command.UnregisterLogFormatForApps(writer, registrationFetcher, cliConn, selector, apps, "", command.UnregisterOptions{})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(cliConn.cliCommandsCalled).To(Receive(Equal([]string{"unbind-service", "app-a", "json-service"})))
+			Expect(cliConn.cliCommandsCalled).To(Receive(Equal([]string{"unbind-service", "app-b", "json-service"})))
+			Expect(cliConn.cliCommandsCalled).To(Receive(Equal([]string{"delete-service", "json-service", "-f"})))
+			Expect(writer.lines()).To(Equal([]string{
+				"App    Result",
+				"app-a  unregistered",
+				"app-b  unregistered",
+				"",
+			}))
+		})
+	})
+
+	Context("selecting apps", func() {
+		It("adds the apps from a file and matching a glob or regex once each", func() {
+			appsFile := filepath.Join(GinkgoT().TempDir(), "apps")
+			Expect(os.WriteFile(appsFile, []byte("# billing\napp-b\n\n  app-a  \n"), 0600)).To(Succeed())
+
+			apps = command.AppSelection{Names: []string{"app-c"}, File: appsFile, Glob: "app-[ab]", Regex: "^app-c$", Parallel: 1}
+			err := command.UnregisterLogFormatForApps(writer, registrationFetcher, cliConn, selector, apps, "", command.UnregisterOptions{})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(writer.lines()).To(Equal([]string{
+				"App    Result",
+				"app-c  unregistered",
+				"app-b  unregistered",
+				"app-a  unregistered",
+				"",
+			}))
+		})
+
+		It("adds the apps whose labels match the selector", func() {
+			selector.names = []string{"app-b", "app-c"}
+			apps = command.AppSelection{Names: []string{"app-a"}, LabelSelector: "team=payments", Parallel: 1}
+
+			err := command.RegisterLogFormatForApps(writer, registrationFetcher, cliConn, selector, apps, "json", command.RegisterOptions{})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(selector.selectors).To(Receive(Equal("team=payments")))
+			Expect(writer.lines()).To(Equal([]string{
+				"App    Result",
+				"app-a  registered",
+				"app-b  registered",
+				"app-c  registered",
+				"",
+			}))
+		})
+
+		It("returns an error if selecting apps by label fails", func() {
+			selector.err = errors.New("expected")
+			apps.LabelSelector = "team=payments"
+
+			err := command.UnregisterLogFormatForApps(writer, registrationFetcher, cliConn, selector, apps, "", command.UnregisterOptions{})
+			Expect(err).To(MatchError("expected"))
+			Expect(cliConn.cliCommandsCalled).ToNot(Receive())
+		})
+
+		It("returns an error if no apps are selected", func() {
+			apps = command.AppSelection{Glob: "nothing-*", Parallel: 1}
+
+			err := command.UnregisterLogFormatForApps(writer, registrationFetcher, cliConn, selector, apps, "", command.UnregisterOptions{})
+			Expect(err).To(MatchError("no apps selected"))
+		})
+
+		It("returns an error for an invalid regex", func() {
+			apps.Regex = "("
+
+			err := command.UnregisterLogFormatForApps(writer, registrationFetcher, cliConn, selector, apps, "", command.UnregisterOptions{})
+			Expect(err).To(MatchError(HavePrefix(`invalid --app-regex "("`)))
+		})
+
+		It("returns an error for an invalid glob", func() {
+			apps.Glob = "["
+
+			err := command.UnregisterLogFormatForApps(writer, registrationFetcher, cliConn, selector, apps, "", command.UnregisterOptions{})
+			Expect(err).To(MatchError(HavePrefix(`invalid --app-glob "["`)))
+		})
+
+		It("returns an error if the apps file can't be read", func() {
+			apps.File = filepath.Join(GinkgoT().TempDir(), "missing")
+
+			err := command.RegisterLogFormatForApps(writer, registrationFetcher, cliConn, selector, apps, "json", command.RegisterOptions{})
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("requires at least one app at a time", func() {
+			apps.Parallel = 0
+
+			err := command.RegisterLogFormatForApps(writer, registrationFetcher, cliConn, selector, apps, "json", command.RegisterOptions{})
+			Expect(err).To(MatchError("--parallel must be at least 1"))
+		})
+	})
+})
@@ -0,0 +1,104 @@
+package command
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pivotal-cf/metric-registrar-cli/registrations"
+)
+
+// CleanupOptions control which orphaned services are deleted. Without Force
+// the user is asked to confirm first. MinAge skips services created more
+// recently, as well as those whose age is unknown.
+type CleanupOptions struct {
+	Force  bool
+	MinAge time.Duration
+}
+
+// CleanupOrphans deletes the registration services in the space that no app
+// is bound to, which is what deleting an app leaves behind.
+func CleanupOrphans(writer io.Writer, reader io.Reader, fetcher registrationFetcher, cliConn cliCommandRunner, opts CleanupOptions) error {
+	unbound, err := fetcher.FetchUnbound(structuredFormat, metricsEndpoint, secureEndpoint)
+	if err != nil {
+		return err
+	}
+
+	var orphans []registrations.Registration
+	for _, r := range unbound {
+		if opts.MinAge > 0 && (r.CreatedAt.IsZero() || time.Since(r.CreatedAt) < opts.MinAge) {
+			continue
+		}
+		orphans = append(orphans, r)
+	}
+	sort.SliceStable(orphans, func(i, j int) bool { return orphans[i].Name < orphans[j].Name })
+
+	if len(orphans) == 0 {
+		_, err := fmt.Fprintln(writer, "No orphaned services found.")
+		return err
+	}
+
+	err = writeOrphans(writer, orphans)
+	if err != nil {
+		return err
+	}
+
+	if !opts.Force {
+		confirmed, err := confirm(writer, reader, fmt.Sprintf("Delete %d services?", len(orphans)))
+		if err != nil {
+			return err
+		}
+		if !confirmed {
+			_, err := fmt.Fprintln(writer, "Nothing deleted.")
+			return err
+		}
+	}
+
+	for _, r := range orphans {
+		_, err := cliConn.CliCommandWithoutTerminalOutput("delete-service", r.Name, "-f")
+		if err != nil {
+			return err
+		}
+	}
+
+	summary := "Deleted %d services.\n"
+	if isDryRun(cliConn) {
+		summary = "Would delete %d services.\n"
+	}
+	_, err = fmt.Fprintf(writer, summary, len(orphans))
+	return err
+}
+
+func writeOrphans(writer io.Writer, orphans []registrations.Registration) error {
+	w := tabwriter.NewWriter(writer, 0, 8, 2, ' ', tabwriter.StripEscape)
+	writeFields(w, "Service", "Drain URL", "Created") //nolint:errcheck
+	for _, r := range orphans {
+		created := "unknown"
+		if !r.CreatedAt.IsZero() {
+			created = r.CreatedAt.Format(time.RFC3339)
+		}
+		writeFields(w, r.Name, r.Type+"://"+r.Config, created) //nolint:errcheck
+	}
+	return w.Flush()
+}
+
+// confirm asks a yes/no question. Anything but yes, including no input,
+// is a no.
+func confirm(writer io.Writer, reader io.Reader, question string) (bool, error) {
+	_, err := fmt.Fprintf(writer, "%s [y/N]: ", question)
+	if err != nil {
+		return false, err
+	}
+
+	answer, err := bufio.NewReader(reader).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes", nil
+}
@@ -0,0 +1,99 @@
+package command_test
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/pivotal-cf/metric-registrar-cli/command"
+	"github.com/pivotal-cf/metric-registrar-cli/registrations"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CleanupOrphans", func() {
+	var (
+		writer              *spyWriter
+		registrationFetcher *mockRegistrationFetcher
+		cliConn             *mockCliConnection
+		created             time.Time
+	)
+
+	BeforeEach(func() {
+		writer = newSpyWriter()
+		created = time.Now().Add(-48 * time.Hour).UTC().Truncate(time.Second)
+		registrationFetcher = newMockRegistrationFetcher()
+		registrationFetcher.unbound = []registrations.Registration{
+			{Name: "secure-endpoint-2112-metrics", Type: "secure-endpoint", Config: ":2112/metrics", CreatedAt: created},
+			{Name: "structured-format-json", Type: "structured-format", Config: "json"},
+		}
+		cliConn = newMockCliConnection()
+	})
+
+	It("lists the orphans and deletes them once confirmed", func() {
+		err := command.CleanupOrphans(writer, strings.NewReader("y\n"), registrationFetcher, cliConn, command.CleanupOptions{})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(cliConn.cliCommandsCalled).To(Receive(Equal([]string{"delete-service", "secure-endpoint-2112-metrics", "-f"})))
+		Expect(cliConn.cliCommandsCalled).To(Receive(Equal([]string{"delete-service", "structured-format-json", "-f"})))
+		Expect(writer.lines()).To(Equal([]string{
+			"Service                       Drain URL                        Created",
+			"secure-endpoint-2112-metrics  secure-endpoint://:2112/metrics  " + created.Format(time.RFC3339),
+			"structured-format-json        structured-format://json         unknown",
+			"Delete 2 services? [y/N]: Deleted 2 services.",
+			"",
+		}))
+	})
+
+	It("deletes nothing unless confirmed", func() {
+		err := command.CleanupOrphans(writer, strings.NewReader("\n"), registrationFetcher, cliConn, command.CleanupOptions{})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(cliConn.cliCommandsCalled).ToNot(Receive())
+		Expect(writer.lines()).To(ContainElement("Delete 2 services? [y/N]: Nothing deleted."))
+	})
+
+	It("doesn't ask with --force", func() {
+		err := command.CleanupOrphans(writer, strings.NewReader(""), registrationFetcher, cliConn, command.CleanupOptions{Force: true})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(cliConn.cliCommandsCalled).To(Receive())
+		Expect(cliConn.cliCommandsCalled).To(Receive())
+		Expect(writer.lines()).ToNot(ContainElement(ContainSubstring("[y/N]")))
+	})
+
+	It("only deletes services older than the minimum age", func() {
+		registrationFetcher.unbound = append(registrationFetcher.unbound, registrations.Registration{
+			Name: "metrics-endpoint-metrics", Type: "metrics-endpoint", Config: "/metrics", CreatedAt: time.Now().Add(-time.Hour),
+		})
+
+		err := command.CleanupOrphans(writer, nil, registrationFetcher, cliConn, command.CleanupOptions{Force: true, MinAge: 24 * time.Hour})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(cliConn.cliCommandsCalled).To(Receive(Equal([]string{"delete-service", "secure-endpoint-2112-metrics", "-f"})))
+		Expect(cliConn.cliCommandsCalled).ToNot(Receive())
+	})
+
+	It("reports when there are no orphans", func() {
+		registrationFetcher.unbound = nil
+
+		Expect(command.CleanupOrphans(writer, nil, registrationFetcher, cliConn, command.CleanupOptions{})).To(Succeed())
+		Expect(writer.lines()).To(Equal([]string{"No orphaned services found.", ""}))
+	})
+
+	It("stops at the first failed deletion", func() {
+		cliConn.cliErrorCommand = "delete-service"
+
+		err := command.CleanupOrphans(writer, nil, registrationFetcher, cliConn, command.CleanupOptions{Force: true})
+		Expect(err).To(MatchError("error"))
+		Expect(cliConn.cliCommandsCalled).To(Receive())
+		Expect(cliConn.cliCommandsCalled).ToNot(Receive())
+	})
+
+	It("returns an error if fetching fails", func() {
+		registrationFetcher.fetchError = errors.New("expected")
+
+		Expect(command.CleanupOrphans(writer, nil, registrationFetcher, cliConn, command.CleanupOptions{})).To(MatchError("expected"))
+	})
+})
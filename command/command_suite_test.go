@@ -3,9 +3,9 @@ package command_test
 import (
 	"errors"
 	"fmt"
-	"strings"
 	"testing"
 
+	"code.cloudfoundry.org/cli/plugin"
 	plugin_models "code.cloudfoundry.org/cli/plugin/models"
 	"github.com/pivotal-cf/metric-registrar-cli/registrations"
 
@@ -21,19 +21,20 @@ func TestCommand(t *testing.T) {
 type mockCliConnection struct {
 	cliCommandsCalled chan []string
 	cliErrorCommand   string
+	// cliCommandHook, when set, runs before each command and fails it by
+	// returning an error
+	cliCommandHook func(args []string) error
 
 	getServicesResult []plugin_models.GetServices_Model
 	getServicesError  error
 
 	getAppResult plugin_models.GetAppModel
 	getAppError  error
+	// getAppResults, when set, are the only apps GetApp finds
+	getAppResults map[string]plugin_models.GetAppModel
 
 	getAppsResult []plugin_models.GetAppsModel
 	getAppsError  error
-
-	exposedPorts     []int
-	getAppsInfoError error
-	putAppsInfoError error
 }
 
 func (c *mockCliConnection) GetServices() ([]plugin_models.GetServices_Model, error) {
@@ -54,32 +55,31 @@ func newMockCliConnection() *mockCliConnection {
 				Path: "app-path",
 			}},
 		},
-		exposedPorts: []int{8080},
 	}
 }
 
 func (c *mockCliConnection) CliCommandWithoutTerminalOutput(args ...string) ([]string, error) {
 	c.cliCommandsCalled <- args
 
-	// curl /v2/apps
-	if args[0] == "curl" && strings.Contains(args[1], "/v2/apps/") {
-		response := getFakeAppsInfoResponse(c.exposedPorts)
-		output := strings.Split(response, "\n")
-
-		if len(args) == 2 {
-			return output, c.getAppsInfoError
-		} else {
-			return output, c.putAppsInfoError
+	if c.cliCommandHook != nil {
+		if err := c.cliCommandHook(args); err != nil {
+			return nil, err
 		}
 	}
-
 	if args[0] == c.cliErrorCommand {
 		return nil, errors.New("error")
 	}
 	return nil, nil
 }
 
-func (c *mockCliConnection) GetApp(string) (plugin_models.GetAppModel, error) {
+func (c *mockCliConnection) GetApp(name string) (plugin_models.GetAppModel, error) {
+	if c.getAppResults != nil {
+		app, ok := c.getAppResults[name]
+		if !ok {
+			return plugin_models.GetAppModel{}, fmt.Errorf("App %s not found", name)
+		}
+		return app, nil
+	}
 	return c.getAppResult, c.getAppError
 }
 
@@ -89,6 +89,9 @@ func (c *mockCliConnection) GetApps() ([]plugin_models.GetAppsModel, error) {
 
 type mockRegistrationFetcher struct {
 	registrations map[string][]registrations.Registration
+	instances     []registrations.Registration
+	unbound       []registrations.Registration
+	unparseable   map[string][]registrations.Registration
 	fetchError    error
 }
 
@@ -125,60 +128,120 @@ func (f *mockRegistrationFetcher) FetchAll(registrationType ...string) (map[stri
 	return result, f.fetchError
 }
 
-func getFakeAppsInfoResponse(ports []int) string {
-	portsString := strings.Replace(fmt.Sprint(ports), " ", ",", -1)
-	return fmt.Sprintf(fakeAppsInfoResponseTemplate, portsString)
-}
-
-const (
-	fakeAppsInfoResponseTemplate = `{
-   "metadata": {
-      "guid": "419aa316-c70b-40f9-b38b-c99e693e7620",
-      "url": "/v2/apps/419aa316-c70b-40f9-b38b-c99e693e7620",
-      "created_at": "2020-07-21T22:09:59Z",
-      "updated_at": "2020-07-21T22:17:30Z"
-   },
-   "entity": {
-      "name": "go-app-with-metrics",
-      "production": false,
-      "space_guid": "eefee588-c08a-4fde-b0e3-8b2a6309e365",
-      "stack_guid": "a3fd69f2-b350-4515-86a9-7fab406e8544",
-      "buildpack": null,
-      "detected_buildpack": "go",
-      "detected_buildpack_guid": "dc517d4d-694b-4e8a-a014-42b5a55b9d9f",
-      "environment_json": {},
-      "memory": 1024,
-      "instances": 2,
-      "disk_quota": 1024,
-      "state": "STARTED",
-      "version": "bb68c933-3ede-452c-9a3a-709f264d43ed",
-      "command": null,
-      "console": false,
-      "debug": null,
-      "staging_task_id": "0c11278f-beb2-4681-8518-44c1b7a5545a",
-      "package_state": "STAGED",
-      "health_check_type": "process",
-      "health_check_timeout": null,
-      "health_check_http_endpoint": "",
-      "staging_failed_reason": null,
-      "staging_failed_description": null,
-      "diego": true,
-      "docker_image": null,
-      "docker_credentials": {
-         "username": null,
-         "password": null
-      },
-      "package_updated_at": "2020-07-21T22:09:59Z",
-      "detected_start_command": "./bin/go-app-with-metrics",
-      "enable_ssh": true,
-      "ports": %s,
-      "space_url": "/v2/spaces/eefee588-c08a-4fde-b0e3-8b2a6309e365",
-      "stack_url": "/v2/stacks/a3fd69f2-b350-4515-86a9-7fab406e8544",
-      "routes_url": "/v2/apps/419aa316-c70b-40f9-b38b-c99e693e7620/routes",
-      "events_url": "/v2/apps/419aa316-c70b-40f9-b38b-c99e693e7620/events",
-      "service_bindings_url": "/v2/apps/419aa316-c70b-40f9-b38b-c99e693e7620/service_bindings",
-      "route_mappings_url": "/v2/apps/419aa316-c70b-40f9-b38b-c99e693e7620/route_mappings"
-   }
-}
-`
-)
+func (f *mockRegistrationFetcher) FetchUnparseable(...string) (map[string][]registrations.Registration, error) {
+	return f.unparseable, f.fetchError
+}
+
+func (f *mockRegistrationFetcher) FetchUnbound(registrationType ...string) ([]registrations.Registration, error) {
+	var result []registrations.Registration
+	for _, r := range f.unbound {
+		for _, t := range registrationType {
+			if r.Type == t {
+				result = append(result, r)
+			}
+		}
+	}
+	return result, f.fetchError
+}
+
+func (f *mockRegistrationFetcher) FetchInstances(registrationType ...string) ([]registrations.Registration, error) {
+	var result []registrations.Registration
+	for _, r := range f.instances {
+		for _, t := range registrationType {
+			if r.Type == t {
+				result = append(result, r)
+			}
+		}
+	}
+	return result, f.fetchError
+}
+
+type mockAppSelector struct {
+	selectors chan string
+	names     []string
+	err       error
+}
+
+func newMockAppSelector() *mockAppSelector {
+	return &mockAppSelector{selectors: make(chan string, 10)}
+}
+
+func (s *mockAppSelector) SelectApps(labelSelector string) ([]string, error) {
+	s.selectors <- labelSelector
+	return s.names, s.err
+}
+
+type mockPortManager struct {
+	appGuids       []string
+	exposedPorts   []int
+	getPortsError  error
+	setPortsError  error
+	setPortsCalled chan []int
+
+	openedPorts          []int
+	getOpenedPortsError  error
+	setOpenedPortsError  error
+	setOpenedPortsCalled chan []int
+}
+
+func newMockPortManager() *mockPortManager {
+	return &mockPortManager{
+		appGuids:             []string{"app-guid"},
+		exposedPorts:         []int{8080},
+		setPortsCalled:       make(chan []int, 10),
+		setOpenedPortsCalled: make(chan []int, 10),
+	}
+}
+
+func (m *mockPortManager) GetPortsForApp(appGuid string) ([]int, error) {
+	Expect(m.appGuids).To(ContainElement(appGuid))
+	return m.exposedPorts, m.getPortsError
+}
+
+func (m *mockPortManager) SetPortsForApp(appGuid string, ports []int) error {
+	Expect(m.appGuids).To(ContainElement(appGuid))
+	m.setPortsCalled <- ports
+	if m.setPortsError != nil {
+		return m.setPortsError
+	}
+	m.exposedPorts = ports
+	return nil
+}
+
+func (m *mockPortManager) GetOpenedPortsForApp(appGuid string) ([]int, error) {
+	Expect(m.appGuids).To(ContainElement(appGuid))
+	return m.openedPorts, m.getOpenedPortsError
+}
+
+func (m *mockPortManager) SetOpenedPortsForApp(appGuid string, ports []int) error {
+	Expect(m.appGuids).To(ContainElement(appGuid))
+	m.setOpenedPortsCalled <- ports
+	if m.setOpenedPortsError != nil {
+		return m.setOpenedPortsError
+	}
+	m.openedPorts = ports
+	return nil
+}
+
+// pluginConnection runs CLI commands and looks up services and apps with the
+// mock. Nothing else of the plugin connection is implemented.
+type pluginConnection struct {
+	plugin.CliConnection
+	mock *mockCliConnection
+}
+
+func (c *pluginConnection) CliCommandWithoutTerminalOutput(args ...string) ([]string, error) {
+	return c.mock.CliCommandWithoutTerminalOutput(args...)
+}
+
+func (c *pluginConnection) GetServices() ([]plugin_models.GetServices_Model, error) {
+	return c.mock.GetServices()
+}
+
+func (c *pluginConnection) GetApp(name string) (plugin_models.GetAppModel, error) {
+	return c.mock.GetApp(name)
+}
+
+func (c *pluginConnection) GetApps() ([]plugin_models.GetAppsModel, error) {
+	return c.mock.GetApps()
+}
@@ -0,0 +1,215 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	plugin_models "code.cloudfoundry.org/cli/plugin/models"
+	"github.com/pivotal-cf/metric-registrar-cli/registrations"
+)
+
+const (
+	severityError   = "error"
+	severityWarning = "warning"
+)
+
+// DoctorOptions control whether the problems doctor finds are repaired.
+// Only problems with a safe fix, like exposing a missing port, are.
+type DoctorOptions struct {
+	Fix bool
+}
+
+type problem struct {
+	appName     string
+	serviceName string
+	severity    string
+	description string
+	suggestion  string
+
+	// fix repairs the problem, it is nil if that can't be done safely
+	fix func() error
+}
+
+// Doctor checks the registrations of an app, or of every app in the space
+// when appName is empty, and reports the problems it finds. It fails if
+// errors remain; warnings alone don't fail it.
+func Doctor(writer io.Writer, fetcher registrationFetcher, cliConn cliCommandRunner, portManager portManager, appName string, opts DoctorOptions) error {
+	all, err := fetcher.FetchAll(structuredFormat, metricsEndpoint, secureEndpoint)
+	if err != nil {
+		return err
+	}
+
+	// FetchAll skips services whose drain can't be parsed at all
+	unparseable, err := fetcher.FetchUnparseable(structuredFormat, metricsEndpoint, secureEndpoint)
+	if err != nil {
+		return err
+	}
+
+	apps, err := appsToCheck(cliConn, appName)
+	if err != nil {
+		return err
+	}
+
+	var problems []problem
+	for _, app := range apps {
+		regs := append(all[app.Guid], unparseable[app.Guid]...)
+		if len(regs) == 0 {
+			continue
+		}
+
+		found, err := diagnoseApp(cliConn, portManager, app, regs)
+		if err != nil {
+			return err
+		}
+		problems = append(problems, found...)
+	}
+
+	return writeDiagnosis(writer, problems, opts)
+}
+
+func appsToCheck(cliConn cliCommandRunner, appName string) ([]plugin_models.GetAppsModel, error) {
+	if appName != "" {
+		app, err := cliConn.GetApp(appName)
+		if err != nil {
+			return nil, err
+		}
+		return []plugin_models.GetAppsModel{{Name: app.Name, Guid: app.Guid, State: app.State}}, nil
+	}
+
+	apps, err := cliConn.GetApps()
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(apps, func(i, j int) bool { return apps[i].Name < apps[j].Name })
+	return apps, nil
+}
+
+func diagnoseApp(cliConn cliCommandRunner, portManager portManager, app plugin_models.GetAppsModel, regs []registrations.Registration) ([]problem, error) {
+	var problems []problem
+	add := func(r registrations.Registration, severity, description, suggestion string, fix func() error) {
+		problems = append(problems, problem{
+			appName:     app.Name,
+			serviceName: r.Name,
+			severity:    severity,
+			description: description,
+			suggestion:  suggestion,
+			fix:         fix,
+		})
+	}
+
+	if app.State != "" && !strings.EqualFold(app.State, "started") {
+		for _, r := range regs {
+			add(r, severityWarning, "app is "+strings.ToLower(app.State), fmt.Sprintf("start %s or unregister it", app.Name), nil)
+		}
+	}
+
+	// the app's routes and ports are only looked up if a registration needs them
+	var details *plugin_models.GetAppModel
+	var exposed []int
+	var exposedRead bool
+
+	for _, r := range regs {
+		drain := r.Type + "://" + r.Config
+		if r.Type == "" {
+			// the drain couldn't be split into type and config
+			drain = r.Config
+		}
+		unparseable := func() {
+			add(r, severityError, "can't parse drain URL "+drain, fmt.Sprintf("cf unbind-service %s %s", app.Name, r.Name), nil)
+		}
+
+		switch r.Type {
+		case "":
+			unparseable()
+
+		case structuredFormat:
+			if r.Config == "" {
+				unparseable()
+			}
+
+		case metricsEndpoint:
+			if _, err := url.Parse(ensureHttpsPrefix(r.Config)); err != nil || r.Config == "" {
+				unparseable()
+				continue
+			}
+
+			if details == nil {
+				d, err := cliConn.GetApp(app.Name)
+				if err != nil {
+					return nil, err
+				}
+				details = &d
+			}
+			if _, err := validateRouteForApp(r.Config, *details, false); err != nil {
+				add(r, severityError, "route isn't mapped to the app", fmt.Sprintf("map the route again or cf unregister-metrics-endpoint %s --path %s", app.Name, r.Config), nil)
+			}
+
+		case secureEndpoint:
+			port := getPortFromConfig(r.Config)
+			if port <= 0 {
+				unparseable()
+				continue
+			}
+
+			if !exposedRead {
+				var err error
+				exposed, err = portManager.GetPortsForApp(app.Guid)
+				if err != nil {
+					return nil, err
+				}
+				exposedRead = true
+			}
+			if !containsPort(exposed, port) {
+				guid := app.Guid
+				add(r, severityError, "internal port "+strconv.Itoa(port)+" isn't exposed", "rerun with --fix to expose it", func() error {
+					_, err := exposePortForApp(portManager, guid, port)
+					return err
+				})
+				// one fix per port is enough
+				exposed = append(exposed, port)
+			}
+		}
+	}
+
+	return problems, nil
+}
+
+func writeDiagnosis(writer io.Writer, problems []problem, opts DoctorOptions) error {
+	if len(problems) == 0 {
+		_, err := fmt.Fprintln(writer, "No problems found.")
+		return err
+	}
+
+	w := tabwriter.NewWriter(writer, 0, 8, 2, ' ', tabwriter.StripEscape)
+	writeFields(w, "App", "Service", "Severity", "Problem", "Suggested fix") //nolint:errcheck
+
+	remaining := 0
+	for _, p := range problems {
+		suggestion := p.suggestion
+		if opts.Fix && p.fix != nil {
+			if err := p.fix(); err != nil {
+				suggestion = "fix failed: " + err.Error()
+			} else {
+				suggestion = "fixed"
+			}
+		}
+		if p.severity == severityError && suggestion != "fixed" {
+			remaining++
+		}
+		writeFields(w, p.appName, p.serviceName, p.severity, p.description, suggestion) //nolint:errcheck
+	}
+	err := w.Flush()
+	if err != nil {
+		return err
+	}
+
+	if remaining > 0 {
+		return fmt.Errorf("%d problems need attention", remaining)
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package command_test
+
+import (
+	"errors"
+
+	plugin_models "code.cloudfoundry.org/cli/plugin/models"
+	"github.com/pivotal-cf/metric-registrar-cli/command"
+	"github.com/pivotal-cf/metric-registrar-cli/registrations"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Doctor", func() {
+	var (
+		writer              *spyWriter
+		registrationFetcher *mockRegistrationFetcher
+		portManager         *mockPortManager
+		cliConn             *mockCliConnection
+	)
+
+	BeforeEach(func() {
+		writer = newSpyWriter()
+		registrationFetcher = newMockRegistrationFetcher()
+		portManager = newMockPortManager()
+		portManager.exposedPorts = []int{8080, 2112}
+		cliConn = newMockCliConnection()
+		cliConn.getAppsResult = []plugin_models.GetAppsModel{
+			{Name: "app-name", Guid: "app-guid", State: "STARTED"},
+		}
+	})
+
+	It("reports that healthy registrations have no problems", func() {
+		registrationFetcher.registrations["app-guid"] = []registrations.Registration{
+			{Name: "format", Type: "structured-format", Config: "json"},
+			{Name: "insecure", Type: "metrics-endpoint", Config: "app-host.app-domain/app-path/metrics"},
+			{Name: "secure", Type: "secure-endpoint", Config: ":2112/metrics"},
+		}
+
+		Expect(command.Doctor(writer, registrationFetcher, cliConn, portManager, "", command.DoctorOptions{})).To(Succeed())
+		Expect(writer.lines()).To(Equal([]string{"No problems found.", ""}))
+	})
+
+	It("reports insecure endpoints whose route isn't mapped", func() {
+		registrationFetcher.registrations["app-guid"] = []registrations.Registration{
+			{Name: "insecure", Type: "metrics-endpoint", Config: "other-host.app-domain/metrics"},
+		}
+
+		err := command.Doctor(writer, registrationFetcher, cliConn, portManager, "", command.DoctorOptions{})
+		Expect(err).To(MatchError("1 problems need attention"))
+		Expect(writer.lines()).To(Equal([]string{
+			"App       Service   Severity  Problem                        Suggested fix",
+			"app-name  insecure  error     route isn't mapped to the app  map the route again or cf unregister-metrics-endpoint app-name --path other-host.app-domain/metrics",
+			"",
+		}))
+	})
+
+	It("reports secure endpoints whose port isn't exposed", func() {
+		registrationFetcher.registrations["app-guid"] = []registrations.Registration{
+			{Name: "secure", Type: "secure-endpoint", Config: ":9090/metrics"},
+		}
+
+		Expect(command.Doctor(writer, registrationFetcher, cliConn, portManager, "", command.DoctorOptions{})).ToNot(Succeed())
+		Expect(writer.lines()).To(ContainElement(MatchRegexp(`app-name\s+secure\s+error\s+internal port 9090 isn't exposed\s+rerun with --fix to expose it`)))
+		Expect(portManager.setPortsCalled).ToNot(Receive())
+	})
+
+	It("exposes missing ports with --fix", func() {
+		registrationFetcher.registrations["app-guid"] = []registrations.Registration{
+			{Name: "secure", Type: "secure-endpoint", Config: ":9090/metrics"},
+			{Name: "other-secure", Type: "secure-endpoint", Config: ":9090/other"},
+		}
+
+		Expect(command.Doctor(writer, registrationFetcher, cliConn, portManager, "", command.DoctorOptions{Fix: true})).To(Succeed())
+		Expect(portManager.setPortsCalled).To(Receive(Equal([]int{8080, 2112, 9090})))
+		Expect(portManager.setPortsCalled).ToNot(Receive())
+		Expect(portManager.openedPorts).To(Equal([]int{9090}))
+		Expect(writer.lines()).To(ContainElement(MatchRegexp(`internal port 9090 isn't exposed\s+fixed$`)))
+	})
+
+	It("reports drain URLs it can't parse", func() {
+		registrationFetcher.registrations["app-guid"] = []registrations.Registration{
+			{Name: "broken", Type: "secure-endpoint", Config: "no-port/metrics"},
+		}
+
+		Expect(command.Doctor(writer, registrationFetcher, cliConn, portManager, "", command.DoctorOptions{Fix: true})).ToNot(Succeed())
+		Expect(writer.lines()).To(ContainElement(MatchRegexp(`can't parse drain URL secure-endpoint://no-port/metrics\s+cf unbind-service app-name broken`)))
+	})
+
+	It("reports registration services whose drain URL has no type", func() {
+		registrationFetcher.unparseable = map[string][]registrations.Registration{
+			"app-guid": {{Name: "metrics-endpoint-broken", Config: "metrics-endpoint:/metrics", NumberOfBindings: 1}},
+		}
+
+		err := command.Doctor(writer, registrationFetcher, cliConn, portManager, "", command.DoctorOptions{})
+		Expect(err).To(MatchError("1 problems need attention"))
+		Expect(writer.lines()).To(ContainElement(MatchRegexp(`app-name\s+metrics-endpoint-broken\s+error\s+can't parse drain URL metrics-endpoint:/metrics\s+cf unbind-service app-name metrics-endpoint-broken`)))
+	})
+
+	It("warns about registrations of stopped apps", func() {
+		cliConn.getAppsResult[0].State = "STOPPED"
+		registrationFetcher.registrations["app-guid"] = []registrations.Registration{
+			{Name: "format", Type: "structured-format", Config: "json"},
+		}
+
+		Expect(command.Doctor(writer, registrationFetcher, cliConn, portManager, "", command.DoctorOptions{})).To(Succeed())
+		Expect(writer.lines()).To(ContainElement(MatchRegexp(`app-name\s+format\s+warning\s+app is stopped\s+start app-name or unregister it`)))
+	})
+
+	It("only checks the given app", func() {
+		cliConn.getAppResult.State = "STOPPED"
+		registrationFetcher.registrations["app-guid"] = []registrations.Registration{
+			{Name: "format", Type: "structured-format", Config: "json"},
+		}
+		registrationFetcher.registrations["other-guid"] = []registrations.Registration{
+			{Name: "other-format", Type: "structured-format", Config: "json"},
+		}
+
+		Expect(command.Doctor(writer, registrationFetcher, cliConn, portManager, "app-name", command.DoctorOptions{})).To(Succeed())
+		Expect(writer.lines()).To(HaveLen(3))
+		Expect(writer.lines()[1]).To(HavePrefix("app-name  format"))
+	})
+
+	It("returns an error if fetching registrations fails", func() {
+		registrationFetcher.fetchError = errors.New("expected")
+
+		Expect(command.Doctor(writer, registrationFetcher, cliConn, portManager, "", command.DoctorOptions{})).To(MatchError("expected"))
+	})
+})
@@ -0,0 +1,146 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"code.cloudfoundry.org/cli/plugin"
+)
+
+// DryRunConnection prints CLI commands instead of running them. The plugin
+// only runs CLI commands to change services and bindings; lookups go through
+// the plugin API or the Cloud Controller client.
+type DryRunConnection struct {
+	plugin.CliConnection
+	writer io.Writer
+}
+
+func NewDryRunConnection(conn plugin.CliConnection, writer io.Writer) *DryRunConnection {
+	return &DryRunConnection{CliConnection: conn, writer: writer}
+}
+
+func (c *DryRunConnection) CliCommandWithoutTerminalOutput(args ...string) ([]string, error) {
+	_, err := fmt.Fprintf(c.writer, "would run: cf %s\n", strings.Join(args, " "))
+	return nil, err
+}
+
+func (c *DryRunConnection) CliCommand(args ...string) ([]string, error) {
+	return c.CliCommandWithoutTerminalOutput(args...)
+}
+
+func (c *DryRunConnection) dryRun() {}
+
+func (c *DryRunConnection) printingTo(writer io.Writer) *DryRunConnection {
+	return NewDryRunConnection(c.CliConnection, writer)
+}
+
+// dryRunner is implemented by connections that only print the changes they
+// are asked to make.
+type dryRunner interface {
+	dryRun()
+}
+
+// connPrintingTo returns cliConn printing to writer if it is a dry run, so
+// that a batch shows what it would do for an app with the app's output.
+func connPrintingTo(writer io.Writer, cliConn cliCommandRunner) cliCommandRunner {
+	if c, ok := cliConn.(*DryRunConnection); ok {
+		return c.printingTo(writer)
+	}
+	return cliConn
+}
+
+// portsPrintingTo is connPrintingTo for port managers.
+func portsPrintingTo(writer io.Writer, pm portManager) portManager {
+	if m, ok := pm.(*DryRunPortManager); ok {
+		return m.printingTo(writer)
+	}
+	return pm
+}
+
+// isDryRun reports whether changes made through cliConn are only printed,
+// so that results and summaries don't claim work that didn't happen.
+func isDryRun(cliConn cliCommandRunner) bool {
+	_, ok := cliConn.(dryRunner)
+	return ok
+}
+
+// DryRunPortManager prints the change a set would make. Later reads return
+// the ports as if the set had happened so that changes building on each
+// other are shown correctly. It is safe for concurrent use.
+type DryRunPortManager struct {
+	portManager
+	writer io.Writer
+	*dryRunPorts
+}
+
+// dryRunPorts are the ports set during a dry run. They are shared by the
+// port managers printing each app of a batch to its own output.
+type dryRunPorts struct {
+	mu          sync.Mutex
+	ports       map[string][]int
+	openedPorts map[string][]int
+}
+
+func NewDryRunPortManager(pm portManager, writer io.Writer) *DryRunPortManager {
+	return &DryRunPortManager{
+		portManager: pm,
+		writer:      writer,
+		dryRunPorts: &dryRunPorts{
+			ports:       map[string][]int{},
+			openedPorts: map[string][]int{},
+		},
+	}
+}
+
+func (m *DryRunPortManager) printingTo(writer io.Writer) *DryRunPortManager {
+	return &DryRunPortManager{portManager: m.portManager, writer: writer, dryRunPorts: m.dryRunPorts}
+}
+
+func (m *DryRunPortManager) GetPortsForApp(guid string) ([]int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.getPorts(guid)
+}
+
+func (m *DryRunPortManager) getPorts(guid string) ([]int, error) {
+	if ports, ok := m.ports[guid]; ok {
+		return ports, nil
+	}
+	return m.portManager.GetPortsForApp(guid)
+}
+
+func (m *DryRunPortManager) SetPortsForApp(guid string, ports []int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current, err := m.getPorts(guid)
+	if err != nil {
+		return err
+	}
+
+	m.ports[guid] = ports
+	_, err = fmt.Fprintf(m.writer, "would set ports of app %s from [%s] to [%s]\n", guid, joinPorts(current), joinPorts(ports))
+	return err
+}
+
+func (m *DryRunPortManager) GetOpenedPortsForApp(guid string) ([]int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if ports, ok := m.openedPorts[guid]; ok {
+		return ports, nil
+	}
+	return m.portManager.GetOpenedPortsForApp(guid)
+}
+
+func (m *DryRunPortManager) SetOpenedPortsForApp(guid string, ports []int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.openedPorts[guid] = ports
+	_, err := fmt.Fprintf(m.writer, "would record ports opened by the plugin on app %s as [%s]\n", guid, joinPorts(ports))
+	return err
+}
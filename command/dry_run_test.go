@@ -0,0 +1,111 @@
+package command_test
+
+import (
+	"io"
+
+	"github.com/pivotal-cf/metric-registrar-cli/command"
+	"github.com/pivotal-cf/metric-registrar-cli/registrations"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Dry run", func() {
+	It("prints CLI commands instead of running them", func() {
+		writer := newSpyWriter()
+		conn := command.NewDryRunConnection(nil, writer)
+
+		_, err := conn.CliCommandWithoutTerminalOutput("bind-service", "app-name", "service-name")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(writer.lines()).To(Equal([]string{
+			"would run: cf bind-service app-name service-name",
+			"",
+		}))
+	})
+
+	It("prints port changes instead of making them", func() {
+		writer := newSpyWriter()
+		portManager := newMockPortManager()
+		portManager.exposedPorts = []int{8080, 2112}
+		dryRun := command.NewDryRunPortManager(portManager, writer)
+
+		ports, err := dryRun.GetPortsForApp("app-guid")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ports).To(Equal([]int{8080, 2112}))
+
+		Expect(dryRun.SetPortsForApp("app-guid", []int{8080})).To(Succeed())
+		Expect(portManager.setPortsCalled).ToNot(Receive())
+		Expect(writer.lines()).To(Equal([]string{
+			"would set ports of app app-guid from [8080, 2112] to [8080]",
+			"",
+		}))
+	})
+
+	It("shows the services and ports unregistering would remove", func() {
+		writer := newSpyWriter()
+		cliConn := newMockCliConnection()
+		portManager := newMockPortManager()
+		portManager.exposedPorts = []int{2112}
+		portManager.openedPorts = []int{2112}
+		registrationFetcher := newMockRegistrationFetcher()
+		registrationFetcher.registrations["app-guid"] = []registrations.Registration{{
+			Name:             "secure-endpoint-2112-metrics",
+			Type:             "secure-endpoint",
+			Config:           ":2112/metrics",
+			NumberOfBindings: 1,
+		}}
+
+		err := command.UnregisterMetricsEndpoint(writer, registrationFetcher, newDryRunConnection(cliConn, writer), command.NewDryRunPortManager(portManager, writer), "app-name", "", "", command.UnregisterOptions{})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(cliConn.cliCommandsCalled).ToNot(Receive())
+		Expect(portManager.setPortsCalled).ToNot(Receive())
+		Expect(writer.lines()).To(Equal([]string{
+			"would run: cf unbind-service app-name secure-endpoint-2112-metrics",
+			"would run: cf delete-service secure-endpoint-2112-metrics -f",
+			"would set ports of app app-guid from [2112] to []",
+			"would record ports opened by the plugin on app app-guid as []",
+			"",
+		}))
+	})
+
+	It("reports a registration it only printed as one it would make", func() {
+		dryRunWriter := newSpyWriter()
+		cliConn := newDryRunConnection(newMockCliConnection(), dryRunWriter)
+		writer := newSpyWriter()
+
+		err := command.RegisterLogFormat(writer, newMockRegistrationFetcher(), cliConn, "app-name", "json", command.RegisterOptions{Output: "json"})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(string(writer.bytes)).To(MatchJSON(`{
+			"app_name": "app-name",
+			"service_name": "structured-format-json-09cae105ae65ef6b",
+			"type": "structured-format",
+			"config": "json",
+			"status": "would register"
+		}`))
+		Expect(dryRunWriter.lines()).To(Equal([]string{
+			"would run: cf create-user-provided-service structured-format-json-09cae105ae65ef6b -l structured-format://json",
+			"would run: cf bind-service app-name structured-format-json-09cae105ae65ef6b",
+			"",
+		}))
+	})
+
+	It("words the summary of a cleanup as what it would do", func() {
+		writer := newSpyWriter()
+		registrationFetcher := newMockRegistrationFetcher()
+		registrationFetcher.unbound = []registrations.Registration{
+			{Name: "structured-format-json", Type: "structured-format", Config: "json"},
+		}
+
+		err := command.CleanupOrphans(writer, nil, registrationFetcher, newDryRunConnection(newMockCliConnection(), writer), command.CleanupOptions{Force: true})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(writer.lines()).To(ContainElement("would run: cf delete-service structured-format-json -f"))
+		Expect(writer.lines()).To(ContainElement("Would delete 1 services."))
+	})
+})
+
+func newDryRunConnection(mock *mockCliConnection, writer io.Writer) *command.DryRunConnection {
+	return command.NewDryRunConnection(&pluginConnection{mock: mock}, writer)
+}
@@ -0,0 +1,16 @@
+package command
+
+// ErrPortOwnershipUnavailable is returned by port managers of foundations
+// without v3 apps.
+var ErrPortOwnershipUnavailable = errPortOwnershipUnavailable
+
+var (
+	NewFetcher     = newFetcher
+	NewPortManager = newPortManager
+	NewAppSelector = newAppSelector
+)
+
+// AppPorts returns the manager a port manager sets the app's ports with.
+func AppPorts(pm portManager) interface{} {
+	return pm.(trackedPortManager).appPorts
+}
@@ -14,57 +14,113 @@ type appLister interface {
 	GetApps() ([]plugin_models.GetAppsModel, error)
 }
 
-func ListRegisteredLogFormats(writer io.Writer, fetcher registrationFetcher, lister appLister, appName string) error {
+// ListFilter limits a listing to the app called Name, to the apps whose
+// labels match LabelSelector and, with ManagedOnly, to the services the
+// plugin labelled when it created them. The zero value lists everything.
+type ListFilter struct {
+	Name          string
+	LabelSelector string
+	ManagedOnly   bool
+}
+
+func ListRegisteredLogFormats(writer io.Writer, fetcher registrationFetcher, lister appLister, selector appSelector, filter ListFilter, opts OutputOptions) error {
 	regs, err := fetcher.FetchAll(structuredFormat)
 	if err != nil {
 		return err
 	}
 
-	apps, err := lister.GetApps()
+	apps, err := filteredApps(lister, selector, filter)
 	if err != nil {
 		return err
 	}
 
-	return writeTable(writer, apps, regs, appName, "Format")
+	return writeRecords(writer, records(apps, managedRegistrations(regs, filter.ManagedOnly)), opts, []string{"app", "format", "service", "bindings"})
 }
 
-func ListRegisteredMetricsEndpoints(writer io.Writer, fetcher registrationFetcher, lister appLister, appName string) error {
+func ListRegisteredMetricsEndpoints(writer io.Writer, fetcher registrationFetcher, lister appLister, selector appSelector, filter ListFilter, opts OutputOptions) error {
 	regs, err := fetcher.FetchAll(metricsEndpoint, secureEndpoint)
 	if err != nil {
 		return err
 	}
 
-	apps, err := lister.GetApps()
+	apps, err := filteredApps(lister, selector, filter)
 	if err != nil {
 		return err
 	}
 
-	return writeTable(writer, apps, regs, appName, "Path")
+	return writeRecords(writer, records(apps, managedRegistrations(regs, filter.ManagedOnly)), opts, []string{"app", "type", "port", "path", "service", "bindings"})
 }
 
-func writeTable(writer io.Writer, apps []plugin_models.GetAppsModel, regs map[string][]registrations.Registration, appName, configName string) error {
-	w := tabwriter.NewWriter(writer, 0, 8, 2, ' ', tabwriter.StripEscape)
-	writeFields(w, "App", configName) //nolint:errcheck
+func filteredApps(lister appLister, selector appSelector, filter ListFilter) ([]plugin_models.GetAppsModel, error) {
+	apps, err := lister.GetApps()
+	if err != nil {
+		return nil, err
+	}
 
-	for _, line := range lines(apps, regs, appName) {
-		writeFields(w, line...) //nolint:errcheck
+	labelled := map[string]bool{}
+	if filter.LabelSelector != "" {
+		names, err := selector.SelectApps(filter.LabelSelector)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			labelled[name] = true
+		}
 	}
 
-	return w.Flush()
+	var filtered []plugin_models.GetAppsModel
+	for _, app := range apps {
+		if filter.Name != "" && filter.Name != app.Name {
+			continue
+		}
+		if filter.LabelSelector != "" && !labelled[app.Name] {
+			continue
+		}
+		filtered = append(filtered, app)
+	}
+	return filtered, nil
 }
 
-func lines(apps []plugin_models.GetAppsModel, regs map[string][]registrations.Registration, appName string) [][]string {
-	var lines [][]string
+func managedRegistrations(regs map[string][]registrations.Registration, managedOnly bool) map[string][]registrations.Registration {
+	if !managedOnly {
+		return regs
+	}
 
-	for _, app := range apps {
-		for _, reg := range regs[app.Guid] {
-			if appName == "" || appName == app.Name {
-				lines = append(lines, []string{app.Name, reg.Config})
+	managed := map[string][]registrations.Registration{}
+	for appGuid, appRegs := range regs {
+		for _, r := range appRegs {
+			if r.Managed {
+				managed[appGuid] = append(managed[appGuid], r)
 			}
 		}
 	}
+	return managed
+}
+
+func writeTable(writer io.Writer, records []record, columnNames []string) error {
+	var cols []column
+	var headers []string
+	for _, name := range columnNames {
+		c, err := lookupColumn(name)
+		if err != nil {
+			return err
+		}
+		cols = append(cols, c)
+		headers = append(headers, c.header)
+	}
 
-	return lines
+	w := tabwriter.NewWriter(writer, 0, 8, 2, ' ', tabwriter.StripEscape)
+	writeFields(w, headers...) //nolint:errcheck
+
+	for _, r := range records {
+		var fields []string
+		for _, c := range cols {
+			fields = append(fields, c.value(r))
+		}
+		writeFields(w, fields...) //nolint:errcheck
+	}
+
+	return w.Flush()
 }
 
 func writeFields(w *tabwriter.Writer, fields ...string) error {
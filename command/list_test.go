@@ -18,11 +18,11 @@ var _ = Describe("List", func() {
 			registrationFetcher := newMockRegistrationFetcher()
 			registrationFetcher.registrations = map[string][]registrations.Registration{
 				"app-guid": {
-					{Type: "structured-format", Config: "json"},
-					{Type: "structured-format", Config: "dogstatsd"},
+					{Name: "structured-format-json", Type: "structured-format", Config: "json", NumberOfBindings: 1},
+					{Name: "structured-format-dogstatsd", Type: "structured-format", Config: "dogstatsd", NumberOfBindings: 2},
 				},
 				"app-guid-2": {
-					{Type: "structured-format", Config: "dogstatsd"},
+					{Name: "structured-format-dogstatsd", Type: "structured-format", Config: "dogstatsd", NumberOfBindings: 2},
 				},
 			}
 			writer := newSpyWriter()
@@ -32,14 +32,14 @@ var _ = Describe("List", func() {
 				{Name: "app-name-2", Guid: "app-guid-2"},
 			}
 
-			err := command.ListRegisteredLogFormats(writer, registrationFetcher, cliConn, "")
+			err := command.ListRegisteredLogFormats(writer, registrationFetcher, cliConn, newMockAppSelector(), command.ListFilter{}, command.OutputOptions{})
 			Expect(err).ToNot(HaveOccurred())
 
 			Expect(writer.lines()).To(Equal([]string{
-				"App         Format",
-				"app-name    json",
-				"app-name    dogstatsd",
-				"app-name-2  dogstatsd",
+				"App         Format     Service                      Bindings",
+				"app-name    json       structured-format-json       1",
+				"app-name    dogstatsd  structured-format-dogstatsd  2",
+				"app-name-2  dogstatsd  structured-format-dogstatsd  2",
 				"",
 			}))
 		})
@@ -48,11 +48,11 @@ var _ = Describe("List", func() {
 			registrationFetcher := newMockRegistrationFetcher()
 			registrationFetcher.registrations = map[string][]registrations.Registration{
 				"app-guid": {
-					{Type: "structured-format", Config: "json"},
-					{Type: "structured-format", Config: "dogstatsd"},
+					{Name: "structured-format-json", Type: "structured-format", Config: "json", NumberOfBindings: 1},
+					{Name: "structured-format-dogstatsd", Type: "structured-format", Config: "dogstatsd", NumberOfBindings: 2},
 				},
 				"app-guid-2": {
-					{Type: "structured-format", Config: "dogstatsd"},
+					{Name: "structured-format-dogstatsd", Type: "structured-format", Config: "dogstatsd", NumberOfBindings: 2},
 				},
 			}
 			writer := newSpyWriter()
@@ -62,13 +62,13 @@ var _ = Describe("List", func() {
 				{Name: "app-name-2", Guid: "app-guid-2"},
 			}
 
-			err := command.ListRegisteredLogFormats(writer, registrationFetcher, cliConn, "app-name")
+			err := command.ListRegisteredLogFormats(writer, registrationFetcher, cliConn, newMockAppSelector(), command.ListFilter{Name: "app-name"}, command.OutputOptions{})
 			Expect(err).ToNot(HaveOccurred())
 
 			Expect(writer.lines()).To(Equal([]string{
-				"App       Format",
-				"app-name  json",
-				"app-name  dogstatsd",
+				"App       Format     Service                      Bindings",
+				"app-name  json       structured-format-json       1",
+				"app-name  dogstatsd  structured-format-dogstatsd  2",
 				"",
 			}))
 		})
@@ -83,7 +83,7 @@ var _ = Describe("List", func() {
 				{Name: "app-name", Guid: "app-guid"},
 			}
 
-			err := command.ListRegisteredLogFormats(writer, registrationFetcher, cliConn, "")
+			err := command.ListRegisteredLogFormats(writer, registrationFetcher, cliConn, newMockAppSelector(), command.ListFilter{}, command.OutputOptions{})
 			Expect(err).To(HaveOccurred())
 		})
 
@@ -104,7 +104,7 @@ var _ = Describe("List", func() {
 				{Name: "app-name", Guid: "app-guid"},
 			}
 
-			err := command.ListRegisteredLogFormats(writer, registrationFetcher, cliConn, "")
+			err := command.ListRegisteredLogFormats(writer, registrationFetcher, cliConn, newMockAppSelector(), command.ListFilter{}, command.OutputOptions{})
 			Expect(err).To(HaveOccurred())
 		})
 
@@ -122,9 +122,43 @@ var _ = Describe("List", func() {
 			cliConn := newMockCliConnection()
 			cliConn.getAppsError = errors.New("expected")
 
-			err := command.ListRegisteredLogFormats(writer, registrationFetcher, cliConn, "")
+			err := command.ListRegisteredLogFormats(writer, registrationFetcher, cliConn, newMockAppSelector(), command.ListFilter{}, command.OutputOptions{})
 			Expect(err).To(HaveOccurred())
 		})
+
+		It("lists only the apps matching the label selector", func() {
+			registrationFetcher := newMockRegistrationFetcher()
+			registrationFetcher.registrations = map[string][]registrations.Registration{
+				"app-guid":   {{Name: "structured-format-json", Type: "structured-format", Config: "json", NumberOfBindings: 2}},
+				"app-guid-2": {{Name: "structured-format-json", Type: "structured-format", Config: "json", NumberOfBindings: 2}},
+			}
+			writer := newSpyWriter()
+			cliConn := newMockCliConnection()
+			cliConn.getAppsResult = []plugin_models.GetAppsModel{
+				{Name: "app-name", Guid: "app-guid"},
+				{Name: "app-name-2", Guid: "app-guid-2"},
+			}
+			selector := newMockAppSelector()
+			selector.names = []string{"app-name-2"}
+
+			err := command.ListRegisteredLogFormats(writer, registrationFetcher, cliConn, selector, command.ListFilter{LabelSelector: "team=payments"}, command.OutputOptions{})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(selector.selectors).To(Receive(Equal("team=payments")))
+			Expect(writer.lines()).To(Equal([]string{
+				"App         Format  Service                 Bindings",
+				"app-name-2  json    structured-format-json  2",
+				"",
+			}))
+		})
+
+		It("returns an error if selecting apps by label fails", func() {
+			selector := newMockAppSelector()
+			selector.err = errors.New("expected")
+
+			err := command.ListRegisteredLogFormats(newSpyWriter(), newMockRegistrationFetcher(), newMockCliConnection(), selector, command.ListFilter{LabelSelector: "team=payments"}, command.OutputOptions{})
+			Expect(err).To(MatchError("expected"))
+		})
 	})
 
 	Describe("ListRegisteredMetricsEndpoints", func() {
@@ -132,13 +166,13 @@ var _ = Describe("List", func() {
 			registrationFetcher := newMockRegistrationFetcher()
 			registrationFetcher.registrations = map[string][]registrations.Registration{
 				"app-guid": {
-					{Type: "metrics-endpoint", Config: "/metrics"},
-					{Type: "metrics-endpoint", Config: "/promql"},
-					{Type: "secure-endpoint", Config: ":8081/metrics"},
+					{Name: "metrics-endpoint-metrics", Type: "metrics-endpoint", Config: "/metrics", NumberOfBindings: 1},
+					{Name: "metrics-endpoint-promql", Type: "metrics-endpoint", Config: "/promql", NumberOfBindings: 2},
+					{Name: "secure-endpoint-8081-metrics", Type: "secure-endpoint", Config: ":8081/metrics", NumberOfBindings: 1},
 				},
 				"app-guid-2": {
-					{Type: "metrics-endpoint", Config: "/promql"},
-					{Type: "secure-endpoint", Config: ":1234/promql"},
+					{Name: "metrics-endpoint-promql", Type: "metrics-endpoint", Config: "/promql", NumberOfBindings: 2},
+					{Name: "secure-endpoint-1234-promql", Type: "secure-endpoint", Config: ":1234/promql", NumberOfBindings: 1},
 				},
 			}
 			writer := newSpyWriter()
@@ -148,16 +182,16 @@ var _ = Describe("List", func() {
 				{Name: "app-name-2", Guid: "app-guid-2"},
 			}
 
-			err := command.ListRegisteredMetricsEndpoints(writer, registrationFetcher, cliConn, "")
+			err := command.ListRegisteredMetricsEndpoints(writer, registrationFetcher, cliConn, newMockAppSelector(), command.ListFilter{}, command.OutputOptions{})
 			Expect(err).ToNot(HaveOccurred())
 
 			Expect(writer.lines()).To(Equal([]string{
-				"App         Path",
-				"app-name    /metrics",
-				"app-name    /promql",
-				"app-name    :8081/metrics",
-				"app-name-2  /promql",
-				"app-name-2  :1234/promql",
+				"App         Type              Port  Path      Service                       Bindings",
+				"app-name    metrics-endpoint        /metrics  metrics-endpoint-metrics      1",
+				"app-name    metrics-endpoint        /promql   metrics-endpoint-promql       2",
+				"app-name    secure-endpoint   8081  /metrics  secure-endpoint-8081-metrics  1",
+				"app-name-2  metrics-endpoint        /promql   metrics-endpoint-promql       2",
+				"app-name-2  secure-endpoint   1234  /promql   secure-endpoint-1234-promql   1",
 				"",
 			}))
 		})
@@ -166,13 +200,13 @@ var _ = Describe("List", func() {
 			registrationFetcher := newMockRegistrationFetcher()
 			registrationFetcher.registrations = map[string][]registrations.Registration{
 				"app-guid": {
-					{Type: "metrics-endpoint", Config: "/metrics"},
-					{Type: "metrics-endpoint", Config: "/promql"},
-					{Type: "secure-endpoint", Config: ":8081/metrics"},
+					{Name: "metrics-endpoint-metrics", Type: "metrics-endpoint", Config: "/metrics", NumberOfBindings: 1},
+					{Name: "metrics-endpoint-promql", Type: "metrics-endpoint", Config: "/promql", NumberOfBindings: 2},
+					{Name: "secure-endpoint-8081-metrics", Type: "secure-endpoint", Config: ":8081/metrics", NumberOfBindings: 1},
 				},
 				"app-guid-2": {
-					{Type: "metrics-endpoint", Config: "/promql"},
-					{Type: "secure-endpoint", Config: ":1234/promql"},
+					{Name: "metrics-endpoint-promql", Type: "metrics-endpoint", Config: "/promql", NumberOfBindings: 2},
+					{Name: "secure-endpoint-1234-promql", Type: "secure-endpoint", Config: ":1234/promql", NumberOfBindings: 1},
 				},
 			}
 			writer := newSpyWriter()
@@ -182,14 +216,14 @@ var _ = Describe("List", func() {
 				{Name: "app-name-2", Guid: "app-guid-2"},
 			}
 
-			err := command.ListRegisteredMetricsEndpoints(writer, registrationFetcher, cliConn, "app-name")
+			err := command.ListRegisteredMetricsEndpoints(writer, registrationFetcher, cliConn, newMockAppSelector(), command.ListFilter{Name: "app-name"}, command.OutputOptions{})
 			Expect(err).ToNot(HaveOccurred())
 
 			Expect(writer.lines()).To(Equal([]string{
-				"App       Path",
-				"app-name  /metrics",
-				"app-name  /promql",
-				"app-name  :8081/metrics",
+				"App       Type              Port  Path      Service                       Bindings",
+				"app-name  metrics-endpoint        /metrics  metrics-endpoint-metrics      1",
+				"app-name  metrics-endpoint        /promql   metrics-endpoint-promql       2",
+				"app-name  secure-endpoint   8081  /metrics  secure-endpoint-8081-metrics  1",
 				"",
 			}))
 		})
@@ -204,7 +238,7 @@ var _ = Describe("List", func() {
 				{Name: "app-name", Guid: "app-guid"},
 			}
 
-			err := command.ListRegisteredMetricsEndpoints(writer, registrationFetcher, cliConn, "")
+			err := command.ListRegisteredMetricsEndpoints(writer, registrationFetcher, cliConn, newMockAppSelector(), command.ListFilter{}, command.OutputOptions{})
 			Expect(err).To(HaveOccurred())
 		})
 
@@ -225,7 +259,7 @@ var _ = Describe("List", func() {
 				{Name: "app-name", Guid: "app-guid"},
 			}
 
-			err := command.ListRegisteredMetricsEndpoints(writer, registrationFetcher, cliConn, "")
+			err := command.ListRegisteredMetricsEndpoints(writer, registrationFetcher, cliConn, newMockAppSelector(), command.ListFilter{}, command.OutputOptions{})
 			Expect(err).To(HaveOccurred())
 		})
 
@@ -243,12 +277,191 @@ var _ = Describe("List", func() {
 			cliConn := newMockCliConnection()
 			cliConn.getAppsError = errors.New("expected")
 
-			err := command.ListRegisteredMetricsEndpoints(writer, registrationFetcher, cliConn, "")
+			err := command.ListRegisteredMetricsEndpoints(writer, registrationFetcher, cliConn, newMockAppSelector(), command.ListFilter{}, command.OutputOptions{})
 			Expect(err).To(HaveOccurred())
 		})
 	})
 })
 
+var _ = Describe("List output", func() {
+	var (
+		registrationFetcher *mockRegistrationFetcher
+		cliConn             *mockCliConnection
+		writer              *spyWriter
+	)
+
+	BeforeEach(func() {
+		registrationFetcher = newMockRegistrationFetcher()
+		registrationFetcher.registrations = map[string][]registrations.Registration{
+			"app-guid": {
+				{Name: "metrics-endpoint-metrics", Type: "metrics-endpoint", Config: "/metrics", NumberOfBindings: 1},
+				{Name: "secure-endpoint-8081-metrics", Type: "secure-endpoint", Config: ":8081/metrics", NumberOfBindings: 2, Managed: true},
+			},
+		}
+		cliConn = newMockCliConnection()
+		cliConn.getAppsResult = []plugin_models.GetAppsModel{
+			{Name: "app-name", Guid: "app-guid"},
+		}
+		writer = newSpyWriter()
+	})
+
+	It("writes JSON records", func() {
+		err := command.ListRegisteredMetricsEndpoints(writer, registrationFetcher, cliConn, newMockAppSelector(), command.ListFilter{}, command.OutputOptions{Output: "json"})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(string(writer.bytes)).To(MatchJSON(`[
+  {
+    "app_name": "app-name",
+    "app_guid": "app-guid",
+    "service_name": "metrics-endpoint-metrics",
+    "type": "metrics-endpoint",
+    "path": "/metrics",
+    "bindings": 1,
+    "managed": false
+  },
+  {
+    "app_name": "app-name",
+    "app_guid": "app-guid",
+    "service_name": "secure-endpoint-8081-metrics",
+    "type": "secure-endpoint",
+    "port": 8081,
+    "path": "/metrics",
+    "bindings": 2,
+    "managed": true
+  }
+]`))
+	})
+
+	It("writes an empty JSON list when nothing is registered", func() {
+		registrationFetcher.registrations = map[string][]registrations.Registration{}
+
+		err := command.ListRegisteredMetricsEndpoints(writer, registrationFetcher, cliConn, newMockAppSelector(), command.ListFilter{}, command.OutputOptions{Output: "json"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(writer.bytes)).To(MatchJSON(`[]`))
+	})
+
+	It("writes YAML records", func() {
+		registrationFetcher.registrations = map[string][]registrations.Registration{
+			"app-guid": {{Name: "structured-format-json", Type: "structured-format", Config: "json", NumberOfBindings: 1}},
+		}
+
+		err := command.ListRegisteredLogFormats(writer, registrationFetcher, cliConn, newMockAppSelector(), command.ListFilter{}, command.OutputOptions{Output: "yaml"})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(string(writer.bytes)).To(MatchYAML(`
+- app_name: app-name
+  app_guid: app-guid
+  service_name: structured-format-json
+  type: structured-format
+  log_format: json
+  bindings: 1
+  managed: false
+`))
+	})
+
+	It("lists only the services the plugin created with --managed-only", func() {
+		err := command.ListRegisteredMetricsEndpoints(writer, registrationFetcher, cliConn, newMockAppSelector(), command.ListFilter{ManagedOnly: true}, command.OutputOptions{Columns: "service,managed"})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(writer.lines()).To(Equal([]string{
+			"Service                       Managed",
+			"secure-endpoint-8081-metrics  true",
+			"",
+		}))
+	})
+
+	It("writes CSV records", func() {
+		err := command.ListRegisteredMetricsEndpoints(writer, registrationFetcher, cliConn, newMockAppSelector(), command.ListFilter{}, command.OutputOptions{Output: "csv"})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(writer.lines()).To(Equal([]string{
+			"app_name,app_guid,service_name,type,log_format,port,path,bindings,managed",
+			"app-name,app-guid,metrics-endpoint-metrics,metrics-endpoint,,,/metrics,1,false",
+			"app-name,app-guid,secure-endpoint-8081-metrics,secure-endpoint,,8081,/metrics,2,true",
+			"",
+		}))
+	})
+
+	It("writes each record with a template", func() {
+		err := command.ListRegisteredMetricsEndpoints(writer, registrationFetcher, cliConn, newMockAppSelector(), command.ListFilter{}, command.OutputOptions{Format: "{{.AppName}} {{.Port}} {{.Path}}"})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(writer.lines()).To(Equal([]string{
+			"app-name 0 /metrics",
+			"app-name 8081 /metrics",
+			"",
+		}))
+	})
+
+	It("orders records by app name", func() {
+		registrationFetcher.registrations["app-guid-2"] = []registrations.Registration{
+			{Name: "metrics-endpoint-metrics", Type: "metrics-endpoint", Config: "/metrics", NumberOfBindings: 1},
+		}
+		cliConn.getAppsResult = []plugin_models.GetAppsModel{
+			{Name: "z-app", Guid: "app-guid"},
+			{Name: "a-app", Guid: "app-guid-2"},
+		}
+
+		err := command.ListRegisteredMetricsEndpoints(writer, registrationFetcher, cliConn, newMockAppSelector(), command.ListFilter{}, command.OutputOptions{Format: "{{.AppName}} {{.Path}}"})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(writer.lines()).To(Equal([]string{
+			"a-app /metrics",
+			"z-app /metrics",
+			"z-app /metrics",
+			"",
+		}))
+	})
+
+	It("sorts by the given column", func() {
+		err := command.ListRegisteredMetricsEndpoints(writer, registrationFetcher, cliConn, newMockAppSelector(), command.ListFilter{}, command.OutputOptions{Sort: "bindings", Columns: "service,bindings"})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(writer.lines()).To(Equal([]string{
+			"Service                       Bindings",
+			"metrics-endpoint-metrics      1",
+			"secure-endpoint-8081-metrics  2",
+			"",
+		}))
+
+		writer = newSpyWriter()
+		err = command.ListRegisteredMetricsEndpoints(writer, registrationFetcher, cliConn, newMockAppSelector(), command.ListFilter{}, command.OutputOptions{Sort: "port", Columns: "port,path"})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(writer.lines()).To(Equal([]string{
+			"Port  Path",
+			"      /metrics",
+			"8081  /metrics",
+			"",
+		}))
+	})
+
+	It("shows the selected columns", func() {
+		err := command.ListRegisteredMetricsEndpoints(writer, registrationFetcher, cliConn, newMockAppSelector(), command.ListFilter{}, command.OutputOptions{Columns: "App, GUID,type"})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(writer.lines()).To(Equal([]string{
+			"App       App GUID  Type",
+			"app-name  app-guid  metrics-endpoint",
+			"app-name  app-guid  secure-endpoint",
+			"",
+		}))
+	})
+
+	DescribeTable("errors", func(opts command.OutputOptions) {
+		err := command.ListRegisteredMetricsEndpoints(writer, registrationFetcher, cliConn, newMockAppSelector(), command.ListFilter{}, opts)
+		Expect(err).To(HaveOccurred())
+	},
+		Entry("unknown output", command.OutputOptions{Output: "xml"}),
+		Entry("invalid template", command.OutputOptions{Format: "{{.AppName"}),
+		Entry("unknown template field", command.OutputOptions{Format: "{{.Nope}}"}),
+		Entry("output and format together", command.OutputOptions{Output: "json", Format: "{{.AppName}}"}),
+		Entry("unknown sort column", command.OutputOptions{Sort: "nope"}),
+		Entry("unknown column", command.OutputOptions{Columns: "app,nope"}),
+		Entry("columns without table output", command.OutputOptions{Output: "json", Columns: "app"}),
+	)
+})
+
 type spyWriter struct {
 	bytes    []byte
 	writeErr error
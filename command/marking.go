@@ -0,0 +1,41 @@
+package command
+
+import (
+	"fmt"
+	"strings"
+
+	"code.cloudfoundry.org/cli/plugin"
+)
+
+// serviceMarker records on a service instance that the plugin created it.
+type serviceMarker interface {
+	Mark(serviceName, registrationType string) error
+}
+
+// MarkingConnection labels the registration services created through it. A
+// service that can't be labelled is deleted again, so the registration fails
+// and is rolled back like any other failure.
+type MarkingConnection struct {
+	plugin.CliConnection
+	marker serviceMarker
+}
+
+func NewMarkingConnection(conn plugin.CliConnection, marker serviceMarker) *MarkingConnection {
+	return &MarkingConnection{CliConnection: conn, marker: marker}
+}
+
+func (c *MarkingConnection) CliCommandWithoutTerminalOutput(args ...string) ([]string, error) {
+	output, err := c.CliConnection.CliCommandWithoutTerminalOutput(args...)
+	if err != nil || len(args) != 4 || args[0] != "create-user-provided-service" || args[2] != "-l" {
+		return output, err
+	}
+
+	serviceName := args[1]
+	registrationType := strings.SplitN(args[3], "://", 2)[0]
+	err = c.marker.Mark(serviceName, registrationType)
+	if err != nil {
+		c.CliConnection.CliCommandWithoutTerminalOutput("delete-service", serviceName, "-f") //nolint:errcheck
+		return nil, fmt.Errorf("failed to label service %s: %s", serviceName, err)
+	}
+	return output, nil
+}
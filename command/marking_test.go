@@ -0,0 +1,68 @@
+package command_test
+
+import (
+	"errors"
+
+	"github.com/pivotal-cf/metric-registrar-cli/command"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("MarkingConnection", func() {
+	var (
+		cliConn *mockCliConnection
+		marker  *mockServiceMarker
+		conn    *command.MarkingConnection
+	)
+
+	BeforeEach(func() {
+		cliConn = newMockCliConnection()
+		marker = &mockServiceMarker{marked: make(chan []string, 10)}
+		conn = command.NewMarkingConnection(&pluginConnection{mock: cliConn}, marker)
+	})
+
+	It("labels the services it creates with their registration type", func() {
+		_, err := conn.CliCommandWithoutTerminalOutput("create-user-provided-service", "service-name", "-l", "secure-endpoint://:2112/metrics")
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(cliConn.cliCommandsCalled).To(Receive(Equal([]string{"create-user-provided-service", "service-name", "-l", "secure-endpoint://:2112/metrics"})))
+		Expect(marker.marked).To(Receive(Equal([]string{"service-name", "secure-endpoint"})))
+	})
+
+	It("only labels new services", func() {
+		_, err := conn.CliCommandWithoutTerminalOutput("bind-service", "app-name", "service-name")
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(cliConn.cliCommandsCalled).To(Receive())
+		Expect(marker.marked).ToNot(Receive())
+	})
+
+	It("doesn't label a service that wasn't created", func() {
+		cliConn.cliErrorCommand = "create-user-provided-service"
+
+		_, err := conn.CliCommandWithoutTerminalOutput("create-user-provided-service", "service-name", "-l", "structured-format://json")
+		Expect(err).To(MatchError("error"))
+		Expect(marker.marked).ToNot(Receive())
+	})
+
+	It("deletes the service again if labelling fails", func() {
+		marker.err = errors.New("expected")
+
+		_, err := conn.CliCommandWithoutTerminalOutput("create-user-provided-service", "service-name", "-l", "structured-format://json")
+		Expect(err).To(MatchError("failed to label service service-name: expected"))
+
+		Expect(cliConn.cliCommandsCalled).To(Receive())
+		Expect(cliConn.cliCommandsCalled).To(Receive(Equal([]string{"delete-service", "service-name", "-f"})))
+	})
+})
+
+type mockServiceMarker struct {
+	marked chan []string
+	err    error
+}
+
+func (m *mockServiceMarker) Mark(serviceName, registrationType string) error {
+	m.marked <- []string{serviceName, registrationType}
+	return m.err
+}
@@ -33,7 +33,7 @@ func buildCommands() []plugin.Command {
 
 func buildOptions(c Command) map[string]string {
 	opts := map[string]string{}
-	for flag, opt := range c.Options {
+	for flag, opt := range c.allOptions() {
 		opts[flag] = opt.Description
 	}
 	return opts
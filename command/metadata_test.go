@@ -27,6 +27,14 @@ var _ = Describe("Metadata", func() {
 				gstruct.MatchFields(gstruct.IgnoreExtras, gstruct.Fields{"Name": Equal("unregister-metrics-endpoint")}),
 				gstruct.MatchFields(gstruct.IgnoreExtras, gstruct.Fields{"Name": Equal("registered-log-formats")}),
 				gstruct.MatchFields(gstruct.IgnoreExtras, gstruct.Fields{"Name": Equal("registered-metrics-endpoints")}),
+				gstruct.MatchFields(gstruct.IgnoreExtras, gstruct.Fields{"Name": Equal("plan-registrations")}),
+				gstruct.MatchFields(gstruct.IgnoreExtras, gstruct.Fields{"Name": Equal("apply-registrations")}),
+				gstruct.MatchFields(gstruct.IgnoreExtras, gstruct.Fields{"Name": Equal("export-registrations")}),
+				gstruct.MatchFields(gstruct.IgnoreExtras, gstruct.Fields{"Name": Equal("migrate-service-names")}),
+				gstruct.MatchFields(gstruct.IgnoreExtras, gstruct.Fields{"Name": Equal("update-metrics-endpoint")}),
+				gstruct.MatchFields(gstruct.IgnoreExtras, gstruct.Fields{"Name": Equal("migrate-to-secure")}),
+				gstruct.MatchFields(gstruct.IgnoreExtras, gstruct.Fields{"Name": Equal("metric-registrar-doctor")}),
+				gstruct.MatchFields(gstruct.IgnoreExtras, gstruct.Fields{"Name": Equal("cleanup-orphans")}),
 			))
 		})
 	})
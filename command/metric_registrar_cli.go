@@ -1,9 +1,11 @@
 package command
 
 import (
+	"errors"
 	"fmt"
 	"os"
 
+	"github.com/pivotal-cf/metric-registrar-cli/cloudcontroller"
 	"github.com/pivotal-cf/metric-registrar-cli/registrations"
 
 	"code.cloudfoundry.org/cli/plugin"
@@ -28,6 +30,23 @@ type MetricRegistrarCli struct {
 type registrationFetcher interface {
 	Fetch(string, string) ([]registrations.Registration, error)
 	FetchAll(...string) (map[string][]registrations.Registration, error)
+	FetchInstances(...string) ([]registrations.Registration, error)
+	FetchUnbound(...string) ([]registrations.Registration, error)
+	FetchUnparseable(...string) (map[string][]registrations.Registration, error)
+}
+
+// portManager exposes app ports and records which of them the plugin opened,
+// so that unregistering never closes a port the app exposed itself.
+type portManager interface {
+	GetPortsForApp(string) ([]int, error)
+	SetPortsForApp(string, []int) error
+	GetOpenedPortsForApp(string) ([]int, error)
+	SetOpenedPortsForApp(string, []int) error
+}
+
+// appSelector finds apps by their Cloud Controller labels.
+type appSelector interface {
+	SelectApps(labelSelector string) ([]string, error)
 }
 
 type cliCommandRunner interface {
@@ -41,7 +60,44 @@ func (c MetricRegistrarCli) Run(cliConnection plugin.CliConnection, args []strin
 	command := command(args)
 	parseArgs(command, args)
 
-	exitIfErr(command.Run(registrations.NewFetcher(cliConnection), cliConnection))
+	client, err := cloudcontroller.NewClient(
+		cliConnection,
+		cloudcontroller.WithMaxRetries(globalFlags.MaxRetries),
+		cloudcontroller.WithTimeout(globalFlags.Timeout),
+	)
+	exitIfErr(err)
+
+	caps, err := cloudcontroller.Probe(client)
+	exitIfErr(err)
+
+	fetcher := newFetcher(client, cliConnection, caps, registrations.WithConcurrency(globalFlags.Concurrency))
+	portManager := newPortManager(client, caps)
+	selector := newAppSelector(client, cliConnection, caps)
+	if caps.Supports(cloudcontroller.ServiceInstances) {
+		marker, err := c.newMarker(client, cliConnection)
+		exitIfErr(err)
+		cliConnection = NewMarkingConnection(cliConnection, marker)
+	}
+	// the changes a dry run would make go to stderr so that the command's
+	// own output, such as JSON, stays readable
+	if globalFlags.DryRun {
+		cliConnection = NewDryRunConnection(cliConnection, os.Stderr)
+		portManager = NewDryRunPortManager(portManager, os.Stderr)
+	}
+
+	exitIfErr(command.Run(fetcher, portManager, selector, cliConnection))
+}
+
+// newMarker records the plugin's version and the user running it on the
+// services the plugin creates.
+func (c MetricRegistrarCli) newMarker(client *cloudcontroller.Client, conn plugin.CliConnection) (*registrations.Marker, error) {
+	username, err := conn.Username()
+	if err != nil {
+		return nil, err
+	}
+
+	version := fmt.Sprintf("%d.%d.%d", c.Major, c.Minor, c.Patch)
+	return registrations.NewMarker(client, conn, version, username), nil
 }
 
 func command(args []string) Command {
@@ -61,6 +117,10 @@ func command(args []string) Command {
 
 func parseArgs(command Command, args []string) {
 	parser := flags.NewParser(command.Flags, flags.HelpFlag)
+	_, err := parser.AddGroup("Global Options", "", globalFlags)
+	if err != nil {
+		exitUsage(err.Error(), command.Usage())
+	}
 
 	remainingArgs, err := parser.ParseArgs(args[1:])
 	if err != nil {
@@ -70,6 +130,12 @@ func parseArgs(command Command, args []string) {
 	if len(remainingArgs) != 0 {
 		exitUsage("too many arguments", command.Usage())
 	}
+
+	if command.Validate != nil {
+		if err := command.Validate(); err != nil {
+			exitUsage(err.Error(), command.Usage())
+		}
+	}
 }
 
 func exitUsage(message, usage string) {
@@ -79,6 +145,11 @@ func exitUsage(message, usage string) {
 }
 
 func exitIfErr(err error) {
+	var code ExitCode
+	if errors.As(err, &code) {
+		os.Exit(int(code))
+	}
+
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
@@ -0,0 +1,55 @@
+package command
+
+import (
+	"fmt"
+	"io"
+)
+
+// MigrateServiceNames renames the services that earlier versions of the plugin
+// named for a registration. Services with any other name are left alone.
+func MigrateServiceNames(writer io.Writer, fetcher registrationFetcher, cliConn cliCommandRunner) error {
+	instances, err := fetcher.FetchInstances(structuredFormat, metricsEndpoint, secureEndpoint)
+	if err != nil {
+		return err
+	}
+
+	services, err := cliConn.GetServices()
+	if err != nil {
+		return err
+	}
+	taken := map[string]bool{}
+	for _, s := range services {
+		taken[s.Name] = true
+	}
+
+	renamed := 0
+	for _, r := range instances {
+		if r.Name != legacyServiceName(r.Type, r.Config) {
+			continue
+		}
+
+		name := generateServiceName(r.Type, r.Config)
+		if taken[name] {
+			return fmt.Errorf("unable to rename %s to %s: a service with that name already exists", r.Name, name)
+		}
+
+		_, err = fmt.Fprintf(writer, "rename %s to %s\n", r.Name, name)
+		if err != nil {
+			return err
+		}
+
+		_, err = cliConn.CliCommandWithoutTerminalOutput("rename-service", r.Name, name)
+		if err != nil {
+			return err
+		}
+		taken[name] = true
+		renamed++
+	}
+
+	summary := "Renamed %d services.\n"
+	if isDryRun(cliConn) {
+		summary = "Would rename %d services.\n"
+	}
+	_, err = fmt.Fprintf(writer, summary, renamed)
+	return err
+}
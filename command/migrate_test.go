@@ -0,0 +1,80 @@
+package command_test
+
+import (
+	"strings"
+
+	plugin_models "code.cloudfoundry.org/cli/plugin/models"
+	"github.com/pivotal-cf/metric-registrar-cli/command"
+	"github.com/pivotal-cf/metric-registrar-cli/registrations"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("MigrateServiceNames", func() {
+	var (
+		writer              *spyWriter
+		registrationFetcher *mockRegistrationFetcher
+		cliConn             *mockCliConnection
+	)
+
+	BeforeEach(func() {
+		writer = newSpyWriter()
+		registrationFetcher = newMockRegistrationFetcher()
+		cliConn = newMockCliConnection()
+	})
+
+	It("renames services with legacy names", func() {
+		registrationFetcher.instances = []registrations.Registration{
+			{Name: "secure-endpoint-2112-metrics", Type: "secure-endpoint", Config: ":2112/metrics"},
+			{Name: "metrics-endpoint-E9lWAz2a9Em_4sTveMF8IEacS_E", Type: "metrics-endpoint", Config: "/" + strings.Repeat("a", 60)},
+		}
+
+		Expect(command.MigrateServiceNames(writer, registrationFetcher, cliConn)).To(Succeed())
+
+		Expect(cliConn.cliCommandsCalled).To(Receive(Equal([]string{"rename-service", "secure-endpoint-2112-metrics", "secure-endpoint-2112-metrics-fdae1312e3715d76"})))
+		Expect(cliConn.cliCommandsCalled).To(Receive(Equal([]string{"rename-service", "metrics-endpoint-E9lWAz2a9Em_4sTveMF8IEacS_E", "metrics-endpoint-" + strings.Repeat("a", 60) + "-3e840ecd02e74407"})))
+		Expect(cliConn.cliCommandsCalled).ToNot(Receive())
+		Expect(writer.lines()).To(Equal([]string{
+			"rename secure-endpoint-2112-metrics to secure-endpoint-2112-metrics-fdae1312e3715d76",
+			"rename metrics-endpoint-E9lWAz2a9Em_4sTveMF8IEacS_E to metrics-endpoint-" + strings.Repeat("a", 60) + "-3e840ecd02e74407",
+			"Renamed 2 services.",
+			"",
+		}))
+	})
+
+	It("leaves services with other names alone", func() {
+		registrationFetcher.instances = []registrations.Registration{
+			{Name: "secure-endpoint-2112-metrics-fdae1312e3715d76", Type: "secure-endpoint", Config: ":2112/metrics"},
+			{Name: "my-metrics", Type: "secure-endpoint", Config: ":2112/metrics"},
+		}
+
+		Expect(command.MigrateServiceNames(writer, registrationFetcher, cliConn)).To(Succeed())
+
+		Expect(cliConn.cliCommandsCalled).ToNot(Receive())
+		Expect(writer.lines()).To(Equal([]string{"Renamed 0 services.", ""}))
+	})
+
+	It("returns an error if the new name is taken", func() {
+		registrationFetcher.instances = []registrations.Registration{
+			{Name: "secure-endpoint-2112-metrics", Type: "secure-endpoint", Config: ":2112/metrics"},
+		}
+		cliConn.getServicesResult = []plugin_models.GetServices_Model{
+			{Name: "secure-endpoint-2112-metrics"},
+			{Name: "secure-endpoint-2112-metrics-fdae1312e3715d76"},
+		}
+
+		err := command.MigrateServiceNames(writer, registrationFetcher, cliConn)
+		Expect(err).To(MatchError("unable to rename secure-endpoint-2112-metrics to secure-endpoint-2112-metrics-fdae1312e3715d76: a service with that name already exists"))
+		Expect(cliConn.cliCommandsCalled).ToNot(Receive())
+	})
+
+	It("returns an error if renaming fails", func() {
+		registrationFetcher.instances = []registrations.Registration{
+			{Name: "secure-endpoint-2112-metrics", Type: "secure-endpoint", Config: ":2112/metrics"},
+		}
+		cliConn.cliErrorCommand = "rename-service"
+
+		Expect(command.MigrateServiceNames(writer, registrationFetcher, cliConn)).ToNot(Succeed())
+	})
+})
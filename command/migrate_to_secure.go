@@ -0,0 +1,201 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/pivotal-cf/metric-registrar-cli/registrations"
+)
+
+type migrationResult struct {
+	appName string
+	from    string
+	to      string
+	err     error
+}
+
+// MigrateToSecure replaces the insecure metrics endpoints of an app, or of
+// every app in the space when appName is empty, with secure endpoints on
+// internalPort. All of an app's secure endpoints are registered before any
+// insecure one is removed, and they are rolled back if one fails. A failed
+// app doesn't stop the others from being migrated.
+func MigrateToSecure(writer io.Writer, fetcher registrationFetcher, cliConn cliCommandRunner, portManager portManager, appName, internalPort string) error {
+	port, err := strconv.Atoi(internalPort)
+	if err != nil {
+		return fmt.Errorf("invalid --internal-port %q", internalPort)
+	}
+
+	if appName == "" {
+		// an insecure service can be shared by several apps, it is only
+		// deleted once the last of them has been migrated
+		fetcher = newSnapshotFetcher(fetcher)
+	}
+
+	insecure, err := insecureEndpointsByApp(fetcher, cliConn, appName)
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	for name := range insecure {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var results []migrationResult
+	for _, name := range names {
+		results = append(results, migrateAppToSecure(writer, fetcher, cliConn, portManager, name, insecure[name], port)...)
+	}
+
+	return writeMigrationReport(writer, results, isDryRun(cliConn))
+}
+
+// insecureEndpointsByApp returns the insecure metrics endpoints keyed by app
+// name.
+func insecureEndpointsByApp(fetcher registrationFetcher, cliConn cliCommandRunner, appName string) (map[string][]registrations.Registration, error) {
+	if appName != "" {
+		app, err := cliConn.GetApp(appName)
+		if err != nil {
+			return nil, err
+		}
+
+		regs, err := fetcher.Fetch(app.Guid, metricsEndpoint)
+		if err != nil {
+			return nil, err
+		}
+		return map[string][]registrations.Registration{appName: regs}, nil
+	}
+
+	apps, err := cliConn.GetApps()
+	if err != nil {
+		return nil, err
+	}
+
+	byName := map[string][]registrations.Registration{}
+	for _, app := range apps {
+		regs, err := fetcher.Fetch(app.Guid, metricsEndpoint)
+		if err != nil {
+			return nil, err
+		}
+		if len(regs) > 0 {
+			byName[app.Name] = regs
+		}
+	}
+	return byName, nil
+}
+
+func migrateAppToSecure(writer io.Writer, fetcher registrationFetcher, cliConn cliCommandRunner, portManager portManager, appName string, insecure []registrations.Registration, port int) []migrationResult {
+	results := make([]migrationResult, len(insecure))
+	for i, r := range insecure {
+		results[i] = migrationResult{appName: appName, from: r.Type + "://" + r.Config}
+	}
+	failAll := func(err error) []migrationResult {
+		for i := range results {
+			if results[i].err == nil {
+				results[i].err = err
+			}
+		}
+		return results
+	}
+
+	app, err := cliConn.GetApp(appName)
+	if err != nil {
+		return failAll(err)
+	}
+
+	configs := make([]string, len(insecure))
+	migrating := false
+	for i, r := range insecure {
+		if r.Config == "" {
+			results[i].err = fmt.Errorf("can't parse the endpoint of %s", r.Name)
+			continue
+		}
+
+		route, err := validateRouteForApp(r.Config, app, false)
+		if err != nil {
+			return failAll(err)
+		}
+
+		path := route.Path
+		if path == "" {
+			path = "/"
+		}
+		configs[i] = ":" + strconv.Itoa(port) + path
+		results[i].to = secureEndpoint + "://" + configs[i]
+		migrating = true
+	}
+	if !migrating {
+		return results
+	}
+
+	undo := &rollback{}
+	exposed, err := exposePortForApp(portManager, app.Guid, port)
+	if exposed {
+		undo.add(fmt.Sprintf("remove port %d from %s", port, appName), func() error {
+			return unexposePortForApp(portManager, app.Guid, port)
+		})
+	}
+	if err != nil {
+		undo.run(writer, appName, err) //nolint:errcheck
+		return failAll(err)
+	}
+
+	registered := map[string]bool{}
+	for _, config := range configs {
+		// different routes to the app can lead to the same path
+		if config == "" || registered[config] {
+			continue
+		}
+		registered[config] = true
+
+		serviceName, err := findRegistration(fetcher, app.Guid, secureEndpoint, config)
+		if err == nil && serviceName == "" {
+			_, err = ensureServiceAndBind(cliConn, fetcher, undo, appName, secureEndpoint, config)
+		}
+		if err != nil {
+			undo.run(writer, appName, err) //nolint:errcheck
+			return failAll(err)
+		}
+	}
+
+	for i, r := range insecure {
+		if results[i].err == nil {
+			_, results[i].err = removeRegistration(fetcher, appName, r, cliConn, &unregisterReport{})
+		}
+	}
+	return results
+}
+
+func writeMigrationReport(writer io.Writer, results []migrationResult, dryRun bool) error {
+	if len(results) == 0 {
+		_, err := fmt.Fprintln(writer, "No insecure metrics endpoints found.")
+		return err
+	}
+
+	w := tabwriter.NewWriter(writer, 0, 8, 2, ' ', tabwriter.StripEscape)
+	writeFields(w, "App", "From", "To", "Result") //nolint:errcheck
+	failed := 0
+	for _, r := range results {
+		status := "migrated"
+		if dryRun {
+			status = "would migrate"
+		}
+		if r.err != nil {
+			status = "failed: " + r.err.Error()
+			failed++
+		}
+		writeFields(w, r.appName, r.from, r.to, status) //nolint:errcheck
+	}
+	err := w.Flush()
+	if err != nil {
+		return err
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d endpoints failed to migrate", failed, len(results))
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package command_test
+
+import (
+	"errors"
+
+	plugin_models "code.cloudfoundry.org/cli/plugin/models"
+	"github.com/pivotal-cf/metric-registrar-cli/command"
+	"github.com/pivotal-cf/metric-registrar-cli/registrations"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("MigrateToSecure", func() {
+	var (
+		writer              *spyWriter
+		registrationFetcher *mockRegistrationFetcher
+		portManager         *mockPortManager
+		cliConn             *mockCliConnection
+	)
+
+	BeforeEach(func() {
+		writer = newSpyWriter()
+		registrationFetcher = newMockRegistrationFetcher()
+		registrationFetcher.registrations["app-guid"] = []registrations.Registration{
+			{Name: "insecure-relative", Type: "metrics-endpoint", Config: "/metrics", NumberOfBindings: 1},
+			{Name: "insecure-route", Type: "metrics-endpoint", Config: "app-host.app-domain/app-path/metrics", NumberOfBindings: 2},
+			{Name: "secure", Type: "secure-endpoint", Config: ":9090/metrics", NumberOfBindings: 1},
+		}
+		portManager = newMockPortManager()
+		cliConn = newMockCliConnection()
+	})
+
+	It("registers secure endpoints before removing the insecure ones", func() {
+		Expect(command.MigrateToSecure(writer, registrationFetcher, cliConn, portManager, "app-name", "2112")).To(Succeed())
+
+		Expect(portManager.setPortsCalled).To(Receive(Equal([]int{8080, 2112})))
+		Expect(portManager.openedPorts).To(Equal([]int{2112}))
+		Expect(cliConn.cliCommandsCalled).To(Receive(Equal([]string{"create-user-provided-service", "secure-endpoint-2112-metrics-fdae1312e3715d76", "-l", "secure-endpoint://:2112/metrics"})))
+		Expect(cliConn.cliCommandsCalled).To(Receive(Equal([]string{"bind-service", "app-name", "secure-endpoint-2112-metrics-fdae1312e3715d76"})))
+		Expect(cliConn.cliCommandsCalled).To(Receive(Equal([]string{"create-user-provided-service", "secure-endpoint-2112-app-path-metrics-167665f106aa68b1", "-l", "secure-endpoint://:2112/app-path/metrics"})))
+		Expect(cliConn.cliCommandsCalled).To(Receive(Equal([]string{"bind-service", "app-name", "secure-endpoint-2112-app-path-metrics-167665f106aa68b1"})))
+		Expect(cliConn.cliCommandsCalled).To(Receive(Equal([]string{"unbind-service", "app-name", "insecure-relative"})))
+		Expect(cliConn.cliCommandsCalled).To(Receive(Equal([]string{"delete-service", "insecure-relative", "-f"})))
+		Expect(cliConn.cliCommandsCalled).To(Receive(Equal([]string{"unbind-service", "app-name", "insecure-route"})))
+		Expect(cliConn.cliCommandsCalled).ToNot(Receive())
+
+		Expect(writer.lines()).To(Equal([]string{
+			"App       From                                                     To                                        Result",
+			"app-name  metrics-endpoint:///metrics                              secure-endpoint://:2112/metrics           migrated",
+			"app-name  metrics-endpoint://app-host.app-domain/app-path/metrics  secure-endpoint://:2112/app-path/metrics  migrated",
+			"",
+		}))
+	})
+
+	It("migrates every app in the space when no app is given", func() {
+		cliConn.getAppsResult = []plugin_models.GetAppsModel{
+			{Name: "app-name", Guid: "app-guid"},
+			{Name: "other-app", Guid: "other-guid"},
+		}
+
+		Expect(command.MigrateToSecure(writer, registrationFetcher, cliConn, portManager, "", "2112")).To(Succeed())
+
+		Expect(writer.lines()).To(HaveLen(4))
+		Expect(writer.lines()[1]).To(HavePrefix("app-name  metrics-endpoint:///metrics"))
+	})
+
+	It("deletes an insecure service shared by several apps once the last app is migrated", func() {
+		shared := registrations.Registration{Name: "insecure-shared", Type: "metrics-endpoint", Config: "/metrics", NumberOfBindings: 2}
+		registrationFetcher.registrations = map[string][]registrations.Registration{
+			"a-guid": {shared},
+			"b-guid": {shared},
+		}
+		cliConn.getAppsResult = []plugin_models.GetAppsModel{
+			{Name: "app-a", Guid: "a-guid"},
+			{Name: "app-b", Guid: "b-guid"},
+		}
+		cliConn.getAppResults = map[string]plugin_models.GetAppModel{
+			"app-a": {Name: "app-a", Guid: "a-guid"},
+			"app-b": {Name: "app-b", Guid: "b-guid"},
+		}
+		portManager.appGuids = []string{"a-guid", "b-guid"}
+
+		Expect(command.MigrateToSecure(writer, registrationFetcher, cliConn, portManager, "", "2112")).To(Succeed())
+
+		Expect(cliConn.cliCommandsCalled).To(Receive(Equal([]string{"create-user-provided-service", "secure-endpoint-2112-metrics-fdae1312e3715d76", "-l", "secure-endpoint://:2112/metrics"})))
+		Expect(cliConn.cliCommandsCalled).To(Receive(Equal([]string{"bind-service", "app-a", "secure-endpoint-2112-metrics-fdae1312e3715d76"})))
+		Expect(cliConn.cliCommandsCalled).To(Receive(Equal([]string{"unbind-service", "app-a", "insecure-shared"})))
+		Expect(cliConn.cliCommandsCalled).To(Receive(Equal([]string{"bind-service", "app-b", "secure-endpoint-2112-metrics-fdae1312e3715d76"})))
+		Expect(cliConn.cliCommandsCalled).To(Receive(Equal([]string{"unbind-service", "app-b", "insecure-shared"})))
+		Expect(cliConn.cliCommandsCalled).To(Receive(Equal([]string{"delete-service", "insecure-shared", "-f"})))
+		Expect(cliConn.cliCommandsCalled).ToNot(Receive())
+	})
+
+	It("reports endpoints without a config as failed and migrates the others", func() {
+		registrationFetcher.registrations["app-guid"] = []registrations.Registration{
+			{Name: "insecure-empty", Type: "metrics-endpoint", Config: "", NumberOfBindings: 1},
+			{Name: "insecure-relative", Type: "metrics-endpoint", Config: "/metrics", NumberOfBindings: 1},
+		}
+
+		err := command.MigrateToSecure(writer, registrationFetcher, cliConn, portManager, "app-name", "2112")
+		Expect(err).To(MatchError("1 of 2 endpoints failed to migrate"))
+
+		Expect(cliConn.cliCommandsCalled).To(Receive(Equal([]string{"create-user-provided-service", "secure-endpoint-2112-metrics-fdae1312e3715d76", "-l", "secure-endpoint://:2112/metrics"})))
+		Expect(cliConn.cliCommandsCalled).To(Receive(Equal([]string{"bind-service", "app-name", "secure-endpoint-2112-metrics-fdae1312e3715d76"})))
+		Expect(cliConn.cliCommandsCalled).To(Receive(Equal([]string{"unbind-service", "app-name", "insecure-relative"})))
+		Expect(cliConn.cliCommandsCalled).To(Receive(Equal([]string{"delete-service", "insecure-relative", "-f"})))
+		Expect(cliConn.cliCommandsCalled).ToNot(Receive())
+		Expect(writer.lines()).To(ContainElement(HaveSuffix("failed: can't parse the endpoint of insecure-empty")))
+	})
+
+	It("reuses secure endpoints that are already registered", func() {
+		registrationFetcher.registrations["app-guid"] = []registrations.Registration{
+			{Name: "insecure-relative", Type: "metrics-endpoint", Config: "/metrics", NumberOfBindings: 1},
+			{Name: "secure", Type: "secure-endpoint", Config: ":2112/metrics", NumberOfBindings: 1},
+		}
+		portManager.exposedPorts = []int{8080, 2112}
+
+		Expect(command.MigrateToSecure(writer, registrationFetcher, cliConn, portManager, "app-name", "2112")).To(Succeed())
+
+		Expect(cliConn.cliCommandsCalled).To(Receive(Equal([]string{"unbind-service", "app-name", "insecure-relative"})))
+		Expect(cliConn.cliCommandsCalled).To(Receive(Equal([]string{"delete-service", "insecure-relative", "-f"})))
+		Expect(cliConn.cliCommandsCalled).ToNot(Receive())
+		Expect(portManager.setPortsCalled).ToNot(Receive())
+	})
+
+	It("rolls back the app and keeps its insecure endpoints if registering fails", func() {
+		cliConn.cliErrorCommand = "bind-service"
+
+		err := command.MigrateToSecure(writer, registrationFetcher, cliConn, portManager, "app-name", "2112")
+		Expect(err).To(MatchError("2 of 2 endpoints failed to migrate"))
+
+		Expect(cliConn.cliCommandsCalled).To(Receive(Equal([]string{"create-user-provided-service", "secure-endpoint-2112-metrics-fdae1312e3715d76", "-l", "secure-endpoint://:2112/metrics"})))
+		Expect(cliConn.cliCommandsCalled).To(Receive(Equal([]string{"bind-service", "app-name", "secure-endpoint-2112-metrics-fdae1312e3715d76"})))
+		Expect(cliConn.cliCommandsCalled).To(Receive(Equal([]string{"delete-service", "secure-endpoint-2112-metrics-fdae1312e3715d76", "-f"})))
+		Expect(cliConn.cliCommandsCalled).ToNot(Receive())
+		Expect(portManager.exposedPorts).To(Equal([]int{8080}))
+		Expect(writer.lines()).To(ContainElement(HaveSuffix("failed: error")))
+	})
+
+	It("reports when there is nothing to migrate", func() {
+		registrationFetcher.registrations["app-guid"] = nil
+
+		Expect(command.MigrateToSecure(writer, registrationFetcher, cliConn, portManager, "app-name", "2112")).To(Succeed())
+		Expect(writer.lines()).To(Equal([]string{"No insecure metrics endpoints found.", ""}))
+	})
+
+	It("returns an error for an invalid port", func() {
+		Expect(command.MigrateToSecure(writer, registrationFetcher, cliConn, portManager, "app-name", "abc")).To(MatchError(`invalid --internal-port "abc"`))
+	})
+
+	It("returns an error if getting the registrations fails", func() {
+		registrationFetcher.fetchError = errors.New("expected")
+
+		Expect(command.MigrateToSecure(writer, registrationFetcher, cliConn, portManager, "app-name", "2112")).To(MatchError("expected"))
+		Expect(cliConn.cliCommandsCalled).ToNot(Receive())
+	})
+})
@@ -0,0 +1,278 @@
+package command
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	plugin_models "code.cloudfoundry.org/cli/plugin/models"
+	"github.com/pivotal-cf/metric-registrar-cli/registrations"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+	outputCSV   = "csv"
+)
+
+// OutputOptions select how the list commands print registrations. Format is
+// a Go template executed once per registration. Sort names the column to
+// order by and Columns is a comma separated list of table columns.
+type OutputOptions struct {
+	Output  string
+	Format  string
+	Sort    string
+	Columns string
+}
+
+type record struct {
+	AppName     string `json:"app_name" yaml:"app_name"`
+	AppGuid     string `json:"app_guid" yaml:"app_guid"`
+	ServiceName string `json:"service_name" yaml:"service_name"`
+	Type        string `json:"type" yaml:"type"`
+	LogFormat   string `json:"log_format,omitempty" yaml:"log_format,omitempty"`
+	Port        int    `json:"port,omitempty" yaml:"port,omitempty"`
+	Path        string `json:"path,omitempty" yaml:"path,omitempty"`
+	Bindings    int    `json:"bindings" yaml:"bindings"`
+	Managed     bool   `json:"managed" yaml:"managed"`
+}
+
+var csvHeader = []string{"app_name", "app_guid", "service_name", "type", "log_format", "port", "path", "bindings", "managed"}
+
+func records(apps []plugin_models.GetAppsModel, regs map[string][]registrations.Registration) []record {
+	records := []record{}
+
+	for _, app := range apps {
+		for _, reg := range regs[app.Guid] {
+			r := record{
+				AppName:     app.Name,
+				AppGuid:     app.Guid,
+				ServiceName: reg.Name,
+				Type:        reg.Type,
+				Bindings:    reg.NumberOfBindings,
+				Managed:     reg.Managed,
+			}
+
+			if reg.Type == structuredFormat {
+				r.LogFormat = reg.Config
+			} else {
+				r.Port, r.Path = parseEndpointConfig(reg.Config)
+			}
+
+			records = append(records, r)
+		}
+	}
+
+	return records
+}
+
+// parseEndpointConfig splits a secure endpoint's ":PORT/PATH" config. Other
+// endpoint configs are a path or route without a port.
+func parseEndpointConfig(config string) (int, string) {
+	port := getPortFromConfig(config)
+	if port == -1 {
+		return 0, config
+	}
+
+	path := strings.TrimPrefix(config, ":"+strconv.Itoa(port))
+	if path == "" {
+		path = "/"
+	}
+	return port, path
+}
+
+type column struct {
+	header string
+	value  func(record) string
+	less   func(a, b record) bool
+}
+
+var columns = map[string]column{
+	"app": {
+		header: "App",
+		value:  func(r record) string { return r.AppName },
+		less:   func(a, b record) bool { return a.AppName < b.AppName },
+	},
+	"guid": {
+		header: "App GUID",
+		value:  func(r record) string { return r.AppGuid },
+		less:   func(a, b record) bool { return a.AppGuid < b.AppGuid },
+	},
+	"type": {
+		header: "Type",
+		value:  func(r record) string { return r.Type },
+		less:   func(a, b record) bool { return a.Type < b.Type },
+	},
+	"format": {
+		header: "Format",
+		value:  func(r record) string { return r.LogFormat },
+		less:   func(a, b record) bool { return a.LogFormat < b.LogFormat },
+	},
+	"port": {
+		header: "Port",
+		value: func(r record) string {
+			if r.Port == 0 {
+				return ""
+			}
+			return strconv.Itoa(r.Port)
+		},
+		less: func(a, b record) bool { return a.Port < b.Port },
+	},
+	"path": {
+		header: "Path",
+		value:  func(r record) string { return r.Path },
+		less:   func(a, b record) bool { return a.Path < b.Path },
+	},
+	"service": {
+		header: "Service",
+		value:  func(r record) string { return r.ServiceName },
+		less:   func(a, b record) bool { return a.ServiceName < b.ServiceName },
+	},
+	"bindings": {
+		header: "Bindings",
+		value:  func(r record) string { return strconv.Itoa(r.Bindings) },
+		less:   func(a, b record) bool { return a.Bindings < b.Bindings },
+	},
+	"managed": {
+		header: "Managed",
+		value:  func(r record) string { return strconv.FormatBool(r.Managed) },
+		less:   func(a, b record) bool { return !a.Managed && b.Managed },
+	},
+}
+
+func columnNames() string {
+	var names []string
+	for name := range columns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+func lookupColumn(name string) (column, error) {
+	c, ok := columns[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return column{}, fmt.Errorf("unknown column %q, must be one of %s", name, columnNames())
+	}
+	return c, nil
+}
+
+// sortRecords orders records by the given column, then by app name. The
+// sort is stable so an app's registrations keep the order they were
+// fetched in.
+func sortRecords(records []record, by string) error {
+	if by == "" {
+		by = "app"
+	}
+	c, err := lookupColumn(by)
+	if err != nil {
+		return err
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		if c.less(records[i], records[j]) {
+			return true
+		}
+		if c.less(records[j], records[i]) {
+			return false
+		}
+		return records[i].AppName < records[j].AppName
+	})
+	return nil
+}
+
+func writeRecords(writer io.Writer, records []record, opts OutputOptions, defaultColumns []string) error {
+	err := sortRecords(records, opts.Sort)
+	if err != nil {
+		return err
+	}
+
+	if opts.Columns != "" && opts.Output != "" && opts.Output != outputTable {
+		return fmt.Errorf("--columns can only be used with table output")
+	}
+
+	if opts.Format != "" {
+		if opts.Output != "" {
+			return fmt.Errorf("--output and --format can't be used together")
+		}
+		return writeTemplate(writer, records, opts.Format)
+	}
+
+	switch opts.Output {
+	case "", outputTable:
+		names := defaultColumns
+		if opts.Columns != "" {
+			names = strings.Split(opts.Columns, ",")
+		}
+		return writeTable(writer, records, names)
+	case outputJSON:
+		return writeJSON(writer, records)
+	case outputYAML:
+		return yaml.NewEncoder(writer).Encode(records)
+	case outputCSV:
+		return writeCSV(writer, records)
+	}
+
+	return fmt.Errorf("unknown output %q, must be one of %s, %s, %s or %s", opts.Output, outputTable, outputJSON, outputYAML, outputCSV)
+}
+
+func writeJSON(writer io.Writer, records []record) error {
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(records)
+}
+
+func writeCSV(writer io.Writer, records []record) error {
+	w := csv.NewWriter(writer)
+	w.Write(csvHeader) //nolint:errcheck
+
+	for _, r := range records {
+		port := ""
+		if r.Port != 0 {
+			port = strconv.Itoa(r.Port)
+		}
+
+		w.Write([]string{ //nolint:errcheck
+			r.AppName,
+			r.AppGuid,
+			r.ServiceName,
+			r.Type,
+			r.LogFormat,
+			port,
+			r.Path,
+			strconv.Itoa(r.Bindings),
+			strconv.FormatBool(r.Managed),
+		})
+	}
+
+	w.Flush()
+	return w.Error()
+}
+
+func writeTemplate(writer io.Writer, records []record, format string) error {
+	tmpl, err := template.New("format").Parse(format)
+	if err != nil {
+		return fmt.Errorf("invalid --format template: %s", err)
+	}
+
+	for _, r := range records {
+		err := tmpl.Execute(writer, r)
+		if err != nil {
+			return err
+		}
+
+		_, err = fmt.Fprintln(writer)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,136 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+)
+
+// portUpdateAttempts bounds how often a port change is written before giving
+// up on another invocation that keeps changing the same app.
+const portUpdateAttempts = 3
+
+// portChange adds and removes ports, keeping the order of the others.
+type portChange struct {
+	add    []int
+	remove []int
+}
+
+func (c portChange) apply(ports []int) []int {
+	result := withoutPorts(ports, c.remove)
+	for _, p := range c.add {
+		if !containsPort(result, p) {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// portConflictError is returned when the ports read back after a write still
+// don't include a change because another invocation kept overwriting them.
+type portConflictError struct {
+	guid     string
+	expected []int
+	actual   []int
+}
+
+func (e portConflictError) Error() string {
+	return fmt.Sprintf(
+		"ports of app %s were changed by someone else while updating them: expected [%s], found [%s]. Try again",
+		e.guid, joinPorts(e.expected), joinPorts(e.actual),
+	)
+}
+
+func updatePorts(portManager portManager, guid string, change portChange) error {
+	return updateUntilApplied(
+		guid,
+		func() ([]int, error) { return portManager.GetPortsForApp(guid) },
+		func(ports []int) error { return portManager.SetPortsForApp(guid, ports) },
+		change,
+	)
+}
+
+func updateOpenedPorts(portManager portManager, guid string, change portChange) error {
+	err := updateUntilApplied(
+		guid,
+		func() ([]int, error) { return portManager.GetOpenedPortsForApp(guid) },
+		func(ports []int) error { return portManager.SetOpenedPortsForApp(guid, ports) },
+		change,
+	)
+	if errors.Is(err, errPortOwnershipUnavailable) {
+		return nil
+	}
+	return err
+}
+
+// openedPortsForApp returns the ports the plugin opened. Where they aren't
+// recorded, all of candidates are taken to be opened by the plugin.
+func openedPortsForApp(portManager portManager, guid string, candidates []int) ([]int, error) {
+	opened, err := portManager.GetOpenedPortsForApp(guid)
+	if errors.Is(err, errPortOwnershipUnavailable) {
+		return candidates, nil
+	}
+	return opened, err
+}
+
+// updateUntilApplied applies change to the ports read by get and writes them
+// with set. The Cloud Controller can't make app or metadata updates
+// conditional on a resource version, so two invocations updating the same app
+// can overwrite each other. Instead the ports are read back after every write:
+// once the change no longer alters them it has been applied, otherwise it is
+// merged into what the other invocation wrote and written again. Nothing is
+// written when the change is already applied.
+func updateUntilApplied(guid string, get func() ([]int, error), set func([]int) error, change portChange) error {
+	var expected []int
+	for attempt := 0; ; attempt++ {
+		current, err := get()
+		if err != nil {
+			return err
+		}
+
+		desired := change.apply(current)
+		if samePorts(desired, current) {
+			return nil
+		}
+		if attempt == portUpdateAttempts {
+			return portConflictError{guid: guid, expected: expected, actual: current}
+		}
+
+		err = set(desired)
+		if err != nil {
+			return err
+		}
+		expected = desired
+	}
+}
+
+// withoutPorts returns ports, in order, minus the ones in remove.
+func withoutPorts(ports, remove []int) []int {
+	remaining := []int{}
+	for _, p := range ports {
+		if !containsPort(remove, p) {
+			remaining = append(remaining, p)
+		}
+	}
+	return remaining
+}
+
+func containsAnyPort(ports, candidates []int) bool {
+	for _, p := range candidates {
+		if containsPort(ports, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func samePorts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
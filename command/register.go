@@ -1,53 +1,194 @@
 package command
 
 import (
-	"crypto/sha1"
-	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/url"
 	"strconv"
 	"strings"
 
-	"github.com/pivotal-cf/metric-registrar-cli/ports"
-
 	pluginmodels "code.cloudfoundry.org/cli/plugin/models"
 )
 
-func RegisterLogFormat(cliConn cliCommandRunner, appName, logFormat string) error {
-	return ensureServiceAndBind(cliConn, appName, structuredFormat, logFormat)
+const (
+	statusRegistered        = "registered"
+	statusAlreadyRegistered = "already registered"
+	statusWouldRegister     = "would register"
+)
+
+// RegisterOptions select how the register commands report their result.
+// Output is empty for text or "json".
+type RegisterOptions struct {
+	Output string
+}
+
+type registerResult struct {
+	AppName     string `json:"app_name"`
+	ServiceName string `json:"service_name"`
+	Type        string `json:"type"`
+	Config      string `json:"config"`
+	Status      string `json:"status"`
+	Error       string `json:"error,omitempty"`
+}
+
+func RegisterLogFormat(writer io.Writer, fetcher registrationFetcher, cliConn cliCommandRunner, appName, logFormat string, opts RegisterOptions) error {
+	err := validateRegisterOutput(opts.Output)
+	if err != nil {
+		return err
+	}
+
+	result, err := registerLogFormat(writer, fetcher, cliConn, appName, logFormat)
+	if err != nil {
+		return err
+	}
+	return writeRegisterResult(writer, result, opts)
+}
+
+func RegisterMetricsEndpoint(writer io.Writer, fetcher registrationFetcher, cliConn cliCommandRunner, portManager portManager, appName, route, internalPort string, insecure bool, opts RegisterOptions) error {
+	err := validateMetricsEndpointFlags(internalPort, insecure, opts)
+	if err != nil {
+		return err
+	}
+
+	result, err := registerMetricsEndpoint(writer, fetcher, cliConn, portManager, appName, route, internalPort, insecure)
+	if err != nil {
+		return err
+	}
+	return writeRegisterResult(writer, result, opts)
 }
 
-func RegisterMetricsEndpoint(cliConn cliCommandRunner, appName, route, internalPort string, insecure bool) error {
-	// validate flags
+func validateMetricsEndpointFlags(internalPort string, insecure bool, opts RegisterOptions) error {
 	if internalPort == "" && !insecure {
 		return fmt.Errorf("need to pass either --internal-port or --insecure")
 	}
+	return validateRegisterOutput(opts.Output)
+}
 
+func registerLogFormat(writer io.Writer, fetcher registrationFetcher, cliConn cliCommandRunner, appName, logFormat string) (registerResult, error) {
 	app, err := cliConn.GetApp(appName)
 	if err != nil {
-		return err
+		return registerResult{}, err
+	}
+
+	result := registerResult{AppName: appName, Type: structuredFormat, Config: logFormat, Status: statusRegistered}
+	result.ServiceName, err = findRegistration(fetcher, app.Guid, structuredFormat, logFormat)
+	if err != nil {
+		return registerResult{}, err
+	}
+
+	if result.ServiceName != "" {
+		result.Status = statusAlreadyRegistered
+		return result, nil
+	}
+
+	undo := &rollback{}
+	result.ServiceName, err = ensureServiceAndBind(cliConn, fetcher, undo, appName, structuredFormat, logFormat)
+	if err != nil {
+		return registerResult{}, undo.run(writer, appName, err)
+	}
+	return dryRunResult(cliConn, result), nil
+}
+
+func registerMetricsEndpoint(writer io.Writer, fetcher registrationFetcher, cliConn cliCommandRunner, portManager portManager, appName, route, internalPort string, insecure bool) (registerResult, error) {
+	app, err := cliConn.GetApp(appName)
+	if err != nil {
+		return registerResult{}, err
 	}
 
 	validRoute, err := validateRouteForApp(route, app, !insecure)
 	if err != nil {
-		return err
+		return registerResult{}, err
 	}
 
 	serviceProtocol := metricsEndpoint
 	if !insecure {
 		route = ":" + internalPort + validRoute.Path
 		serviceProtocol = secureEndpoint
+	}
+
+	result := registerResult{AppName: appName, Type: serviceProtocol, Config: route, Status: statusAlreadyRegistered}
+	result.ServiceName, err = findRegistration(fetcher, app.Guid, serviceProtocol, route)
+	if err != nil {
+		return registerResult{}, err
+	}
+
+	undo := &rollback{}
+	if !insecure {
 		port, err := strconv.Atoi(internalPort)
 		if err != nil {
-			return err
+			return registerResult{}, err
+		}
+		exposed, err := exposePortForApp(portManager, app.Guid, port)
+		if exposed {
+			result.Status = statusRegistered
+			undo.add(fmt.Sprintf("remove port %d from %s", port, appName), func() error {
+				return unexposePortForApp(portManager, app.Guid, port)
+			})
 		}
-		err = exposePortForApp(cliConn, app.Guid, port)
 		if err != nil {
-			return err
+			return registerResult{}, undo.run(writer, appName, err)
+		}
+	}
+
+	if result.ServiceName == "" {
+		result.Status = statusRegistered
+		result.ServiceName, err = ensureServiceAndBind(cliConn, fetcher, undo, appName, serviceProtocol, route)
+		if err != nil {
+			return registerResult{}, undo.run(writer, appName, err)
+		}
+	}
+	return dryRunResult(cliConn, result), nil
+}
+
+// dryRunResult reports a registration a dry run only printed as one that
+// would be made.
+func dryRunResult(cliConn cliCommandRunner, result registerResult) registerResult {
+	if result.Status == statusRegistered && isDryRun(cliConn) {
+		result.Status = statusWouldRegister
+	}
+	return result
+}
+
+// findRegistration returns the name of the service that already registers
+// the app for the given drain, or "" if there is none.
+func findRegistration(fetcher registrationFetcher, appGuid, serviceProtocol, config string) (string, error) {
+	regs, err := fetcher.Fetch(appGuid, serviceProtocol)
+	if err != nil {
+		return "", err
+	}
+
+	for _, r := range regs {
+		if r.Config == config {
+			return r.Name, nil
 		}
 	}
+	return "", nil
+}
+
+func validateRegisterOutput(output string) error {
+	if output != "" && output != outputJSON {
+		return fmt.Errorf("unknown output %q, must be %s", output, outputJSON)
+	}
+	return nil
+}
 
-	return ensureServiceAndBind(cliConn, appName, serviceProtocol, route)
+// writeRegisterResult prints the result as JSON when asked to. Otherwise
+// only an existing registration is reported; a new one is silent like any
+// other successful CLI command.
+func writeRegisterResult(writer io.Writer, result registerResult, opts RegisterOptions) error {
+	if opts.Output == outputJSON {
+		encoder := json.NewEncoder(writer)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(result)
+	}
+
+	if result.Status == statusAlreadyRegistered {
+		_, err := fmt.Fprintf(writer, "%s is %s for %s://%s\n", result.AppName, statusAlreadyRegistered, result.Type, result.Config)
+		return err
+	}
+	return nil
 }
 
 func validateRouteForApp(requestedRoute string, app pluginmodels.GetAppModel, secure bool) (url.URL, error) {
@@ -73,28 +214,46 @@ func validateRouteForApp(requestedRoute string, app pluginmodels.GetAppModel, se
 		}
 
 		if requested.Host == route.Host && strings.HasPrefix(requested.Path, route.Path) {
-			return route, nil
+			// the router passes the whole path on to the app
+			return url.URL{Host: route.Host, Path: requested.Path}, nil
 		}
 
 	}
 	return url.URL{}, fmt.Errorf("route '%s' is not bound to app '%s'", requestedRoute, app.Name)
 }
 
-func exposePortForApp(cliConn cliCommandRunner, guid string, port int) error {
-	existingPorts, err := ports.GetPortsForApp(cliConn, guid)
+// exposePortForApp adds port to the app's ports and records that the plugin
+// opened it. It reports whether the port was exposed, which it can be even if
+// recording it fails. Ports the app already exposes are left unrecorded so
+// that unregistering doesn't close them.
+func exposePortForApp(portManager portManager, guid string, port int) (bool, error) {
+	existingPorts, err := portManager.GetPortsForApp(guid)
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	// don't need to make a PUT request if it's already exposed
-	for _, p := range existingPorts {
-		if p == port {
-			return nil
-		}
+	if containsPort(existingPorts, port) {
+		return false, nil
+	}
+
+	change := portChange{add: []int{port}}
+	err = updatePorts(portManager, guid, change)
+	if err != nil {
+		return false, err
 	}
 
-	newPorts := append(existingPorts, port)
-	return ports.SetPortsForApp(cliConn, guid, newPorts)
+	return true, updateOpenedPorts(portManager, guid, change)
+}
+
+func unexposePortForApp(portManager portManager, guid string, port int) error {
+	change := portChange{remove: []int{port}}
+	err := updatePorts(portManager, guid, change)
+	if err != nil {
+		return err
+	}
+
+	return updateOpenedPorts(portManager, guid, change)
 }
 
 func formatHost(r pluginmodels.GetApp_RouteSummary) string {
@@ -112,55 +271,95 @@ func ensureHttpsPrefix(requestedRoute string) string {
 	return "https://" + strings.Replace(requestedRoute, "https://", "", 1)
 }
 
-func ensureServiceAndBind(cliConn cliCommandRunner, appName, serviceProtocol, config string) error {
-	serviceName := generateServiceName(serviceProtocol, config)
-	exists, err := findExistingService(cliConn, serviceName)
+func ensureServiceAndBind(cliConn cliCommandRunner, fetcher registrationFetcher, undo *rollback, appName, serviceProtocol, config string) (string, error) {
+	serviceName, created, err := ensureService(cliConn, fetcher, undo, serviceProtocol, config)
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	if !exists {
-		binding := serviceProtocol + "://" + config
-		_, err = cliConn.CliCommandWithoutTerminalOutput("create-user-provided-service", serviceName, "-l", binding)
-		if err != nil {
-			return err
+	_, err = cliConn.CliCommandWithoutTerminalOutput("bind-service", appName, serviceName)
+	if err != nil && !created {
+		if batch, inBatch := fetcher.(batchSnapshot); inBatch {
+			return serviceName, releaseService(cliConn, fetcher, batch, serviceProtocol, config, serviceName, err)
 		}
 	}
 
-	_, err = cliConn.CliCommandWithoutTerminalOutput("bind-service", appName, serviceName)
-
-	return err
+	return serviceName, err
 }
 
-func generateServiceName(serviceProtocol string, config string) string {
-	cleanedConfig := sanitizeConfig(config)
-	serviceName := serviceProtocol + "-" + cleanedConfig
-	// Cloud Controller limits service name lengths:
-	// see https://github.com/cloudfoundry/cloud_controller_ng/blob/master/vendor/errors/v2.yml#L231
-	if len(serviceName) > 50 {
-		hasher := sha1.New()
-		hasher.Write([]byte(cleanedConfig))
-		serviceName = serviceProtocol + "-" + strings.Trim(base64.URLEncoding.EncodeToString(hasher.Sum(nil)), "=")
+// ensureService returns the service for the drain, creating it if there is
+// none. Apps registered in one batch take turns so that only the first of
+// them creates it, and a service the batch created is only deleted on
+// rollback once no other app of the batch uses it.
+func ensureService(cliConn cliCommandRunner, fetcher registrationFetcher, undo *rollback, serviceProtocol, config string) (string, bool, error) {
+	batch, inBatch := fetcher.(batchSnapshot)
+	if inBatch {
+		defer batch.lockServices()()
 	}
-	return serviceName
-}
 
-func sanitizeConfig(config string) string {
-	slashToDashes := strings.Replace(config, "/", "-", -1)
-	removeColons := strings.Replace(slashToDashes, ":", "", -1)
-	return strings.Trim(removeColons, "-")
-}
+	names, err := loadServiceNames(cliConn, fetcher, serviceProtocol)
+	if err != nil {
+		return "", false, err
+	}
 
-func findExistingService(cliConn cliCommandRunner, serviceName string) (bool, error) {
-	existingServices, err := cliConn.GetServices()
+	k := registrationKey{Type: serviceProtocol, Config: config}
+	serviceName, exists, err := names.lookup(k)
 	if err != nil {
-		return false, err
+		return "", false, err
 	}
 
-	for _, s := range existingServices {
-		if s.Name == serviceName {
-			return true, nil
+	if exists {
+		if inBatch {
+			batch.use(serviceName, false)
+		}
+		return serviceName, false, nil
+	}
+
+	binding := serviceProtocol + "://" + config
+	_, err = cliConn.CliCommandWithoutTerminalOutput("create-user-provided-service", serviceName, "-l", binding)
+	if err != nil {
+		return "", false, err
+	}
+	undo.add("delete service "+serviceName, func() error {
+		if inBatch {
+			defer batch.lockServices()()
+			if !batch.release(serviceName) {
+				return errors.New("other apps of the batch use it")
+			}
+		}
+		_, err := cliConn.CliCommandWithoutTerminalOutput("delete-service", serviceName, "-f")
+		if err != nil {
+			return err
 		}
+		names.remove(k, serviceName)
+		return nil
+	})
+	names.add(k, serviceName)
+	if inBatch {
+		batch.use(serviceName, true)
+	}
+
+	return serviceName, true, nil
+}
+
+// releaseService gives up an app's use of a service it failed to bind. The
+// service is deleted if the batch created it and the app that did has
+// already rolled back.
+func releaseService(cliConn cliCommandRunner, fetcher registrationFetcher, batch batchSnapshot, serviceProtocol, config, serviceName string, cause error) error {
+	defer batch.lockServices()()
+	if !batch.release(serviceName) {
+		return cause
+	}
+
+	_, err := cliConn.CliCommandWithoutTerminalOutput("delete-service", serviceName, "-f")
+	if err != nil {
+		return fmt.Errorf("%w, deleting the unused service %s failed: %s", cause, serviceName, err)
+	}
+
+	names, err := loadServiceNames(cliConn, fetcher, serviceProtocol)
+	if err != nil {
+		return cause
 	}
-	return false, nil
+	names.remove(registrationKey{Type: serviceProtocol, Config: config}, serviceName)
+	return cause
 }
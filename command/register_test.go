@@ -2,11 +2,11 @@ package command_test
 
 import (
 	"errors"
-	"fmt"
 	"strings"
 
 	plugin_models "code.cloudfoundry.org/cli/plugin/models"
 	"github.com/pivotal-cf/metric-registrar-cli/command"
+	"github.com/pivotal-cf/metric-registrar-cli/registrations"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -18,29 +18,88 @@ var _ = Describe("Register", func() {
 		It("creates a service", func() {
 			cliConnection := newMockCliConnection()
 
-			err := command.RegisterLogFormat(cliConnection, "app-name", "format-name")
+			err := command.RegisterLogFormat(newSpyWriter(), newMockRegistrationFetcher(), cliConnection, "app-name", "format-name", command.RegisterOptions{})
 			Expect(err).ToNot(HaveOccurred())
 			Expect(cliConnection.cliCommandsCalled).To(receiveCreateUserProvidedService(
-				"structured-format-format-name",
+				"structured-format-format-name-c1d5083b9dd2607d",
 				"-l",
 				"structured-format://format-name",
 			))
 
 			Expect(cliConnection.cliCommandsCalled).To(receiveBindService(
 				"app-name",
-				"structured-format-format-name",
+				"structured-format-format-name-c1d5083b9dd2607d",
 			))
 		})
 
 		It("doesn't create a service if service already present", func() {
 			cliConnection := newMockCliConnection()
-			cliConnection.getServicesResult = []plugin_models.GetServices_Model{
-				{Name: "structured-format-config"},
+			fetcher := newMockRegistrationFetcher()
+			fetcher.instances = []registrations.Registration{
+				{Name: "structured-format-config", Type: "structured-format", Config: "config"},
 			}
 
-			err := command.RegisterLogFormat(cliConnection, "app-name", "config")
+			err := command.RegisterLogFormat(newSpyWriter(), fetcher, cliConnection, "app-name", "config", command.RegisterOptions{})
 			Expect(err).ToNot(HaveOccurred())
-			Expect(cliConnection.cliCommandsCalled).To(receiveBindService())
+			Expect(cliConnection.cliCommandsCalled).To(receiveBindService("app-name", "structured-format-config"))
+			Expect(cliConnection.cliCommandsCalled).ToNot(Receive())
+		})
+
+		It("reports an existing registration without changing anything", func() {
+			cliConnection := newMockCliConnection()
+			fetcher := newMockRegistrationFetcher()
+			fetcher.registrations["app-guid"] = []registrations.Registration{
+				{Name: "my-format", Type: "structured-format", Config: "json", NumberOfBindings: 1},
+			}
+			writer := newSpyWriter()
+
+			Expect(command.RegisterLogFormat(writer, fetcher, cliConnection, "app-name", "json", command.RegisterOptions{})).To(Succeed())
+			Expect(cliConnection.cliCommandsCalled).ToNot(Receive())
+			Expect(writer.lines()).To(Equal([]string{
+				"app-name is already registered for structured-format://json",
+				"",
+			}))
+		})
+
+		It("prints the result as JSON", func() {
+			cliConnection := newMockCliConnection()
+			writer := newSpyWriter()
+
+			Expect(command.RegisterLogFormat(writer, newMockRegistrationFetcher(), cliConnection, "app-name", "json", command.RegisterOptions{Output: "json"})).To(Succeed())
+			Expect(string(writer.bytes)).To(MatchJSON(`{
+				"app_name": "app-name",
+				"service_name": "structured-format-json-09cae105ae65ef6b",
+				"type": "structured-format",
+				"config": "json",
+				"status": "registered"
+			}`))
+		})
+
+		It("returns an error for an unknown output", func() {
+			cliConnection := newMockCliConnection()
+
+			err := command.RegisterLogFormat(newSpyWriter(), newMockRegistrationFetcher(), cliConnection, "app-name", "json", command.RegisterOptions{Output: "yaml"})
+			Expect(err).To(MatchError(`unknown output "yaml", must be json`))
+			Expect(cliConnection.cliCommandsCalled).ToNot(Receive())
+		})
+
+		It("returns error if getting existing registrations fails", func() {
+			cliConnection := newMockCliConnection()
+			fetcher := newMockRegistrationFetcher()
+			fetcher.fetchError = errors.New("expected")
+
+			Expect(command.RegisterLogFormat(newSpyWriter(), fetcher, cliConnection, "app-name", "json", command.RegisterOptions{})).To(MatchError("expected"))
+			Expect(cliConnection.cliCommandsCalled).ToNot(Receive())
+		})
+
+		It("returns an error if the service name belongs to another service", func() {
+			cliConnection := newMockCliConnection()
+			cliConnection.getServicesResult = []plugin_models.GetServices_Model{
+				{Name: "structured-format-config-529dd1075bf5fb6f"},
+			}
+
+			err := command.RegisterLogFormat(newSpyWriter(), newMockRegistrationFetcher(), cliConnection, "app-name", "config", command.RegisterOptions{})
+			Expect(err).To(MatchError("service structured-format-config-529dd1075bf5fb6f already exists but isn't registered for structured-format://config"))
 			Expect(cliConnection.cliCommandsCalled).ToNot(Receive())
 		})
 
@@ -48,7 +107,7 @@ var _ = Describe("Register", func() {
 			cliConnection := newMockCliConnection()
 			cliConnection.getServicesError = errors.New("error")
 
-			Expect(command.RegisterLogFormat(cliConnection, "app-name", "config")).ToNot(Succeed())
+			Expect(command.RegisterLogFormat(newSpyWriter(), newMockRegistrationFetcher(), cliConnection, "app-name", "config", command.RegisterOptions{})).ToNot(Succeed())
 			Expect(cliConnection.cliCommandsCalled).ToNot(Receive())
 		})
 
@@ -56,7 +115,7 @@ var _ = Describe("Register", func() {
 			cliConnection := newMockCliConnection()
 			cliConnection.cliErrorCommand = "create-user-provided-service"
 
-			Expect(command.RegisterLogFormat(cliConnection, "app-name", "config")).ToNot(Succeed())
+			Expect(command.RegisterLogFormat(newSpyWriter(), newMockRegistrationFetcher(), cliConnection, "app-name", "config", command.RegisterOptions{})).ToNot(Succeed())
 
 			Expect(cliConnection.cliCommandsCalled).To(receiveCreateUserProvidedService())
 			Expect(cliConnection.cliCommandsCalled).ToNot(Receive())
@@ -66,30 +125,47 @@ var _ = Describe("Register", func() {
 			cliConnection := newMockCliConnection()
 			cliConnection.cliErrorCommand = "bind-service"
 
-			Expect(command.RegisterLogFormat(cliConnection, "app-name", "config")).ToNot(Succeed())
+			Expect(command.RegisterLogFormat(newSpyWriter(), newMockRegistrationFetcher(), cliConnection, "app-name", "config", command.RegisterOptions{})).ToNot(Succeed())
 
 			Expect(cliConnection.cliCommandsCalled).To(receiveCreateUserProvidedService())
 			Expect(cliConnection.cliCommandsCalled).To(receiveBindService())
 		})
+
+		It("deletes the service it created if binding fails", func() {
+			cliConnection := newMockCliConnection()
+			cliConnection.cliErrorCommand = "bind-service"
+			writer := newSpyWriter()
+
+			Expect(command.RegisterLogFormat(writer, newMockRegistrationFetcher(), cliConnection, "app-name", "config", command.RegisterOptions{})).ToNot(Succeed())
+
+			Expect(cliConnection.cliCommandsCalled).To(receiveCreateUserProvidedService())
+			Expect(cliConnection.cliCommandsCalled).To(receiveBindService())
+			Expect(cliConnection.cliCommandsCalled).To(Receive(Equal([]string{"delete-service", "structured-format-config-529dd1075bf5fb6f", "-f"})))
+			Expect(writer.lines()).To(Equal([]string{
+				"Registering app-name failed, rolling back:",
+				"  delete service structured-format-config-529dd1075bf5fb6f",
+				"",
+			}))
+		})
 	})
 
 	Context("RegisterMetricsEndpoint", func() {
 		It("fails if neither --internal-port or --insecure is passed", func() {
 			cliConnection := newMockCliConnection()
 
-			err := command.RegisterMetricsEndpoint(cliConnection, "app-name", "/metrics", "", false)
+			err := command.RegisterMetricsEndpoint(newSpyWriter(), newMockRegistrationFetcher(), cliConnection, newMockPortManager(), "app-name", "/metrics", "", false, command.RegisterOptions{})
 			Expect(err).To(HaveOccurred())
 		})
 
-		It("does not use service names longer than 50 characters", func() {
+		It("does not use service names longer than the Cloud Controller allows", func() {
 			cliConnection := newMockCliConnection()
 
-			err := command.RegisterMetricsEndpoint(cliConnection, "very-long-app-name-with-many-characters", "/metrics", "8091", false)
+			err := command.RegisterMetricsEndpoint(newSpyWriter(), newMockRegistrationFetcher(), cliConnection, newMockPortManager(), "app-name", "/"+strings.Repeat("a", 300), "8091", false, command.RegisterOptions{})
 			Expect(err).ToNot(HaveOccurred())
 
 			Eventually(cliConnection.cliCommandsCalled).Should(Receive(ConsistOf(
 				"create-user-provided-service",
-				WithTransform(func(s string) int { return len(s) }, BeNumerically("<=", 50)),
+				WithTransform(func(s string) int { return len(s) }, BeNumerically("<=", 255)),
 				"-l",
 				HavePrefix("secure-endpoint://"),
 			)))
@@ -99,17 +175,14 @@ var _ = Describe("Register", func() {
 
 		It("doesn't create a service if service already present", func() {
 			cliConnection := newMockCliConnection()
-			cliConnection.getServicesResult = []plugin_models.GetServices_Model{
-				{Name: "secure-endpoint-8091-metrics"},
+			fetcher := newMockRegistrationFetcher()
+			fetcher.instances = []registrations.Registration{
+				{Name: "secure-endpoint-8091-metrics", Type: "secure-endpoint", Config: ":8091/metrics"},
 			}
 
-			err := command.RegisterMetricsEndpoint(cliConnection, "app-name", "/metrics", "8091", false)
+			err := command.RegisterMetricsEndpoint(newSpyWriter(), fetcher, cliConnection, newMockPortManager(), "app-name", "/metrics", "8091", false, command.RegisterOptions{})
 			Expect(err).ToNot(HaveOccurred())
 
-			// Ignore the getting and setting ports call
-			<-cliConnection.cliCommandsCalled
-			<-cliConnection.cliCommandsCalled
-
 			var received []string
 			Expect(cliConnection.cliCommandsCalled).To(Receive(&received))
 			Expect(received).ToNot(matchCreateUserProvidedService())
@@ -119,20 +192,37 @@ var _ = Describe("Register", func() {
 		It("replaces slashes in the service name", func() {
 			cliConnection := newMockCliConnection()
 
-			err := command.RegisterMetricsEndpoint(cliConnection, "app-name", "/v2/path/", "8091", false)
+			err := command.RegisterMetricsEndpoint(newSpyWriter(), newMockRegistrationFetcher(), cliConnection, newMockPortManager(), "app-name", "/v2/path/", "8091", false, command.RegisterOptions{})
 			Expect(err).ToNot(HaveOccurred())
 			Eventually(cliConnection.cliCommandsCalled).Should(receiveCreateUserProvidedService(
-				"secure-endpoint-8091-v2-path",
+				"secure-endpoint-8091-v2-path-ee880aef43c29a65",
 				"-l",
 				"secure-endpoint://:8091/v2/path/",
 			))
 		})
 
+		It("gives paths that only differ in their separators different service names", func() {
+			cliConnection := newMockCliConnection()
+
+			Expect(command.RegisterMetricsEndpoint(newSpyWriter(), newMockRegistrationFetcher(), cliConnection, newMockPortManager(), "app-name", "/a/b", "8091", false, command.RegisterOptions{})).To(Succeed())
+			Expect(command.RegisterMetricsEndpoint(newSpyWriter(), newMockRegistrationFetcher(), cliConnection, newMockPortManager(), "app-name", "/a-b", "8091", false, command.RegisterOptions{})).To(Succeed())
+
+			var first, second []string
+			Expect(cliConnection.cliCommandsCalled).To(Receive(&first))
+			Expect(cliConnection.cliCommandsCalled).To(receiveBindService())
+			Expect(cliConnection.cliCommandsCalled).To(Receive(&second))
+			Expect(first).To(matchCreateUserProvidedService())
+			Expect(second).To(matchCreateUserProvidedService())
+			Expect(first[1]).To(HavePrefix("secure-endpoint-8091-a-b-"))
+			Expect(second[1]).To(HavePrefix("secure-endpoint-8091-a-b-"))
+			Expect(first[1]).ToNot(Equal(second[1]))
+		})
+
 		It("returns error if getting the service fails", func() {
 			cliConnection := newMockCliConnection()
 			cliConnection.getServicesError = errors.New("error")
 
-			Expect(command.RegisterMetricsEndpoint(cliConnection, "app-name", "/metrics", "", true)).ToNot(Succeed())
+			Expect(command.RegisterMetricsEndpoint(newSpyWriter(), newMockRegistrationFetcher(), cliConnection, newMockPortManager(), "app-name", "/metrics", "", true, command.RegisterOptions{})).ToNot(Succeed())
 			Expect(cliConnection.cliCommandsCalled).ToNot(Receive())
 		})
 
@@ -140,7 +230,7 @@ var _ = Describe("Register", func() {
 			cliConnection := newMockCliConnection()
 			cliConnection.cliErrorCommand = "create-user-provided-service"
 
-			Expect(command.RegisterMetricsEndpoint(cliConnection, "app-name", "/metrics", "8091", false)).ToNot(Succeed())
+			Expect(command.RegisterMetricsEndpoint(newSpyWriter(), newMockRegistrationFetcher(), cliConnection, newMockPortManager(), "app-name", "/metrics", "8091", false, command.RegisterOptions{})).ToNot(Succeed())
 
 			Eventually(cliConnection.cliCommandsCalled).Should(receiveCreateUserProvidedService())
 			Expect(cliConnection.cliCommandsCalled).ToNot(Receive())
@@ -150,7 +240,7 @@ var _ = Describe("Register", func() {
 			cliConnection := newMockCliConnection()
 			cliConnection.cliErrorCommand = "bind-service"
 
-			Expect(command.RegisterMetricsEndpoint(cliConnection, "app-name", "/metrics", "8091", false)).ToNot(Succeed())
+			Expect(command.RegisterMetricsEndpoint(newSpyWriter(), newMockRegistrationFetcher(), cliConnection, newMockPortManager(), "app-name", "/metrics", "8091", false, command.RegisterOptions{})).ToNot(Succeed())
 
 			Eventually(cliConnection.cliCommandsCalled).Should(receiveCreateUserProvidedService())
 			Expect(cliConnection.cliCommandsCalled).To(receiveBindService())
@@ -160,14 +250,14 @@ var _ = Describe("Register", func() {
 			cliConnection := newMockCliConnection()
 			cliConnection.getAppError = errors.New("error")
 
-			Expect(command.RegisterMetricsEndpoint(cliConnection, "app-name", "app-host.app-domain/app-path/metrics", "8091", false)).ToNot(Succeed())
+			Expect(command.RegisterMetricsEndpoint(newSpyWriter(), newMockRegistrationFetcher(), cliConnection, newMockPortManager(), "app-name", "app-host.app-domain/app-path/metrics", "8091", false, command.RegisterOptions{})).ToNot(Succeed())
 			Expect(cliConnection.cliCommandsCalled).ToNot(Receive())
 		})
 
 		It("returns an error if parsing the route fails", func() {
 			cliConnection := newMockCliConnection()
 
-			err := command.RegisterMetricsEndpoint(cliConnection, "app-name", "#$%#$%#", "8091", false)
+			err := command.RegisterMetricsEndpoint(newSpyWriter(), newMockRegistrationFetcher(), cliConnection, newMockPortManager(), "app-name", "#$%#$%#", "8091", false, command.RegisterOptions{})
 			Expect(err).To(HaveOccurred())
 			Expect(err.Error()).To(HavePrefix("unable to parse requested route:"))
 			Expect(cliConnection.cliCommandsCalled).ToNot(Receive())
@@ -177,48 +267,225 @@ var _ = Describe("Register", func() {
 			It("errors when domain is passed", func() {
 				cliConnection := newMockCliConnection()
 
-				err := command.RegisterMetricsEndpoint(cliConnection, "app-name", "app-host.app-domain/app-path/metrics", "8091", false)
+				err := command.RegisterMetricsEndpoint(newSpyWriter(), newMockRegistrationFetcher(), cliConnection, newMockPortManager(), "app-name", "app-host.app-domain/app-path/metrics", "8091", false, command.RegisterOptions{})
 				Expect(err).To(MatchError("cannot provide hostname with --internal-port. provided: 'app-host.app-domain'"))
 			})
 
 			It("creates a service given a path", func() {
 				cliConnection := newMockCliConnection()
 
-				err := command.RegisterMetricsEndpoint(cliConnection, "app-name", "/metrics", "1234", false)
+				err := command.RegisterMetricsEndpoint(newSpyWriter(), newMockRegistrationFetcher(), cliConnection, newMockPortManager(), "app-name", "/metrics", "1234", false, command.RegisterOptions{})
 				Expect(err).ToNot(HaveOccurred())
 
 				Eventually(cliConnection.cliCommandsCalled).Should(receiveCreateUserProvidedService(
-					"secure-endpoint-1234-metrics",
+					"secure-endpoint-1234-metrics-0fa8d6b4e2d551ec",
 					"-l",
 					"secure-endpoint://:1234/metrics",
 				))
 
 				Expect(cliConnection.cliCommandsCalled).To(receiveBindService(
 					"app-name",
-					"secure-endpoint-1234-metrics",
+					"secure-endpoint-1234-metrics-0fa8d6b4e2d551ec",
 				))
 			})
 
 			It("exposes the internal port automatically and preserves existing ports", func() {
 				cliConnection := newMockCliConnection()
-				cliConnection.exposedPorts = []int{1234}
+				portManager := newMockPortManager()
+				portManager.exposedPorts = []int{1234}
 
-				Expect(command.RegisterMetricsEndpoint(cliConnection, "app-name", "/v2/metrics", "2112", false)).To(Succeed())
-				expectToReceiveCurlForAppAndPort(cliConnection.cliCommandsCalled, "app-guid", []string{"1234", "2112"})
+				Expect(command.RegisterMetricsEndpoint(newSpyWriter(), newMockRegistrationFetcher(), cliConnection, portManager, "app-name", "/v2/metrics", "2112", false, command.RegisterOptions{})).To(Succeed())
+				Expect(portManager.setPortsCalled).To(Receive(Equal([]int{1234, 2112})))
+			})
+
+			It("merges its port into ports another invocation set at the same time", func() {
+				cliConnection := newMockCliConnection()
+				portManager := &racingPortManager{
+					mockPortManager: newMockPortManager(),
+					overwrites:      [][]int{{8080, 9090}},
+				}
+
+				Expect(command.RegisterMetricsEndpoint(newSpyWriter(), newMockRegistrationFetcher(), cliConnection, portManager, "app-name", "/v2/metrics", "2112", false, command.RegisterOptions{})).To(Succeed())
+				Expect(portManager.setPortsCalled).To(Receive(Equal([]int{8080, 2112})))
+				Expect(portManager.setPortsCalled).To(Receive(Equal([]int{8080, 9090, 2112})))
+				Expect(portManager.setPortsCalled).ToNot(Receive())
+			})
+
+			It("returns an error if its port keeps getting overwritten", func() {
+				cliConnection := newMockCliConnection()
+				portManager := &racingPortManager{
+					mockPortManager: newMockPortManager(),
+					overwrites:      [][]int{{8080}, {8080}, {8080}},
+				}
+
+				err := command.RegisterMetricsEndpoint(newSpyWriter(), newMockRegistrationFetcher(), cliConnection, portManager, "app-name", "/v2/metrics", "2112", false, command.RegisterOptions{})
+				Expect(err).To(MatchError("ports of app app-guid were changed by someone else while updating them: expected [8080, 2112], found [8080]. Try again"))
+				Expect(cliConnection.cliCommandsCalled).ToNot(Receive())
+			})
+
+			It("records that it opened the port", func() {
+				cliConnection := newMockCliConnection()
+				portManager := newMockPortManager()
+				portManager.openedPorts = []int{9090}
+
+				Expect(command.RegisterMetricsEndpoint(newSpyWriter(), newMockRegistrationFetcher(), cliConnection, portManager, "app-name", "/v2/metrics", "2112", false, command.RegisterOptions{})).To(Succeed())
+				Expect(portManager.setOpenedPortsCalled).To(Receive(Equal([]int{9090, 2112})))
+			})
+
+			It("removes the port again if recording it fails", func() {
+				cliConnection := newMockCliConnection()
+				portManager := newMockPortManager()
+				portManager.setOpenedPortsError = errors.New("expected")
+
+				err := command.RegisterMetricsEndpoint(newSpyWriter(), newMockRegistrationFetcher(), cliConnection, portManager, "app-name", "/v2/metrics", "2112", false, command.RegisterOptions{})
+				Expect(err).To(MatchError("expected"))
+				Expect(portManager.setPortsCalled).To(Receive(Equal([]int{8080, 2112})))
+				Expect(portManager.setPortsCalled).To(Receive(Equal([]int{8080})))
+				Expect(cliConnection.cliCommandsCalled).ToNot(Receive())
+			})
+
+			It("exposes the port without recording it where the Cloud Controller can't", func() {
+				cliConnection := newMockCliConnection()
+				portManager := newMockPortManager()
+				portManager.getOpenedPortsError = command.ErrPortOwnershipUnavailable
+				portManager.setOpenedPortsError = command.ErrPortOwnershipUnavailable
+
+				Expect(command.RegisterMetricsEndpoint(newSpyWriter(), newMockRegistrationFetcher(), cliConnection, portManager, "app-name", "/v2/metrics", "2112", false, command.RegisterOptions{})).To(Succeed())
+				Expect(portManager.setPortsCalled).To(Receive(Equal([]int{8080, 2112})))
+				Expect(portManager.setPortsCalled).ToNot(Receive())
+				Expect(cliConnection.cliCommandsCalled).To(Receive(ContainElement("create-user-provided-service")))
+				Expect(cliConnection.cliCommandsCalled).To(Receive(ContainElement("bind-service")))
+			})
+
+			It("doesn't set ports if the internal port is already exposed", func() {
+				cliConnection := newMockCliConnection()
+				portManager := newMockPortManager()
+				portManager.exposedPorts = []int{2112}
+
+				Expect(command.RegisterMetricsEndpoint(newSpyWriter(), newMockRegistrationFetcher(), cliConnection, portManager, "app-name", "/v2/metrics", "2112", false, command.RegisterOptions{})).To(Succeed())
+				Expect(portManager.setPortsCalled).ToNot(Receive())
+				Expect(portManager.setOpenedPortsCalled).ToNot(Receive())
 			})
 
 			It("returns error if getting existing ports fails", func() {
 				cliConnection := newMockCliConnection()
-				cliConnection.getAppsInfoError = errors.New("failed to fetch apps info")
+				portManager := newMockPortManager()
+				portManager.getPortsError = errors.New("failed to fetch ports")
 
-				Expect(command.RegisterMetricsEndpoint(cliConnection, "app-name", "/v2/metrics", "2112", false)).ToNot(Succeed())
+				Expect(command.RegisterMetricsEndpoint(newSpyWriter(), newMockRegistrationFetcher(), cliConnection, portManager, "app-name", "/v2/metrics", "2112", false, command.RegisterOptions{})).ToNot(Succeed())
 			})
 
 			It("returns error if setting port fails", func() {
 				cliConnection := newMockCliConnection()
-				cliConnection.putAppsInfoError = errors.New("failed to put apps info")
+				portManager := newMockPortManager()
+				portManager.setPortsError = errors.New("failed to set ports")
+
+				Expect(command.RegisterMetricsEndpoint(newSpyWriter(), newMockRegistrationFetcher(), cliConnection, portManager, "app-name", "/v2/metrics", "2112", false, command.RegisterOptions{})).ToNot(Succeed())
+			})
+
+			It("rolls back the service and port if binding fails", func() {
+				cliConnection := newMockCliConnection()
+				cliConnection.cliErrorCommand = "bind-service"
+				portManager := newMockPortManager()
+				portManager.exposedPorts = []int{8080}
+				writer := newSpyWriter()
+
+				err := command.RegisterMetricsEndpoint(writer, newMockRegistrationFetcher(), cliConnection, portManager, "app-name", "/metrics", "2112", false, command.RegisterOptions{})
+				Expect(err).To(MatchError("error"))
+
+				Expect(portManager.setPortsCalled).To(Receive(Equal([]int{8080, 2112})))
+				Expect(cliConnection.cliCommandsCalled).To(receiveCreateUserProvidedService())
+				Expect(cliConnection.cliCommandsCalled).To(receiveBindService())
+				Expect(cliConnection.cliCommandsCalled).To(Receive(Equal([]string{"delete-service", "secure-endpoint-2112-metrics-fdae1312e3715d76", "-f"})))
+				Expect(portManager.setPortsCalled).To(Receive(Equal([]int{8080})))
+				Expect(writer.lines()).To(Equal([]string{
+					"Registering app-name failed, rolling back:",
+					"  delete service secure-endpoint-2112-metrics-fdae1312e3715d76",
+					"  remove port 2112 from app-name",
+					"",
+				}))
+			})
+
+			It("only removes the port if creating the service fails", func() {
+				cliConnection := newMockCliConnection()
+				cliConnection.cliErrorCommand = "create-user-provided-service"
+				portManager := newMockPortManager()
+				writer := newSpyWriter()
+
+				Expect(command.RegisterMetricsEndpoint(writer, newMockRegistrationFetcher(), cliConnection, portManager, "app-name", "/metrics", "2112", false, command.RegisterOptions{})).ToNot(Succeed())
 
-				Expect(command.RegisterMetricsEndpoint(cliConnection, "app-name", "/v2/metrics", "2112", false)).ToNot(Succeed())
+				Expect(cliConnection.cliCommandsCalled).To(receiveCreateUserProvidedService())
+				Expect(cliConnection.cliCommandsCalled).ToNot(Receive())
+				Expect(portManager.setPortsCalled).To(Receive(Equal([]int{8080, 2112})))
+				Expect(portManager.setPortsCalled).To(Receive(Equal([]int{8080})))
+				Expect(writer.lines()).To(ContainElement("  remove port 2112 from app-name"))
+			})
+
+			It("leaves ports and services it didn't create alone", func() {
+				cliConnection := newMockCliConnection()
+				cliConnection.cliErrorCommand = "bind-service"
+				fetcher := newMockRegistrationFetcher()
+				fetcher.instances = []registrations.Registration{
+					{Name: "secure-endpoint-2112-metrics", Type: "secure-endpoint", Config: ":2112/metrics"},
+				}
+				portManager := newMockPortManager()
+				portManager.exposedPorts = []int{2112}
+				writer := newSpyWriter()
+
+				Expect(command.RegisterMetricsEndpoint(writer, fetcher, cliConnection, portManager, "app-name", "/metrics", "2112", false, command.RegisterOptions{})).ToNot(Succeed())
+
+				Expect(cliConnection.cliCommandsCalled).To(receiveBindService())
+				Expect(cliConnection.cliCommandsCalled).ToNot(Receive())
+				Expect(portManager.setPortsCalled).ToNot(Receive())
+				Expect(writer.bytes).To(BeEmpty())
+			})
+
+			It("reports an existing registration without changing anything", func() {
+				cliConnection := newMockCliConnection()
+				fetcher := newMockRegistrationFetcher()
+				fetcher.registrations["app-guid"] = []registrations.Registration{
+					{Name: "my-endpoint", Type: "secure-endpoint", Config: ":2112/metrics", NumberOfBindings: 1},
+				}
+				portManager := newMockPortManager()
+				portManager.exposedPorts = []int{8080, 2112}
+				writer := newSpyWriter()
+
+				Expect(command.RegisterMetricsEndpoint(writer, fetcher, cliConnection, portManager, "app-name", "/metrics", "2112", false, command.RegisterOptions{Output: "json"})).To(Succeed())
+				Expect(cliConnection.cliCommandsCalled).ToNot(Receive())
+				Expect(portManager.setPortsCalled).ToNot(Receive())
+				Expect(string(writer.bytes)).To(MatchJSON(`{
+					"app_name": "app-name",
+					"service_name": "my-endpoint",
+					"type": "secure-endpoint",
+					"config": ":2112/metrics",
+					"status": "already registered"
+				}`))
+			})
+
+			It("only exposes the port if the endpoint is bound but the port isn't exposed", func() {
+				cliConnection := newMockCliConnection()
+				fetcher := newMockRegistrationFetcher()
+				fetcher.registrations["app-guid"] = []registrations.Registration{
+					{Name: "my-endpoint", Type: "secure-endpoint", Config: ":2112/metrics", NumberOfBindings: 1},
+				}
+				portManager := newMockPortManager()
+				writer := newSpyWriter()
+
+				Expect(command.RegisterMetricsEndpoint(writer, fetcher, cliConnection, portManager, "app-name", "/metrics", "2112", false, command.RegisterOptions{})).To(Succeed())
+				Expect(portManager.setPortsCalled).To(Receive(Equal([]int{8080, 2112})))
+				Expect(cliConnection.cliCommandsCalled).ToNot(Receive())
+				Expect(writer.bytes).To(BeEmpty())
+			})
+
+			It("reports steps that couldn't be rolled back", func() {
+				cliConnection := newMockCliConnection()
+				cliConnection.cliErrorCommand = "bind-service"
+				portManager := &failingSetPortsManager{mockPortManager: newMockPortManager()}
+				writer := newSpyWriter()
+
+				err := command.RegisterMetricsEndpoint(writer, newMockRegistrationFetcher(), cliConnection, portManager, "app-name", "/metrics", "2112", false, command.RegisterOptions{})
+				Expect(err).To(MatchError("error"))
+				Expect(writer.lines()).To(ContainElement("  failed to remove port 2112 from app-name: failed to set ports"))
 			})
 		})
 
@@ -226,49 +493,65 @@ var _ = Describe("Register", func() {
 			It("creates a metrics-endpoint", func() {
 				cliConnection := newMockCliConnection()
 
-				err := command.RegisterMetricsEndpoint(cliConnection, "app-name", "/metrics", "", true)
+				err := command.RegisterMetricsEndpoint(newSpyWriter(), newMockRegistrationFetcher(), cliConnection, newMockPortManager(), "app-name", "/metrics", "", true, command.RegisterOptions{})
 				Expect(err).ToNot(HaveOccurred())
 
 				Eventually(cliConnection.cliCommandsCalled).Should(receiveCreateUserProvidedService(
-					"metrics-endpoint-metrics",
+					"metrics-endpoint-metrics-7424fc786889f654",
 					"-l",
 					"metrics-endpoint:///metrics",
 				))
 
 				Expect(cliConnection.cliCommandsCalled).To(receiveBindService(
 					"app-name",
-					"metrics-endpoint-metrics",
+					"metrics-endpoint-metrics-7424fc786889f654",
 				))
 			})
 
 			It("creates a service given a path", func() {
 				cliConnection := newMockCliConnection()
 
-				err := command.RegisterMetricsEndpoint(cliConnection, "app-name", "/metrics", "", true)
+				err := command.RegisterMetricsEndpoint(newSpyWriter(), newMockRegistrationFetcher(), cliConnection, newMockPortManager(), "app-name", "/metrics", "", true, command.RegisterOptions{})
 				Expect(err).ToNot(HaveOccurred())
 				Eventually(cliConnection.cliCommandsCalled).Should(receiveCreateUserProvidedService(
-					"metrics-endpoint-metrics",
+					"metrics-endpoint-metrics-7424fc786889f654",
 					"-l",
 					"metrics-endpoint:///metrics",
 				))
 
 				Expect(cliConnection.cliCommandsCalled).To(receiveBindService(
 					"app-name",
-					"metrics-endpoint-metrics",
+					"metrics-endpoint-metrics-7424fc786889f654",
 				))
 			})
 
+			It("reports an existing registration without changing anything", func() {
+				cliConnection := newMockCliConnection()
+				fetcher := newMockRegistrationFetcher()
+				fetcher.registrations["app-guid"] = []registrations.Registration{
+					{Name: "my-endpoint", Type: "metrics-endpoint", Config: "/metrics", NumberOfBindings: 1},
+				}
+				writer := newSpyWriter()
+
+				Expect(command.RegisterMetricsEndpoint(writer, fetcher, cliConnection, newMockPortManager(), "app-name", "/metrics", "", true, command.RegisterOptions{})).To(Succeed())
+				Expect(cliConnection.cliCommandsCalled).ToNot(Receive())
+				Expect(writer.lines()).To(Equal([]string{
+					"app-name is already registered for metrics-endpoint:///metrics",
+					"",
+				}))
+			})
+
 			It("checks the route when domain is passed", func() {
 				cliConnection := newMockCliConnection()
-				err := command.RegisterMetricsEndpoint(cliConnection, "app-name", "not-app-host.app-domain/app-path/metrics", "", true)
+				err := command.RegisterMetricsEndpoint(newSpyWriter(), newMockRegistrationFetcher(), cliConnection, newMockPortManager(), "app-name", "not-app-host.app-domain/app-path/metrics", "", true, command.RegisterOptions{})
 				Expect(err).To(MatchError("route 'not-app-host.app-domain/app-path/metrics' is not bound to app 'app-name'"))
 			})
 
 			It("checks the route when domain is passed correctly", func() {
 				cliConnection := newMockCliConnection()
-				Expect(command.RegisterMetricsEndpoint(cliConnection, "app-name", "app-host.app-domain/app-path", "", true)).To(Succeed())
+				Expect(command.RegisterMetricsEndpoint(newSpyWriter(), newMockRegistrationFetcher(), cliConnection, newMockPortManager(), "app-name", "app-host.app-domain/app-path", "", true, command.RegisterOptions{})).To(Succeed())
 				Expect(cliConnection.cliCommandsCalled).To(receiveCreateUserProvidedService(
-					"metrics-endpoint-app-host.app-domain-app-path",
+					"metrics-endpoint-app-host.app-domain-app-path-6aaf2806bf829bdd",
 					"-l",
 					"metrics-endpoint://app-host.app-domain/app-path",
 				))
@@ -284,9 +567,9 @@ var _ = Describe("Register", func() {
 					},
 					Path: "/app-path",
 				}}
-				Expect(command.RegisterMetricsEndpoint(cliConnection, "app-name", "app-host.app-domain/app-path", "", true)).To(Succeed())
+				Expect(command.RegisterMetricsEndpoint(newSpyWriter(), newMockRegistrationFetcher(), cliConnection, newMockPortManager(), "app-name", "app-host.app-domain/app-path", "", true, command.RegisterOptions{})).To(Succeed())
 				Expect(cliConnection.cliCommandsCalled).To(receiveCreateUserProvidedService(
-					"metrics-endpoint-app-host.app-domain-app-path",
+					"metrics-endpoint-app-host.app-domain-app-path-6aaf2806bf829bdd",
 					"-l",
 					"metrics-endpoint://app-host.app-domain/app-path",
 				))
@@ -302,9 +585,9 @@ var _ = Describe("Register", func() {
 					},
 				}}
 
-				Expect(command.RegisterMetricsEndpoint(cliConnection, "app-name", "tcp.app-domain/v2/path/", "", true)).To(Succeed())
+				Expect(command.RegisterMetricsEndpoint(newSpyWriter(), newMockRegistrationFetcher(), cliConnection, newMockPortManager(), "app-name", "tcp.app-domain/v2/path/", "", true, command.RegisterOptions{})).To(Succeed())
 				Expect(cliConnection.cliCommandsCalled).To(receiveCreateUserProvidedService(
-					"metrics-endpoint-tcp.app-domain-v2-path",
+					"metrics-endpoint-tcp.app-domain-v2-path-5daa7421c2c21b83",
 					"-l",
 					"metrics-endpoint://tcp.app-domain/v2/path/",
 				))
@@ -326,31 +609,44 @@ func receiveCreateUserProvidedService(args ...string) types.GomegaMatcher {
 	return Receive(matchCreateUserProvidedService(args...))
 }
 
-func matchCurl(args ...string) types.GomegaMatcher {
+func matchBindService(args ...string) types.GomegaMatcher {
 	if len(args) == 0 {
-		return ContainElement("curl")
+		return ContainElement("bind-service")
 	}
+	return Equal(append([]string{"bind-service"}, args...))
+}
 
-	return ContainElements(append([]string{"curl"}, args...))
+func receiveBindService(args ...string) types.GomegaMatcher {
+	return Receive(matchBindService(args...))
 }
 
-func expectToReceiveCurlForAppAndPort(called chan []string, appGuid string, ports []string) {
-	Eventually(called).Should(Receive(matchCurl(
-		fmt.Sprintf("/v2/apps/%s", appGuid),
-		"-X",
-		"PUT",
-		"-d",
-		fmt.Sprintf("'{\"ports\":[%s]}'", strings.Join(ports, ",")),
-	)))
+// failingSetPortsManager succeeds at the first set and fails after that.
+type failingSetPortsManager struct {
+	*mockPortManager
+	sets int
 }
 
-func matchBindService(args ...string) types.GomegaMatcher {
-	if len(args) == 0 {
-		return ContainElement("bind-service")
+func (m *failingSetPortsManager) SetPortsForApp(appGuid string, ports []int) error {
+	m.sets++
+	if m.sets > 1 {
+		return errors.New("failed to set ports")
 	}
-	return Equal(append([]string{"bind-service"}, args...))
+	return m.mockPortManager.SetPortsForApp(appGuid, ports)
 }
 
-func receiveBindService(args ...string) types.GomegaMatcher {
-	return Receive(matchBindService(args...))
+// racingPortManager replaces the ports after each set, as another invocation
+// updating the same app would.
+type racingPortManager struct {
+	*mockPortManager
+	overwrites [][]int
+}
+
+func (m *racingPortManager) SetPortsForApp(appGuid string, ports []int) error {
+	err := m.mockPortManager.SetPortsForApp(appGuid, ports)
+	if err != nil || len(m.overwrites) == 0 {
+		return err
+	}
+	m.exposedPorts = m.overwrites[0]
+	m.overwrites = m.overwrites[1:]
+	return nil
 }
@@ -0,0 +1,436 @@
+package command
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	plugin_models "code.cloudfoundry.org/cli/plugin/models"
+	"github.com/pivotal-cf/metric-registrar-cli/registrations"
+	"gopkg.in/yaml.v3"
+)
+
+// registrationsFile declares the registrations each listed app should have.
+// Apps that aren't listed are left alone.
+type registrationsFile struct {
+	Apps []appRegistrations `yaml:"apps"`
+}
+
+type appRegistrations struct {
+	Name             string                 `yaml:"name"`
+	LogFormats       []string               `yaml:"log_formats,omitempty"`
+	MetricsEndpoints []endpointRegistration `yaml:"metrics_endpoints,omitempty"`
+}
+
+type endpointRegistration struct {
+	Path         string `yaml:"path"`
+	InternalPort int    `yaml:"internal_port,omitempty"`
+	Insecure     bool   `yaml:"insecure,omitempty"`
+}
+
+// ExitCode ends the plugin with the given status without printing an error.
+type ExitCode int
+
+func (c ExitCode) Error() string {
+	return fmt.Sprintf("exit status %d", int(c))
+}
+
+// ExitCodeChanges is returned by plan with --detailed-exitcode when the
+// space doesn't match the file.
+const ExitCodeChanges = ExitCode(2)
+
+type registrationKey struct {
+	Type   string
+	Config string
+}
+
+type action struct {
+	description string
+	run         func() error
+}
+
+func PlanRegistrations(writer io.Writer, fetcher registrationFetcher, portManager portManager, cliConn cliCommandRunner, path string, detailedExitCode bool) error {
+	actions, err := planFromFile(fetcher, portManager, cliConn, path)
+	if err != nil {
+		return err
+	}
+
+	if len(actions) == 0 {
+		_, err = fmt.Fprintf(writer, "No changes. Registrations match %s.\n", path)
+		return err
+	}
+
+	for _, a := range actions {
+		_, err = fmt.Fprintf(writer, "%s\n", a.description)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(writer, "\nPlan: %d changes.\n", len(actions))
+	if err != nil {
+		return err
+	}
+
+	if detailedExitCode {
+		return ExitCodeChanges
+	}
+	return nil
+}
+
+func ApplyRegistrations(writer io.Writer, fetcher registrationFetcher, portManager portManager, cliConn cliCommandRunner, path string) error {
+	actions, err := planFromFile(fetcher, portManager, cliConn, path)
+	if err != nil {
+		return err
+	}
+
+	if len(actions) == 0 {
+		_, err = fmt.Fprintf(writer, "No changes. Registrations match %s.\n", path)
+		return err
+	}
+
+	for _, a := range actions {
+		_, err = fmt.Fprintf(writer, "%s\n", a.description)
+		if err != nil {
+			return err
+		}
+
+		err = a.run()
+		if err != nil {
+			return err
+		}
+	}
+
+	summary := "\nApplied %d changes.\n"
+	if isDryRun(cliConn) {
+		summary = "\nWould apply %d changes.\n"
+	}
+	_, err = fmt.Fprintf(writer, summary, len(actions))
+	return err
+}
+
+func ExportRegistrations(writer io.Writer, fetcher registrationFetcher, lister appLister) error {
+	regs, err := fetcher.FetchAll(structuredFormat, metricsEndpoint, secureEndpoint)
+	if err != nil {
+		return err
+	}
+
+	apps, err := lister.GetApps()
+	if err != nil {
+		return err
+	}
+
+	file := registrationsFile{Apps: []appRegistrations{}}
+	for _, app := range apps {
+		if len(regs[app.Guid]) == 0 {
+			continue
+		}
+
+		a := appRegistrations{Name: app.Name}
+		for _, r := range regs[app.Guid] {
+			switch r.Type {
+			case structuredFormat:
+				a.LogFormats = append(a.LogFormats, r.Config)
+			case metricsEndpoint:
+				a.MetricsEndpoints = append(a.MetricsEndpoints, endpointRegistration{Path: r.Config, Insecure: true})
+			case secureEndpoint:
+				port, path := parseEndpointConfig(r.Config)
+				a.MetricsEndpoints = append(a.MetricsEndpoints, endpointRegistration{Path: path, InternalPort: port})
+			}
+		}
+		file.Apps = append(file.Apps, a)
+	}
+
+	sort.SliceStable(file.Apps, func(i, j int) bool {
+		return file.Apps[i].Name < file.Apps[j].Name
+	})
+
+	encoder := yaml.NewEncoder(writer)
+	encoder.SetIndent(2)
+	return encoder.Encode(file)
+}
+
+func readRegistrationsFile(path string) (registrationsFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return registrationsFile{}, err
+	}
+
+	var file registrationsFile
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	err = decoder.Decode(&file)
+	if err != nil && err != io.EOF {
+		return registrationsFile{}, fmt.Errorf("unable to parse %s: %s", path, err)
+	}
+
+	seen := map[string]bool{}
+	for _, app := range file.Apps {
+		if app.Name == "" {
+			return registrationsFile{}, fmt.Errorf("%s: every app needs a name", path)
+		}
+		if seen[app.Name] {
+			return registrationsFile{}, fmt.Errorf("%s: app %s is listed more than once", path, app.Name)
+		}
+		seen[app.Name] = true
+
+		for _, f := range app.LogFormats {
+			if f == "" {
+				return registrationsFile{}, fmt.Errorf("%s: app %s has an empty log format", path, app.Name)
+			}
+		}
+		for _, e := range app.MetricsEndpoints {
+			if e.Path == "" {
+				return registrationsFile{}, fmt.Errorf("%s: app %s has a metrics endpoint without a path", path, app.Name)
+			}
+			if (e.InternalPort == 0) == !e.Insecure {
+				return registrationsFile{}, fmt.Errorf("%s: metrics endpoint %s of app %s needs either internal_port or insecure", path, e.Path, app.Name)
+			}
+		}
+	}
+
+	return file, nil
+}
+
+func planFromFile(fetcher registrationFetcher, portManager portManager, cliConn cliCommandRunner, path string) ([]action, error) {
+	file, err := readRegistrationsFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := fetcher.FetchAll(structuredFormat, metricsEndpoint, secureEndpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	names, err := loadServiceNames(cliConn, fetcher, structuredFormat, metricsEndpoint, secureEndpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	var actions []action
+	unbinds := map[string]int{}
+	binds := map[string]int{}
+	bindings := map[string]int{}
+	var unbound []string
+
+	for _, desired := range file.Apps {
+		app, err := cliConn.GetApp(desired.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		wanted, err := desiredRegistrations(desired, app)
+		if err != nil {
+			return nil, err
+		}
+
+		current := map[registrationKey]registrations.Registration{}
+		for _, r := range existing[app.Guid] {
+			current[registrationKey{Type: r.Type, Config: r.Config}] = r
+		}
+
+		wantedKeys := map[registrationKey]bool{}
+		wantedPorts := map[int]bool{}
+		var toAdd []registrationKey
+		for _, k := range wanted {
+			wantedKeys[k] = true
+			if k.Type == secureEndpoint {
+				wantedPorts[getPortFromConfig(k.Config)] = true
+			}
+			if _, ok := current[k]; !ok {
+				toAdd = append(toAdd, k)
+			}
+		}
+
+		var toRemove []registrations.Registration
+		var stalePorts []int
+		for _, r := range existing[app.Guid] {
+			if wantedKeys[registrationKey{Type: r.Type, Config: r.Config}] {
+				continue
+			}
+			toRemove = append(toRemove, r)
+			if p := getPortFromConfig(r.Config); r.Type == secureEndpoint && p != -1 && !wantedPorts[p] {
+				stalePorts = append(stalePorts, p)
+			}
+		}
+
+		var currentPorts, openedPorts []int
+		if len(wantedPorts) > 0 || len(stalePorts) > 0 {
+			currentPorts, err = portManager.GetPortsForApp(app.Guid)
+			if err != nil {
+				return nil, err
+			}
+			openedPorts, err = openedPortsForApp(portManager, app.Guid, stalePorts)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		exposed := append([]int{}, currentPorts...)
+		var opened []int
+		for _, k := range wanted {
+			p := getPortFromConfig(k.Config)
+			if k.Type == secureEndpoint && !containsPort(exposed, p) {
+				exposed = append(exposed, p)
+				opened = append(opened, p)
+			}
+		}
+		if len(opened) > 0 {
+			actions = append(actions, setPortsAction(portManager, app.Name, app.Guid, currentPorts, exposed, portChange{add: opened}))
+			openedPorts = append(append([]int{}, openedPorts...), opened...)
+		}
+
+		for _, k := range toAdd {
+			serviceName, added, err := addRegistrationActions(cliConn, names, app.Name, k)
+			if err != nil {
+				return nil, err
+			}
+			actions = append(actions, added...)
+			binds[serviceName]++
+		}
+
+		for _, r := range toRemove {
+			r := r
+			appName := app.Name
+			actions = append(actions, action{
+				description: fmt.Sprintf("unbind %s from %s", r.Name, appName),
+				run: func() error {
+					_, err := cliConn.CliCommandWithoutTerminalOutput("unbind-service", appName, r.Name)
+					return err
+				},
+			})
+
+			if _, ok := bindings[r.Name]; !ok {
+				unbound = append(unbound, r.Name)
+			}
+			unbinds[r.Name]++
+			bindings[r.Name] = r.NumberOfBindings
+		}
+
+		// only close ports the plugin opened, the app may need the others
+		var closed []int
+		for _, p := range stalePorts {
+			if containsPort(openedPorts, p) && containsPort(exposed, p) {
+				closed = append(closed, p)
+			}
+		}
+		if len(closed) > 0 {
+			actions = append(actions, setPortsAction(portManager, app.Name, app.Guid, exposed, withoutPorts(exposed, closed), portChange{remove: closed}))
+		}
+	}
+
+	// services are only deleted once nothing else is bound to them,
+	// including apps the plan binds to them
+	for _, name := range unbound {
+		if bindings[name]-unbinds[name]+binds[name] > 0 {
+			continue
+		}
+
+		name := name
+		actions = append(actions, action{
+			description: fmt.Sprintf("delete service %s", name),
+			run: func() error {
+				_, err := cliConn.CliCommandWithoutTerminalOutput("delete-service", name, "-f")
+				return err
+			},
+		})
+	}
+
+	return actions, nil
+}
+
+func desiredRegistrations(desired appRegistrations, app plugin_models.GetAppModel) ([]registrationKey, error) {
+	var keys []registrationKey
+	seen := map[registrationKey]bool{}
+	add := func(k registrationKey) {
+		if !seen[k] {
+			seen[k] = true
+			keys = append(keys, k)
+		}
+	}
+
+	for _, f := range desired.LogFormats {
+		add(registrationKey{Type: structuredFormat, Config: f})
+	}
+
+	for _, e := range desired.MetricsEndpoints {
+		validRoute, err := validateRouteForApp(e.Path, app, !e.Insecure)
+		if err != nil {
+			return nil, err
+		}
+
+		if e.Insecure {
+			add(registrationKey{Type: metricsEndpoint, Config: e.Path})
+			continue
+		}
+		add(registrationKey{Type: secureEndpoint, Config: ":" + strconv.Itoa(e.InternalPort) + validRoute.Path})
+	}
+
+	return keys, nil
+}
+
+func addRegistrationActions(cliConn cliCommandRunner, names serviceNames, appName string, k registrationKey) (string, []action, error) {
+	var actions []action
+	serviceName, exists, err := names.lookup(k)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if !exists {
+		// later apps bind to the service created here
+		names.add(k, serviceName)
+		binding := k.Type + "://" + k.Config
+		actions = append(actions, action{
+			description: fmt.Sprintf("create service %s for %s", serviceName, binding),
+			run: func() error {
+				_, err := cliConn.CliCommandWithoutTerminalOutput("create-user-provided-service", serviceName, "-l", binding)
+				return err
+			},
+		})
+	}
+
+	return serviceName, append(actions, action{
+		description: fmt.Sprintf("bind %s to %s", serviceName, appName),
+		run: func() error {
+			_, err := cliConn.CliCommandWithoutTerminalOutput("bind-service", appName, serviceName)
+			return err
+		},
+	}), nil
+}
+
+// setPortsAction changes the app's ports and records which of them the plugin
+// opened. The change is applied to the ports the app has when the action
+// runs, from and to only describe it.
+func setPortsAction(portManager portManager, appName, appGuid string, from, to []int, change portChange) action {
+	return action{
+		description: fmt.Sprintf("set ports of %s from [%s] to [%s]", appName, joinPorts(from), joinPorts(to)),
+		run: func() error {
+			err := updatePorts(portManager, appGuid, change)
+			if err != nil {
+				return err
+			}
+			return updateOpenedPorts(portManager, appGuid, change)
+		},
+	}
+}
+
+func containsPort(ports []int, port int) bool {
+	for _, p := range ports {
+		if p == port {
+			return true
+		}
+	}
+	return false
+}
+
+func joinPorts(ports []int) string {
+	var s []string
+	for _, p := range ports {
+		s = append(s, strconv.Itoa(p))
+	}
+	return strings.Join(s, ", ")
+}
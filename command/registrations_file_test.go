@@ -0,0 +1,279 @@
+package command_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	plugin_models "code.cloudfoundry.org/cli/plugin/models"
+	"github.com/pivotal-cf/metric-registrar-cli/command"
+	"github.com/pivotal-cf/metric-registrar-cli/registrations"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Registrations file", func() {
+	var (
+		registrationFetcher *mockRegistrationFetcher
+		portManager         *mockPortManager
+		cliConn             *mockCliConnection
+		writer              *spyWriter
+		file                string
+	)
+
+	BeforeEach(func() {
+		registrationFetcher = newMockRegistrationFetcher()
+		registrationFetcher.registrations["app-guid"] = []registrations.Registration{
+			{Name: "metrics-endpoint-old", Type: "metrics-endpoint", Config: "/old", NumberOfBindings: 1},
+			{Name: "secure-endpoint-9090-metrics", Type: "secure-endpoint", Config: ":9090/metrics", NumberOfBindings: 2},
+		}
+		portManager = newMockPortManager()
+		portManager.exposedPorts = []int{8080, 9090}
+		portManager.openedPorts = []int{9090}
+		cliConn = newMockCliConnection()
+		writer = newSpyWriter()
+
+		file = writeRegistrationsFile(`
+apps:
+- name: app-name
+  log_formats: [json]
+  metrics_endpoints:
+  - path: /metrics
+    internal_port: 2112
+`)
+	})
+
+	Describe("PlanRegistrations", func() {
+		It("prints the changes without making them", func() {
+			err := command.PlanRegistrations(writer, registrationFetcher, portManager, cliConn, file, false)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(writer.lines()).To(Equal([]string{
+				"set ports of app-name from [8080, 9090] to [8080, 9090, 2112]",
+				"create service structured-format-json-09cae105ae65ef6b for structured-format://json",
+				"bind structured-format-json-09cae105ae65ef6b to app-name",
+				"create service secure-endpoint-2112-metrics-fdae1312e3715d76 for secure-endpoint://:2112/metrics",
+				"bind secure-endpoint-2112-metrics-fdae1312e3715d76 to app-name",
+				"unbind metrics-endpoint-old from app-name",
+				"unbind secure-endpoint-9090-metrics from app-name",
+				"set ports of app-name from [8080, 9090, 2112] to [8080, 2112]",
+				"delete service metrics-endpoint-old",
+				"",
+				"Plan: 9 changes.",
+				"",
+			}))
+			Expect(cliConn.cliCommandsCalled).ToNot(Receive())
+			Expect(portManager.setPortsCalled).ToNot(Receive())
+		})
+
+		It("doesn't create services that already exist", func() {
+			registrationFetcher.instances = []registrations.Registration{
+				{Name: "structured-format-json", Type: "structured-format", Config: "json"},
+			}
+
+			err := command.PlanRegistrations(writer, registrationFetcher, portManager, cliConn, file, false)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(writer.lines()).To(ContainElement("bind structured-format-json to app-name"))
+			Expect(writer.lines()).ToNot(ContainElement("create service structured-format-json for structured-format://json"))
+		})
+
+		It("reports no changes when the space matches the file", func() {
+			registrationFetcher.registrations["app-guid"] = []registrations.Registration{
+				{Name: "structured-format-json", Type: "structured-format", Config: "json", NumberOfBindings: 1},
+				{Name: "secure-endpoint-2112-metrics", Type: "secure-endpoint", Config: ":2112/metrics", NumberOfBindings: 1},
+			}
+			portManager.exposedPorts = []int{8080, 2112}
+
+			err := command.PlanRegistrations(writer, registrationFetcher, portManager, cliConn, file, true)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(writer.lines()).To(Equal([]string{
+				"No changes. Registrations match " + file + ".",
+				"",
+			}))
+		})
+
+		It("exposes ports that are registered but not exposed", func() {
+			registrationFetcher.registrations["app-guid"] = []registrations.Registration{
+				{Name: "structured-format-json", Type: "structured-format", Config: "json", NumberOfBindings: 1},
+				{Name: "secure-endpoint-2112-metrics", Type: "secure-endpoint", Config: ":2112/metrics", NumberOfBindings: 1},
+			}
+
+			err := command.PlanRegistrations(writer, registrationFetcher, portManager, cliConn, file, false)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(writer.lines()[0]).To(Equal("set ports of app-name from [8080, 9090] to [8080, 9090, 2112]"))
+		})
+
+		It("doesn't close ports the plugin didn't open", func() {
+			portManager.openedPorts = nil
+
+			err := command.PlanRegistrations(writer, registrationFetcher, portManager, cliConn, file, false)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(writer.lines()).ToNot(ContainElement("set ports of app-name from [8080, 9090, 2112] to [8080, 2112]"))
+			Expect(writer.lines()).To(ContainElement("Plan: 8 changes."))
+		})
+
+		It("doesn't delete a service the plan binds to another app", func() {
+			registrationFetcher.registrations["app-guid"] = []registrations.Registration{
+				{Name: "metrics-endpoint-old", Type: "metrics-endpoint", Config: "/old", NumberOfBindings: 1},
+			}
+			registrationFetcher.instances = registrationFetcher.registrations["app-guid"]
+			cliConn.getAppResults = map[string]plugin_models.GetAppModel{
+				"app-name":  {Name: "app-name", Guid: "app-guid"},
+				"other-app": {Name: "other-app", Guid: "other-guid"},
+			}
+			file = writeRegistrationsFile(`
+apps:
+- name: other-app
+  metrics_endpoints:
+  - path: /old
+    insecure: true
+- name: app-name
+`)
+
+			err := command.PlanRegistrations(writer, registrationFetcher, portManager, cliConn, file, false)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(writer.lines()).To(Equal([]string{
+				"bind metrics-endpoint-old to other-app",
+				"unbind metrics-endpoint-old from app-name",
+				"",
+				"Plan: 2 changes.",
+				"",
+			}))
+		})
+
+		It("exits with a detailed exit code when there are changes", func() {
+			err := command.PlanRegistrations(writer, registrationFetcher, portManager, cliConn, file, true)
+			Expect(err).To(Equal(command.ExitCodeChanges))
+		})
+
+		It("leaves apps that aren't in the file alone", func() {
+			file = writeRegistrationsFile(`apps: []`)
+
+			err := command.PlanRegistrations(writer, registrationFetcher, portManager, cliConn, file, true)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		DescribeTable("invalid files", func(contents string) {
+			file = writeRegistrationsFile(contents)
+
+			err := command.PlanRegistrations(writer, registrationFetcher, portManager, cliConn, file, false)
+			Expect(err).To(HaveOccurred())
+		},
+			Entry("invalid YAML", `apps: [`),
+			Entry("unknown fields", "apps:\n- name: app-name\n  log_format: json"),
+			Entry("app without a name", "apps:\n- log_formats: [json]"),
+			Entry("app listed twice", "apps:\n- name: app-name\n- name: app-name"),
+			Entry("empty log format", "apps:\n- name: app-name\n  log_formats: ['']"),
+			Entry("endpoint without a path", "apps:\n- name: app-name\n  metrics_endpoints:\n  - internal_port: 2112"),
+			Entry("endpoint without a port", "apps:\n- name: app-name\n  metrics_endpoints:\n  - path: /metrics"),
+			Entry("endpoint with a port and insecure", "apps:\n- name: app-name\n  metrics_endpoints:\n  - path: /metrics\n    internal_port: 2112\n    insecure: true"),
+			Entry("secure endpoint with a host", "apps:\n- name: app-name\n  metrics_endpoints:\n  - path: app-host.app-domain/metrics\n    internal_port: 2112"),
+		)
+
+		It("returns an error if the file doesn't exist", func() {
+			err := command.PlanRegistrations(writer, registrationFetcher, portManager, cliConn, filepath.Join(GinkgoT().TempDir(), "missing.yml"), false)
+			Expect(err).To(HaveOccurred())
+		})
+
+		DescribeTable("errors", func(modify func()) {
+			modify()
+
+			err := command.PlanRegistrations(writer, registrationFetcher, portManager, cliConn, file, false)
+			Expect(err).To(MatchError("expected"))
+		},
+			Entry("fetching registrations fails", func() {
+				registrationFetcher.fetchError = errors.New("expected")
+			}),
+			Entry("getting services fails", func() {
+				cliConn.getServicesError = errors.New("expected")
+			}),
+			Entry("getting the app fails", func() {
+				cliConn.getAppError = errors.New("expected")
+			}),
+			Entry("getting ports fails", func() {
+				portManager.getPortsError = errors.New("expected")
+			}),
+		)
+	})
+
+	Describe("ApplyRegistrations", func() {
+		It("makes the planned changes", func() {
+			err := command.ApplyRegistrations(writer, registrationFetcher, portManager, cliConn, file)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(portManager.setPortsCalled).To(Receive(Equal([]int{8080, 9090, 2112})))
+			Expect(portManager.setOpenedPortsCalled).To(Receive(Equal([]int{9090, 2112})))
+			Expect(cliConn.cliCommandsCalled).To(Receive(Equal([]string{"create-user-provided-service", "structured-format-json-09cae105ae65ef6b", "-l", "structured-format://json"})))
+			Expect(cliConn.cliCommandsCalled).To(Receive(Equal([]string{"bind-service", "app-name", "structured-format-json-09cae105ae65ef6b"})))
+			Expect(cliConn.cliCommandsCalled).To(Receive(Equal([]string{"create-user-provided-service", "secure-endpoint-2112-metrics-fdae1312e3715d76", "-l", "secure-endpoint://:2112/metrics"})))
+			Expect(cliConn.cliCommandsCalled).To(Receive(Equal([]string{"bind-service", "app-name", "secure-endpoint-2112-metrics-fdae1312e3715d76"})))
+			Expect(cliConn.cliCommandsCalled).To(Receive(Equal([]string{"unbind-service", "app-name", "metrics-endpoint-old"})))
+			Expect(cliConn.cliCommandsCalled).To(Receive(Equal([]string{"unbind-service", "app-name", "secure-endpoint-9090-metrics"})))
+			Expect(portManager.setPortsCalled).To(Receive(Equal([]int{8080, 2112})))
+			Expect(portManager.setOpenedPortsCalled).To(Receive(Equal([]int{2112})))
+			Expect(cliConn.cliCommandsCalled).To(Receive(Equal([]string{"delete-service", "metrics-endpoint-old", "-f"})))
+			Expect(cliConn.cliCommandsCalled).ToNot(Receive())
+
+			Expect(writer.lines()).To(ContainElement("Applied 9 changes."))
+		})
+
+		It("stops at the first failed change", func() {
+			cliConn.cliErrorCommand = "bind-service"
+
+			err := command.ApplyRegistrations(writer, registrationFetcher, portManager, cliConn, file)
+			Expect(err).To(HaveOccurred())
+
+			Expect(cliConn.cliCommandsCalled).To(Receive(Equal([]string{"create-user-provided-service", "structured-format-json-09cae105ae65ef6b", "-l", "structured-format://json"})))
+			Expect(cliConn.cliCommandsCalled).To(Receive(Equal([]string{"bind-service", "app-name", "structured-format-json-09cae105ae65ef6b"})))
+			Expect(cliConn.cliCommandsCalled).ToNot(Receive())
+		})
+	})
+
+	Describe("ExportRegistrations", func() {
+		It("writes the space's registrations as a registrations file", func() {
+			registrationFetcher.registrations["app-guid"] = append(
+				registrationFetcher.registrations["app-guid"],
+				registrations.Registration{Name: "structured-format-json", Type: "structured-format", Config: "json"},
+			)
+			registrationFetcher.registrations["other-guid"] = []registrations.Registration{
+				{Name: "structured-format-dogstatsd", Type: "structured-format", Config: "DogStatsD"},
+			}
+			cliConn.getAppsResult = []plugin_models.GetAppsModel{
+				{Name: "z-app", Guid: "app-guid"},
+				{Name: "a-app", Guid: "other-guid"},
+				{Name: "unregistered", Guid: "unregistered-guid"},
+			}
+
+			err := command.ExportRegistrations(writer, registrationFetcher, cliConn)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(string(writer.bytes)).To(MatchYAML(`
+apps:
+- name: a-app
+  log_formats: [DogStatsD]
+- name: z-app
+  log_formats: [json]
+  metrics_endpoints:
+  - path: /old
+    insecure: true
+  - path: /metrics
+    internal_port: 9090
+`))
+		})
+
+		It("returns an error if fetching registrations fails", func() {
+			registrationFetcher.fetchError = errors.New("expected")
+
+			err := command.ExportRegistrations(writer, registrationFetcher, cliConn)
+			Expect(err).To(MatchError("expected"))
+		})
+	})
+})
+
+func writeRegistrationsFile(contents string) string {
+	path := filepath.Join(GinkgoT().TempDir(), "registrations.yml")
+	Expect(os.WriteFile(path, []byte(contents), 0600)).To(Succeed())
+	return path
+}
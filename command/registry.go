@@ -4,6 +4,10 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"time"
+
+	"github.com/pivotal-cf/metric-registrar-cli/cloudcontroller"
+	"github.com/pivotal-cf/metric-registrar-cli/registrations"
 
 	"code.cloudfoundry.org/cli/plugin"
 )
@@ -15,6 +19,14 @@ const (
 	unregisterMetricsEndpointCommand = "unregister-metrics-endpoint"
 	listLogFormatsCommand            = "registered-log-formats"
 	listMetricsEndpointsCommand      = "registered-metrics-endpoints"
+	planRegistrationsCommand         = "plan-registrations"
+	applyRegistrationsCommand        = "apply-registrations"
+	exportRegistrationsCommand       = "export-registrations"
+	migrateServiceNamesCommand       = "migrate-service-names"
+	updateMetricsEndpointCommand     = "update-metrics-endpoint"
+	migrateToSecureCommand           = "migrate-to-secure"
+	doctorCommand                    = "metric-registrar-doctor"
+	cleanupOrphansCommand            = "cleanup-orphans"
 )
 
 type Command struct {
@@ -25,12 +37,14 @@ type Command struct {
 	Options   map[string]Option
 
 	Flags interface{}
-	Run   func(fetcher registrationFetcher, conn plugin.CliConnection) error
+	// Validate, when set, checks the parsed arguments before Run
+	Validate func() error
+	Run      func(fetcher registrationFetcher, portManager portManager, selector appSelector, conn plugin.CliConnection) error
 }
 
 func (c Command) Usage() string {
 	argsAndOptions := append([]string{}, c.Arguments...)
-	for flag, opt := range c.Options {
+	for flag, opt := range c.allOptions() {
 		argsAndOptions = append(argsAndOptions, fmt.Sprintf("[-%s %s]", flag, opt.Name))
 	}
 
@@ -40,64 +54,248 @@ func (c Command) Usage() string {
 	)
 }
 
+func (c Command) allOptions() map[string]Option {
+	opts := map[string]Option{}
+	for flag, opt := range globalOptions {
+		opts[flag] = opt
+	}
+	for flag, opt := range c.Options {
+		opts[flag] = opt
+	}
+	return opts
+}
+
 type Option struct {
 	Name        string
 	Description string
 }
 
+// globalFlags are accepted by every command
+var globalFlags = &struct {
+	Concurrency int           `long:"concurrency"`
+	MaxRetries  int           `long:"max-retries"`
+	Timeout     time.Duration `long:"timeout"`
+	DryRun      bool          `long:"dry-run"`
+}{
+	Concurrency: registrations.DefaultConcurrency,
+	MaxRetries:  cloudcontroller.DefaultMaxRetries,
+	Timeout:     cloudcontroller.DefaultTimeout,
+}
+
+var globalOptions = map[string]Option{
+	"-concurrency": {
+		Name:        "N",
+		Description: "maximum number of Cloud Controller lookups to run at once",
+	},
+	"-max-retries": {
+		Name:        "N",
+		Description: "number of times to retry Cloud Controller requests that fail transiently",
+	},
+	"-timeout": {
+		Name:        "DURATION",
+		Description: "time limit for each Cloud Controller request, e.g. 30s",
+	},
+	"-dry-run": {
+		Name:        "DRY_RUN",
+		Description: "print the changes the command would make without making them",
+	},
+}
+
+// appSelectionFlags select apps for the register and unregister commands in
+// addition to the ones named as arguments.
+type appSelectionFlags struct {
+	AppsFile string `long:"apps-file"`
+	AppGlob  string `long:"app-glob"`
+	AppRegex string `long:"app-regex"`
+	Selector string `long:"selector"`
+	Parallel int    `long:"parallel"`
+}
+
+func (f appSelectionFlags) selection(appNames []string) AppSelection {
+	return AppSelection{
+		Names:         appNames,
+		File:          f.AppsFile,
+		Glob:          f.AppGlob,
+		Regex:         f.AppRegex,
+		LabelSelector: f.Selector,
+		Parallel:      f.Parallel,
+	}
+}
+
+// selectsApps reports whether apps are selected by a flag rather than only
+// by name.
+func (f appSelectionFlags) selectsApps() bool {
+	return f.AppsFile != "" || f.AppGlob != "" || f.AppRegex != "" || f.Selector != ""
+}
+
+// requireAppNames checks that args hold at least one app name before the
+// argument named last, unless a flag selects the apps.
+func (f appSelectionFlags) requireAppNames(args []string, last string) error {
+	if len(args) < 2 && !f.selectsApps() {
+		return fmt.Errorf("expected APP_NAME... %s, or %s with --apps-file, --app-glob, --app-regex or --selector", last, last)
+	}
+	return nil
+}
+
+// splitLast separates the app names from the argument that follows them.
+func splitLast(args []string) ([]string, string) {
+	return args[:len(args)-1], args[len(args)-1]
+}
+
 var registerLogFormatFlags = &struct {
-	Args struct {
-		AppName string `positional-arg-name:"APP_NAME"`
-		Format  string `positional-arg-name:"FORMAT"`
-	} `positional-args:"APP_NAME FORMAT" required:"2"`
-}{}
+	appSelectionFlags
+	Output string `short:"o" long:"output"`
+	Args   struct {
+		AppNamesAndFormat []string `positional-arg-name:"APP_NAME... FORMAT" required:"1"`
+	} `positional-args:"yes"`
+}{appSelectionFlags: appSelectionFlags{Parallel: DefaultParallel}}
 
 var registerMetricsEndpointFlags = &struct {
+	appSelectionFlags
 	InternalPort string `short:"p" long:"internal-port"`
 	Insecure     bool   `short:"k" long:"insecure"`
+	Output       string `short:"o" long:"output"`
+	Args         struct {
+		AppNamesAndPath []string `positional-arg-name:"APP_NAME... PATH" required:"1"`
+	} `positional-args:"yes"`
+}{appSelectionFlags: appSelectionFlags{Parallel: DefaultParallel}}
+
+var unregisterMetricsEndpointFlags = &struct {
+	appSelectionFlags
+	Path            string `short:"p" long:"path"`
+	Port            string `long:"internal-port"`
+	ContinueOnError bool   `long:"continue-on-error"`
+	Args            struct {
+		AppNames []string `positional-arg-name:"APP_NAME"`
+	} `positional-args:"yes"`
+}{appSelectionFlags: appSelectionFlags{Parallel: DefaultParallel}}
+
+var unregisterLogFormatFlags = &struct {
+	appSelectionFlags
+	Format          string `short:"f" long:"format"`
+	ContinueOnError bool   `long:"continue-on-error"`
+	Args            struct {
+		AppNames []string `positional-arg-name:"APP_NAME"`
+	} `positional-args:"yes"`
+}{appSelectionFlags: appSelectionFlags{Parallel: DefaultParallel}}
+
+var listFlags = &struct {
+	App         string `short:"a" long:"app"`
+	Selector    string `long:"selector"`
+	ManagedOnly bool   `long:"managed-only"`
+	Output      string `short:"o" long:"output"`
+	Format      string `long:"format"`
+	Sort        string `long:"sort"`
+	Columns     string `long:"columns"`
+}{}
+
+var planRegistrationsFlags = &struct {
+	DetailedExitCode bool `long:"detailed-exitcode"`
+	Args             struct {
+		File string `positional-arg-name:"FILE"`
+	} `positional-args:"FILE" required:"1"`
+}{}
+
+var applyRegistrationsFlags = &struct {
+	Args struct {
+		File string `positional-arg-name:"FILE"`
+	} `positional-args:"FILE" required:"1"`
+}{}
+
+var exportRegistrationsFlags = &struct{}{}
+
+var migrateServiceNamesFlags = &struct{}{}
+
+var migrateToSecureFlags = &struct {
+	InternalPort string `short:"p" long:"internal-port" required:"true"`
 	Args         struct {
 		AppName string `positional-arg-name:"APP_NAME"`
-		Path    string `positional-arg-name:"PATH"`
-	} `positional-args:"APP_NAME PATH" required:"2"`
+	} `positional-args:"APP_NAME"`
 }{}
 
-var unregisterMetricsEndpointFlags = &struct {
-	Path string `short:"p" long:"path"`
-	Port string `long:"internal-port"`
+var doctorFlags = &struct {
+	Fix  bool `long:"fix"`
 	Args struct {
 		AppName string `positional-arg-name:"APP_NAME"`
-	} `positional-args:"APP_NAME" required:"1"`
+	} `positional-args:"APP_NAME"`
 }{}
 
-var unregisterLogFormatFlags = &struct {
-	Format string `short:"f" long:"format"`
-	Args   struct {
+var cleanupOrphansFlags = &struct {
+	Force  bool          `short:"f" long:"force"`
+	MinAge time.Duration `long:"min-age"`
+}{}
+
+var updateMetricsEndpointFlags = &struct {
+	FromPath string `long:"from-path"`
+	FromPort string `long:"from-port"`
+	ToPath   string `long:"to-path"`
+	ToPort   string `long:"to-port"`
+	Args     struct {
 		AppName string `positional-arg-name:"APP_NAME"`
 	} `positional-args:"APP_NAME" required:"1"`
 }{}
 
-var listFlags = &struct {
-	App string `short:"a" long:"app"`
-}{}
+const registerOutputDescription = "print the result as JSON, including whether the app was already registered"
+
+const continueOnErrorDescription = "keep unregistering after a failure and print a summary of every operation"
+
+// withAppSelection adds the options of appSelectionFlags to a command's own.
+func withAppSelection(opts map[string]Option) map[string]Option {
+	opts["-apps-file"] = Option{
+		Name:        "FILE",
+		Description: "also run on the apps listed in FILE, one per line",
+	}
+	opts["-app-glob"] = Option{
+		Name:        "GLOB",
+		Description: "also run on the apps whose names match GLOB, e.g. 'billing-*'",
+	}
+	opts["-app-regex"] = Option{
+		Name:        "REGEX",
+		Description: "also run on the apps whose names match REGEX",
+	}
+	opts["-selector"] = Option{
+		Name:        "SELECTOR",
+		Description: "also run on the apps whose labels match SELECTOR, e.g. 'team=payments,tier=prod'",
+	}
+	opts["-parallel"] = Option{
+		Name:        "N",
+		Description: "number of apps to work on at once",
+	}
+	return opts
+}
 
 var Registry = map[string]Command{
 	registerLogFormatCommand: {
 		name:      registerLogFormatCommand,
 		HelpText:  "Register bound applications so that structured logs of the given format can be parsed",
-		Arguments: []string{"APP_NAME", "<json|DogStatsD>"},
-		Flags:     registerLogFormatFlags,
-		Run: func(_ registrationFetcher, conn plugin.CliConnection) error {
-			return RegisterLogFormat(
+		Arguments: []string{"APP_NAME...", "<json|DogStatsD>"},
+		Options: withAppSelection(map[string]Option{
+			"-output": {
+				Name:        "json",
+				Description: registerOutputDescription,
+			},
+		}),
+		Flags: registerLogFormatFlags,
+		Validate: func() error {
+			return registerLogFormatFlags.requireAppNames(registerLogFormatFlags.Args.AppNamesAndFormat, "FORMAT")
+		},
+		Run: func(fetcher registrationFetcher, _ portManager, selector appSelector, conn plugin.CliConnection) error {
+			appNames, format := splitLast(registerLogFormatFlags.Args.AppNamesAndFormat)
+			return RegisterLogFormatForApps(
+				os.Stdout,
+				fetcher,
 				conn,
-				registerLogFormatFlags.Args.AppName,
-				registerLogFormatFlags.Args.Format,
+				selector,
+				registerLogFormatFlags.selection(appNames),
+				format,
+				RegisterOptions{Output: registerLogFormatFlags.Output},
 			)
 		},
 	},
 	registerMetricsEndpointCommand: {
 		name:     registerMetricsEndpointCommand,
 		HelpText: "Register a metrics endpoint which will be scraped at the interval defined at deploy",
-		Options: map[string]Option{
+		Options: withAppSelection(map[string]Option{
 			"-internal-port": {
 				Name:        "PORT",
 				Description: "Port for secure metrics endpoint scraping",
@@ -106,43 +304,64 @@ var Registry = map[string]Command{
 				Name:        "INSECURE",
 				Description: "Use legacy insecure HTTP endpoint",
 			},
-		},
-		Arguments: []string{"APP_NAME", "PATH"},
+			"-output": {
+				Name:        "json",
+				Description: registerOutputDescription,
+			},
+		}),
+		Arguments: []string{"APP_NAME...", "PATH"},
 		Flags:     registerMetricsEndpointFlags,
-		Run: func(_ registrationFetcher, conn plugin.CliConnection) error {
-			return RegisterMetricsEndpoint(
+		Validate: func() error {
+			return registerMetricsEndpointFlags.requireAppNames(registerMetricsEndpointFlags.Args.AppNamesAndPath, "PATH")
+		},
+		Run: func(fetcher registrationFetcher, portManager portManager, selector appSelector, conn plugin.CliConnection) error {
+			appNames, path := splitLast(registerMetricsEndpointFlags.Args.AppNamesAndPath)
+			return RegisterMetricsEndpointForApps(
+				os.Stdout,
+				fetcher,
 				conn,
-				registerMetricsEndpointFlags.Args.AppName,
-				registerMetricsEndpointFlags.Args.Path,
+				selector,
+				portManager,
+				registerMetricsEndpointFlags.selection(appNames),
+				path,
 				registerMetricsEndpointFlags.InternalPort,
 				registerMetricsEndpointFlags.Insecure,
+				RegisterOptions{Output: registerMetricsEndpointFlags.Output},
 			)
 		},
 	},
 	unregisterLogFormatCommand: {
-		name:     unregisterLogFormatCommand,
-		HelpText: "Unregister log formats",
-		Options: map[string]Option{
+		name:      unregisterLogFormatCommand,
+		HelpText:  "Unregister log formats",
+		Arguments: []string{"APP_NAME..."},
+		Options: withAppSelection(map[string]Option{
 			"f": {
 				Name:        "FORMAT",
 				Description: "unregister only the specified log format",
 			},
-		},
+			"-continue-on-error": {
+				Name:        "CONTINUE_ON_ERROR",
+				Description: continueOnErrorDescription,
+			},
+		}),
 		Flags: unregisterLogFormatFlags,
-		Run: func(fetcher registrationFetcher, conn plugin.CliConnection) error {
-			return UnregisterLogFormat(
+		Run: func(fetcher registrationFetcher, _ portManager, selector appSelector, conn plugin.CliConnection) error {
+			return UnregisterLogFormatForApps(
+				os.Stdout,
 				fetcher,
 				conn,
-				unregisterLogFormatFlags.Args.AppName,
+				selector,
+				unregisterLogFormatFlags.selection(unregisterLogFormatFlags.Args.AppNames),
 				unregisterLogFormatFlags.Format,
+				UnregisterOptions{ContinueOnError: unregisterLogFormatFlags.ContinueOnError},
 			)
 		},
 	},
 	unregisterMetricsEndpointCommand: {
 		name:      unregisterMetricsEndpointCommand,
 		HelpText:  "Unregister metrics endpoints",
-		Arguments: []string{"APP_NAME"},
-		Options: map[string]Option{
+		Arguments: []string{"APP_NAME..."},
+		Options: withAppSelection(map[string]Option{
 			"p": {
 				Name:        "PATH",
 				Description: "unregister only the specified path",
@@ -151,15 +370,23 @@ var Registry = map[string]Command{
 				Name:        "PORT",
 				Description: "unregister only the specified port+path for secure endpoints",
 			},
-		},
+			"-continue-on-error": {
+				Name:        "CONTINUE_ON_ERROR",
+				Description: continueOnErrorDescription,
+			},
+		}),
 		Flags: unregisterMetricsEndpointFlags,
-		Run: func(fetcher registrationFetcher, conn plugin.CliConnection) error {
-			return UnregisterMetricsEndpoint(
+		Run: func(fetcher registrationFetcher, portManager portManager, selector appSelector, conn plugin.CliConnection) error {
+			return UnregisterMetricsEndpointForApps(
+				os.Stdout,
 				fetcher,
 				conn,
-				unregisterMetricsEndpointFlags.Args.AppName,
+				selector,
+				portManager,
+				unregisterMetricsEndpointFlags.selection(unregisterMetricsEndpointFlags.Args.AppNames),
 				unregisterMetricsEndpointFlags.Path,
 				unregisterMetricsEndpointFlags.Port,
+				UnregisterOptions{ContinueOnError: unregisterMetricsEndpointFlags.ContinueOnError},
 			)
 		},
 	},
@@ -171,10 +398,39 @@ var Registry = map[string]Command{
 				Name:        "APP",
 				Description: "list log formats for only the specified app",
 			},
+			"-selector": {
+				Name:        "SELECTOR",
+				Description: "list log formats for only the apps whose labels match SELECTOR",
+			},
+			"-managed-only": {
+				Name:        "MANAGED_ONLY",
+				Description: "list only the services the plugin created and labelled",
+			},
+			"-output": {
+				Name:        "<table|json|yaml|csv>",
+				Description: "print log formats in the given format",
+			},
+			"-format": {
+				Name:        "TEMPLATE",
+				Description: "print each registration with a Go template, e.g. '{{.AppName}} {{.Path}}'",
+			},
+			"-sort": {
+				Name:        "COLUMN",
+				Description: "order registrations by the given column, defaults to app",
+			},
+			"-columns": {
+				Name:        "COLUMNS",
+				Description: "comma separated table columns: app, guid, type, format, port, path, service, bindings, managed",
+			},
 		},
 		Flags: listFlags,
-		Run: func(fetcher registrationFetcher, conn plugin.CliConnection) error {
-			return ListRegisteredLogFormats(os.Stdout, fetcher, conn, listFlags.App)
+		Run: func(fetcher registrationFetcher, _ portManager, selector appSelector, conn plugin.CliConnection) error {
+			return ListRegisteredLogFormats(os.Stdout, fetcher, conn, selector, ListFilter{Name: listFlags.App, LabelSelector: listFlags.Selector, ManagedOnly: listFlags.ManagedOnly}, OutputOptions{
+				Output:  listFlags.Output,
+				Format:  listFlags.Format,
+				Sort:    listFlags.Sort,
+				Columns: listFlags.Columns,
+			})
 		},
 	},
 	listMetricsEndpointsCommand: {
@@ -185,10 +441,191 @@ var Registry = map[string]Command{
 				Name:        "APP",
 				Description: "list metrics endpoints for only the specified app",
 			},
+			"-selector": {
+				Name:        "SELECTOR",
+				Description: "list metrics endpoints for only the apps whose labels match SELECTOR",
+			},
+			"-managed-only": {
+				Name:        "MANAGED_ONLY",
+				Description: "list only the services the plugin created and labelled",
+			},
+			"-output": {
+				Name:        "<table|json|yaml|csv>",
+				Description: "print metrics endpoints in the given format",
+			},
+			"-format": {
+				Name:        "TEMPLATE",
+				Description: "print each registration with a Go template, e.g. '{{.AppName}} {{.Path}}'",
+			},
+			"-sort": {
+				Name:        "COLUMN",
+				Description: "order registrations by the given column, defaults to app",
+			},
+			"-columns": {
+				Name:        "COLUMNS",
+				Description: "comma separated table columns: app, guid, type, format, port, path, service, bindings, managed",
+			},
 		},
 		Flags: listFlags,
-		Run: func(fetcher registrationFetcher, conn plugin.CliConnection) error {
-			return ListRegisteredMetricsEndpoints(os.Stdout, fetcher, conn, listFlags.App)
+		Run: func(fetcher registrationFetcher, _ portManager, selector appSelector, conn plugin.CliConnection) error {
+			return ListRegisteredMetricsEndpoints(os.Stdout, fetcher, conn, selector, ListFilter{Name: listFlags.App, LabelSelector: listFlags.Selector, ManagedOnly: listFlags.ManagedOnly}, OutputOptions{
+				Output:  listFlags.Output,
+				Format:  listFlags.Format,
+				Sort:    listFlags.Sort,
+				Columns: listFlags.Columns,
+			})
+		},
+	},
+	planRegistrationsCommand: {
+		name:      planRegistrationsCommand,
+		HelpText:  "Show the changes needed for the apps in a registrations file to match it",
+		Arguments: []string{"FILE"},
+		Options: map[string]Option{
+			"-detailed-exitcode": {
+				Name:        "DETAILED_EXITCODE",
+				Description: "exit with 2 when there are changes, 0 when there are none and 1 on errors",
+			},
+		},
+		Flags: planRegistrationsFlags,
+		Run: func(fetcher registrationFetcher, portManager portManager, _ appSelector, conn plugin.CliConnection) error {
+			return PlanRegistrations(
+				os.Stdout,
+				fetcher,
+				portManager,
+				conn,
+				planRegistrationsFlags.Args.File,
+				planRegistrationsFlags.DetailedExitCode,
+			)
+		},
+	},
+	applyRegistrationsCommand: {
+		name:      applyRegistrationsCommand,
+		HelpText:  "Change the registrations of the apps in a registrations file to match it",
+		Arguments: []string{"FILE"},
+		Flags:     applyRegistrationsFlags,
+		Run: func(fetcher registrationFetcher, portManager portManager, _ appSelector, conn plugin.CliConnection) error {
+			return ApplyRegistrations(os.Stdout, fetcher, portManager, conn, applyRegistrationsFlags.Args.File)
+		},
+	},
+	exportRegistrationsCommand: {
+		name:     exportRegistrationsCommand,
+		HelpText: "Print the registrations in space as a registrations file",
+		Flags:    exportRegistrationsFlags,
+		Run: func(fetcher registrationFetcher, _ portManager, _ appSelector, conn plugin.CliConnection) error {
+			return ExportRegistrations(os.Stdout, fetcher, conn)
+		},
+	},
+	migrateServiceNamesCommand: {
+		name:     migrateServiceNamesCommand,
+		HelpText: "Rename services created by earlier versions of the plugin so their names can't collide",
+		Flags:    migrateServiceNamesFlags,
+		Run: func(fetcher registrationFetcher, _ portManager, _ appSelector, conn plugin.CliConnection) error {
+			return MigrateServiceNames(os.Stdout, fetcher, conn)
+		},
+	},
+	updateMetricsEndpointCommand: {
+		name:      updateMetricsEndpointCommand,
+		HelpText:  "Move a metrics endpoint to another path or internal port without a gap in scraping",
+		Arguments: []string{"APP_NAME"},
+		Options: map[string]Option{
+			"-from-path": {
+				Name:        "PATH",
+				Description: "path of the endpoint to update",
+			},
+			"-from-port": {
+				Name:        "PORT",
+				Description: "internal port of the endpoint to update, omit for an insecure endpoint",
+			},
+			"-to-path": {
+				Name:        "PATH",
+				Description: "new path, defaults to the current one",
+			},
+			"-to-port": {
+				Name:        "PORT",
+				Description: "new internal port, defaults to the current one",
+			},
+		},
+		Flags: updateMetricsEndpointFlags,
+		Run: func(fetcher registrationFetcher, portManager portManager, _ appSelector, conn plugin.CliConnection) error {
+			return UpdateMetricsEndpoint(
+				os.Stdout,
+				fetcher,
+				conn,
+				portManager,
+				updateMetricsEndpointFlags.Args.AppName,
+				UpdateEndpointOptions{
+					FromPath: updateMetricsEndpointFlags.FromPath,
+					FromPort: updateMetricsEndpointFlags.FromPort,
+					ToPath:   updateMetricsEndpointFlags.ToPath,
+					ToPort:   updateMetricsEndpointFlags.ToPort,
+				},
+			)
+		},
+	},
+	migrateToSecureCommand: {
+		name:      migrateToSecureCommand,
+		HelpText:  "Replace insecure metrics endpoints of an app, or of every app in the space, with secure endpoints",
+		Arguments: []string{"[APP_NAME]"},
+		Options: map[string]Option{
+			"-internal-port": {
+				Name:        "PORT",
+				Description: "Port for secure metrics endpoint scraping",
+			},
+		},
+		Flags: migrateToSecureFlags,
+		Run: func(fetcher registrationFetcher, portManager portManager, _ appSelector, conn plugin.CliConnection) error {
+			return MigrateToSecure(
+				os.Stdout,
+				fetcher,
+				conn,
+				portManager,
+				migrateToSecureFlags.Args.AppName,
+				migrateToSecureFlags.InternalPort,
+			)
+		},
+	},
+	doctorCommand: {
+		name:      doctorCommand,
+		HelpText:  "Check the registrations of an app, or of every app in the space, for problems",
+		Arguments: []string{"[APP_NAME]"},
+		Options: map[string]Option{
+			"-fix": {
+				Name:        "FIX",
+				Description: "repair the problems that can be fixed safely",
+			},
+		},
+		Flags: doctorFlags,
+		Run: func(fetcher registrationFetcher, portManager portManager, _ appSelector, conn plugin.CliConnection) error {
+			return Doctor(
+				os.Stdout,
+				fetcher,
+				conn,
+				portManager,
+				doctorFlags.Args.AppName,
+				DoctorOptions{Fix: doctorFlags.Fix},
+			)
+		},
+	},
+	cleanupOrphansCommand: {
+		name:     cleanupOrphansCommand,
+		HelpText: "Delete registration services that no app is bound to",
+		Options: map[string]Option{
+			"f": {
+				Name:        "FORCE",
+				Description: "delete without asking for confirmation",
+			},
+			"-min-age": {
+				Name:        "DURATION",
+				Description: "only delete services created at least this long ago, e.g. 168h",
+			},
+		},
+		Flags: cleanupOrphansFlags,
+		Run: func(fetcher registrationFetcher, _ portManager, _ appSelector, conn plugin.CliConnection) error {
+			return CleanupOrphans(os.Stdout, os.Stdin, fetcher, conn, CleanupOptions{
+				// a dry run deletes nothing, so there is nothing to confirm
+				Force:  cleanupOrphansFlags.Force || globalFlags.DryRun,
+				MinAge: cleanupOrphansFlags.MinAge,
+			})
 		},
 	},
 }
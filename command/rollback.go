@@ -0,0 +1,37 @@
+package command
+
+import (
+	"fmt"
+	"io"
+)
+
+// rollback records how to undo each completed step of a registration so a
+// failed registration doesn't leave open ports or orphaned services behind.
+type rollback struct {
+	steps []action
+}
+
+func (r *rollback) add(description string, undo func() error) {
+	r.steps = append(r.steps, action{description: description, run: undo})
+}
+
+// run undoes the recorded steps, most recent first, and reports each one. It
+// returns the error that caused the rollback.
+func (r *rollback) run(writer io.Writer, appName string, cause error) error {
+	if len(r.steps) == 0 {
+		return cause
+	}
+
+	fmt.Fprintf(writer, "Registering %s failed, rolling back:\n", appName)
+	for i := len(r.steps) - 1; i >= 0; i-- {
+		step := r.steps[i]
+		err := step.run()
+		if err != nil {
+			fmt.Fprintf(writer, "  failed to %s: %s\n", step.description, err)
+			continue
+		}
+		fmt.Fprintf(writer, "  %s\n", step.description)
+	}
+
+	return cause
+}
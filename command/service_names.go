@@ -0,0 +1,118 @@
+package command
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+const (
+	// maxServiceNameLength is the Cloud Controller's limit on service
+	// instance names.
+	maxServiceNameLength = 255
+
+	// serviceNameHashLength is the number of hex digits of the drain URL's
+	// hash that end every service name.
+	serviceNameHashLength = 16
+)
+
+// generateServiceName names the service for a registration. The readable part
+// is lossy, e.g. /a/b and /a-b look the same, so it ends with a hash of the
+// exact drain URL to keep different registrations from sharing a name.
+func generateServiceName(serviceProtocol, config string) string {
+	sum := sha256.Sum256([]byte(serviceProtocol + "://" + config))
+	hash := hex.EncodeToString(sum[:])[:serviceNameHashLength]
+
+	readable := serviceProtocol + "-" + sanitizeConfig(config)
+	if limit := maxServiceNameLength - len(hash) - 1; len(readable) > limit {
+		readable = readable[:limit]
+	}
+	return strings.TrimRight(readable, "-") + "-" + hash
+}
+
+// legacyServiceName is the name earlier versions of the plugin gave a
+// registration's service. Different configs could get the same name.
+func legacyServiceName(serviceProtocol, config string) string {
+	cleanedConfig := sanitizeConfig(config)
+	serviceName := serviceProtocol + "-" + cleanedConfig
+	if len(serviceName) > 50 {
+		hasher := sha1.New()
+		hasher.Write([]byte(cleanedConfig))
+		serviceName = serviceProtocol + "-" + strings.Trim(base64.URLEncoding.EncodeToString(hasher.Sum(nil)), "=")
+	}
+	return serviceName
+}
+
+func sanitizeConfig(config string) string {
+	slashToDashes := strings.Replace(config, "/", "-", -1)
+	removeColons := strings.Replace(slashToDashes, ":", "", -1)
+	return strings.Trim(removeColons, "-")
+}
+
+// serviceNames finds the service instance to bind for a registration.
+type serviceNames struct {
+	registered map[registrationKey]string
+	taken      map[string]bool
+}
+
+func loadServiceNames(cliConn cliCommandRunner, fetcher registrationFetcher, registrationTypes ...string) (serviceNames, error) {
+	if batch, ok := fetcher.(batchSnapshot); ok {
+		return batch.serviceNames(cliConn)
+	}
+
+	n := serviceNames{
+		registered: map[registrationKey]string{},
+		taken:      map[string]bool{},
+	}
+
+	instances, err := fetcher.FetchInstances(registrationTypes...)
+	if err != nil {
+		return serviceNames{}, err
+	}
+	for _, r := range instances {
+		k := registrationKey{Type: r.Type, Config: r.Config}
+		if _, ok := n.registered[k]; !ok {
+			n.registered[k] = r.Name
+		}
+	}
+
+	services, err := cliConn.GetServices()
+	if err != nil {
+		return serviceNames{}, err
+	}
+	for _, s := range services {
+		n.taken[s.Name] = true
+	}
+
+	return n, nil
+}
+
+// lookup returns the instance whose drain URL matches the registration,
+// whatever it is called, and true. Otherwise it returns the name for a new
+// instance, which must not already belong to another service.
+func (n serviceNames) lookup(k registrationKey) (string, bool, error) {
+	if name, ok := n.registered[k]; ok {
+		return name, true, nil
+	}
+
+	name := generateServiceName(k.Type, k.Config)
+	if n.taken[name] {
+		return "", false, fmt.Errorf("service %s already exists but isn't registered for %s://%s", name, k.Type, k.Config)
+	}
+	return name, false, nil
+}
+
+// add records a new instance so that later registrations reuse it.
+func (n serviceNames) add(k registrationKey, name string) {
+	n.registered[k] = name
+	n.taken[name] = true
+}
+
+// remove forgets an instance that was deleted again.
+func (n serviceNames) remove(k registrationKey, name string) {
+	delete(n.registered, k)
+	delete(n.taken, name)
+}
@@ -1,22 +1,41 @@
 package command
 
 import (
+	"fmt"
+	"io"
 	"strconv"
 	"strings"
+	"text/tabwriter"
 
-	porter "github.com/pivotal-cf/metric-registrar-cli/ports"
 	"github.com/pivotal-cf/metric-registrar-cli/registrations"
 )
 
-func UnregisterLogFormat(registrationFetcher registrationFetcher, cliConn cliCommandRunner, appName, format string) error {
-	return removeRegistrations(registrationFetcher, cliConn, appName, structuredFormat, format)
+// UnregisterOptions change how unregister handles failures. With
+// ContinueOnError every matching registration is attempted and a summary of
+// the operations is printed at the end.
+type UnregisterOptions struct {
+	ContinueOnError bool
 }
 
-func UnregisterMetricsEndpoint(registrationFetcher registrationFetcher, cliConn cliCommandRunner, appName, path, port string) error {
-	return removeMetricRegistrations(registrationFetcher, cliConn, appName, metricsEndpoint, path, port)
+func UnregisterLogFormat(writer io.Writer, registrationFetcher registrationFetcher, cliConn cliCommandRunner, appName, format string, opts UnregisterOptions) error {
+	report := &unregisterReport{}
+	err := removeRegistrations(registrationFetcher, cliConn, report, appName, structuredFormat, format, opts)
+	if err != nil {
+		return err
+	}
+	return report.finish(writer, opts)
 }
 
-func removeRegistrations(registrationFetcher registrationFetcher, cliConn cliCommandRunner, appName, registrationType, config string) error {
+func UnregisterMetricsEndpoint(writer io.Writer, registrationFetcher registrationFetcher, cliConn cliCommandRunner, portManager portManager, appName, path, port string, opts UnregisterOptions) error {
+	report := &unregisterReport{}
+	err := removeMetricRegistrations(registrationFetcher, cliConn, portManager, report, appName, path, port, opts)
+	if err != nil {
+		return err
+	}
+	return report.finish(writer, opts)
+}
+
+func removeRegistrations(registrationFetcher registrationFetcher, cliConn cliCommandRunner, report *unregisterReport, appName, registrationType, config string, opts UnregisterOptions) error {
 	app, err := cliConn.GetApp(appName)
 	if err != nil {
 		return err
@@ -32,17 +51,16 @@ func removeRegistrations(registrationFetcher registrationFetcher, cliConn cliCom
 			continue
 		}
 
-		err := removeRegistration(appName, registration, cliConn)
-		if err != nil {
+		_, err := removeRegistration(registrationFetcher, appName, registration, cliConn, report)
+		if err != nil && !opts.ContinueOnError {
 			return err
 		}
-
 	}
 
 	return nil
 }
 
-func removeMetricRegistrations(registrationFetcher registrationFetcher, cliConn cliCommandRunner, appName, registrationType, path, port string) error {
+func removeMetricRegistrations(registrationFetcher registrationFetcher, cliConn cliCommandRunner, portManager portManager, report *unregisterReport, appName, path, port string, opts UnregisterOptions) error {
 	config := path
 	if port != "" {
 		config = ":" + port + config
@@ -57,21 +75,56 @@ func removeMetricRegistrations(registrationFetcher registrationFetcher, cliConn
 		return err
 	}
 
-	portsToRemove, err := removeMatchingRegistrations(existingRegistrations, config, appName, cliConn)
-	if err != nil {
-		return err
+	// read the ports before changing anything so that a failed read can't
+	// leave the app half unregistered. Only secure endpoints have ports.
+	var candidates, currentPorts, openedPorts []int
+	for _, r := range existingRegistrations {
+		if p := getPortFromConfig(r.Config); p != -1 && configMatch(config, r.Config) {
+			candidates = append(candidates, p)
+		}
+	}
+	if len(candidates) > 0 {
+		currentPorts, err = portManager.GetPortsForApp(app.Guid)
+		if err != nil {
+			return err
+		}
+		openedPorts, err = openedPortsForApp(portManager, app.Guid, candidates)
+		if err != nil {
+			return err
+		}
 	}
 
-	currentPorts, err := porter.GetPortsForApp(cliConn, app.Guid)
+	portsToRemove, err := removeMatchingRegistrations(registrationFetcher, existingRegistrations, config, appName, cliConn, report, opts)
 	if err != nil {
 		return err
 	}
 
-	remainingPorts := getRemainingPorts(currentPorts, portsToRemove)
+	// only close ports the plugin opened, the app may need the others
+	var portsToClose []int
+	for _, p := range portsToRemove {
+		if containsPort(openedPorts, p) {
+			portsToClose = append(portsToClose, p)
+		}
+	}
+	if len(portsToClose) == 0 {
+		return nil
+	}
 
-	// call setPorts with only ports that need to remain
-	err = porter.SetPortsForApp(cliConn, app.Guid, remainingPorts)
-	if err != nil {
+	change := portChange{remove: portsToClose}
+	if containsAnyPort(currentPorts, portsToClose) {
+		err = updatePorts(portManager, app.Guid, change)
+		report.add("set ports", appName, err)
+		if err != nil {
+			if opts.ContinueOnError {
+				return nil
+			}
+			return err
+		}
+	}
+
+	err = updateOpenedPorts(portManager, app.Guid, change)
+	report.add("record opened ports", appName, err)
+	if err != nil && !opts.ContinueOnError {
 		return err
 	}
 	return nil
@@ -85,7 +138,7 @@ func configMatch(config1, config2 string) bool {
 	return config1 == config2
 }
 
-func removeMatchingRegistrations(registrations []registrations.Registration, config, appName string, cliConn cliCommandRunner) ([]int, error) {
+func removeMatchingRegistrations(fetcher registrationFetcher, registrations []registrations.Registration, config, appName string, cliConn cliCommandRunner, report *unregisterReport, opts UnregisterOptions) ([]int, error) {
 	keepPort := map[int]bool{}
 
 	for _, r := range registrations {
@@ -96,11 +149,17 @@ func removeMatchingRegistrations(registrations []registrations.Registration, con
 			continue
 		}
 
-		err := removeRegistration(appName, r, cliConn)
-		if err != nil {
+		unbound, err := removeRegistration(fetcher, appName, r, cliConn, report)
+		if err != nil && !opts.ContinueOnError {
 			return nil, err
 		}
 
+		// the port is still scraped while the service is bound
+		if !unbound {
+			keepPort[p] = true
+			continue
+		}
+
 		if !keepPort[p] {
 			keepPort[p] = false
 		}
@@ -116,25 +175,6 @@ func removeMatchingRegistrations(registrations []registrations.Registration, con
 	return remove, nil
 }
 
-func getRemainingPorts(currentPorts, portsToRemove []int) []int {
-	stay := map[int]bool{}
-	for _, p := range currentPorts {
-		stay[p] = true
-	}
-	for _, p := range portsToRemove {
-		stay[p] = false
-	}
-
-	portsForCurl := []int{}
-	for p, ok := range stay {
-		if ok {
-			portsForCurl = append(portsForCurl, p)
-		}
-	}
-
-	return portsForCurl
-}
-
 func getPortFromConfig(config string) int {
 	if config == "" {
 		return -1
@@ -167,17 +207,80 @@ func getAllMetricsRegistrations(fetcher registrationFetcher, guid string) ([]reg
 	return r1, nil
 }
 
-func removeRegistration(appName string, registration registrations.Registration, cliConn cliCommandRunner) error {
+// removeRegistration unbinds the registration's service from the app and
+// deletes it if nothing else is bound. It reports whether the service was
+// unbound, which it can be even when deleting it fails.
+func removeRegistration(fetcher registrationFetcher, appName string, registration registrations.Registration, cliConn cliCommandRunner, report *unregisterReport) (bool, error) {
 	_, err := cliConn.CliCommandWithoutTerminalOutput("unbind-service", appName, registration.Name)
+	report.add("unbind-service", registration.Name, err)
 	if err != nil {
-		return err
+		return false, err
+	}
+
+	last := registration.NumberOfBindings == 1
+	if batch, ok := fetcher.(batchSnapshot); ok {
+		// other apps of the batch may have unbound it since the snapshot
+		last = batch.unbound(registration) == 0
 	}
 
-	if registration.NumberOfBindings == 1 {
+	if last {
 		_, err = cliConn.CliCommandWithoutTerminalOutput("delete-service", registration.Name, "-f")
+		report.add("delete-service", registration.Name, err)
 		if err != nil {
-			return err
+			return true, err
+		}
+	}
+	return true, nil
+}
+
+type operationResult struct {
+	operation string
+	target    string
+	err       error
+}
+
+// unregisterReport records each operation unregister attempts.
+type unregisterReport struct {
+	results []operationResult
+}
+
+func (r *unregisterReport) add(operation, target string, err error) {
+	r.results = append(r.results, operationResult{operation: operation, target: target, err: err})
+}
+
+func (r *unregisterReport) failed() int {
+	failed := 0
+	for _, result := range r.results {
+		if result.err != nil {
+			failed++
 		}
 	}
+	return failed
+}
+
+// finish prints the summary when continuing on errors and fails if any
+// operation did.
+func (r *unregisterReport) finish(writer io.Writer, opts UnregisterOptions) error {
+	if !opts.ContinueOnError || len(r.results) == 0 {
+		return nil
+	}
+
+	w := tabwriter.NewWriter(writer, 0, 8, 2, ' ', tabwriter.StripEscape)
+	writeFields(w, "Operation", "Target", "Result") //nolint:errcheck
+	for _, result := range r.results {
+		status := "ok"
+		if result.err != nil {
+			status = "failed: " + result.err.Error()
+		}
+		writeFields(w, result.operation, result.target, status) //nolint:errcheck
+	}
+	err := w.Flush()
+	if err != nil {
+		return err
+	}
+
+	if failed := r.failed(); failed > 0 {
+		return fmt.Errorf("%d of %d operations failed", failed, len(r.results))
+	}
 	return nil
 }
@@ -2,13 +2,10 @@ package command_test
 
 import (
 	"errors"
-	"fmt"
-	"strings"
 
 	"github.com/pivotal-cf/metric-registrar-cli/command"
 	"github.com/pivotal-cf/metric-registrar-cli/registrations"
 
-	. "github.com/benjamintf1/unmarshalledmatchers"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 )
@@ -33,7 +30,7 @@ var _ = Describe("Unregister", func() {
 				},
 			}
 
-			err := command.UnregisterLogFormat(registrationFetcher, cliConnection, "app-name", "")
+			err := command.UnregisterLogFormat(newSpyWriter(), registrationFetcher, cliConnection, "app-name", "", command.UnregisterOptions{})
 			Expect(err).ToNot(HaveOccurred())
 
 			Expect(cliConnection.cliCommandsCalled).To(Receive(ConsistOf(
@@ -61,7 +58,7 @@ var _ = Describe("Unregister", func() {
 				},
 			}
 
-			err := command.UnregisterLogFormat(registrationFetcher, cliConnection, "app-name", "")
+			err := command.UnregisterLogFormat(newSpyWriter(), registrationFetcher, cliConnection, "app-name", "", command.UnregisterOptions{})
 			Expect(err).ToNot(HaveOccurred())
 
 			Expect(cliConnection.cliCommandsCalled).To(Receive(ConsistOf(
@@ -95,7 +92,7 @@ var _ = Describe("Unregister", func() {
 				},
 			}
 
-			err := command.UnregisterLogFormat(registrationFetcher, cliConnection, "app-name", "json")
+			err := command.UnregisterLogFormat(newSpyWriter(), registrationFetcher, cliConnection, "app-name", "json", command.UnregisterOptions{})
 			Expect(err).ToNot(HaveOccurred())
 
 			Eventually(cliConnection.cliCommandsCalled).Should(Receive(ConsistOf(
@@ -112,7 +109,7 @@ var _ = Describe("Unregister", func() {
 			registrationFetcher := newMockRegistrationFetcher()
 			registrationFetcher.registrations["app-guid"] = nil
 
-			err := command.UnregisterLogFormat(registrationFetcher, cliConnection, "app-name", "")
+			err := command.UnregisterLogFormat(newSpyWriter(), registrationFetcher, cliConnection, "app-name", "", command.UnregisterOptions{})
 			Expect(err).ToNot(HaveOccurred())
 
 			Expect(cliConnection.cliCommandsCalled).ToNot(Receive())
@@ -123,7 +120,7 @@ var _ = Describe("Unregister", func() {
 			cliConnection.getAppError = errors.New("expected")
 			registrationFetcher := newMockRegistrationFetcher()
 
-			Expect(command.UnregisterLogFormat(registrationFetcher, cliConnection, "app-name", "")).ToNot(Succeed())
+			Expect(command.UnregisterLogFormat(newSpyWriter(), registrationFetcher, cliConnection, "app-name", "", command.UnregisterOptions{})).ToNot(Succeed())
 		})
 
 		It("returns error if unbinding service fails", func() {
@@ -139,7 +136,7 @@ var _ = Describe("Unregister", func() {
 				},
 			}
 
-			Expect(command.UnregisterLogFormat(registrationFetcher, cliConnection, "app-name", "")).ToNot(Succeed())
+			Expect(command.UnregisterLogFormat(newSpyWriter(), registrationFetcher, cliConnection, "app-name", "", command.UnregisterOptions{})).ToNot(Succeed())
 		})
 
 		It("returns error if deleting service fails", func() {
@@ -155,7 +152,7 @@ var _ = Describe("Unregister", func() {
 				},
 			}
 
-			Expect(command.UnregisterLogFormat(registrationFetcher, cliConnection, "app-name", "")).ToNot(Succeed())
+			Expect(command.UnregisterLogFormat(newSpyWriter(), registrationFetcher, cliConnection, "app-name", "", command.UnregisterOptions{})).ToNot(Succeed())
 		})
 
 		It("returns an error if registration fetcher returns an error", func() {
@@ -163,13 +160,14 @@ var _ = Describe("Unregister", func() {
 			registrationFetcher := newMockRegistrationFetcher()
 			registrationFetcher.fetchError = errors.New("expected")
 
-			Expect(command.UnregisterLogFormat(registrationFetcher, cliConnection, "app-name", "")).ToNot(Succeed())
+			Expect(command.UnregisterLogFormat(newSpyWriter(), registrationFetcher, cliConnection, "app-name", "", command.UnregisterOptions{})).ToNot(Succeed())
 		})
 	})
 
 	Context("UnregisterMetricsEndpoint", func() {
 		It("unbinds app from all metrics endpoints", func() {
 			cliConnection := newMockCliConnection()
+			portManager := newMockPortManager()
 			registrationFetcher := newMockRegistrationFetcher()
 			registrationFetcher.registrations["app-guid"] = []registrations.Registration{
 				{
@@ -186,7 +184,7 @@ var _ = Describe("Unregister", func() {
 				},
 			}
 
-			err := command.UnregisterMetricsEndpoint(registrationFetcher, cliConnection, "app-name", "", "")
+			err := command.UnregisterMetricsEndpoint(newSpyWriter(), registrationFetcher, cliConnection, portManager, "app-name", "", "", command.UnregisterOptions{})
 			Expect(err).ToNot(HaveOccurred())
 
 			Eventually(cliConnection.cliCommandsCalled).Should(Receive(ConsistOf(
@@ -204,7 +202,9 @@ var _ = Describe("Unregister", func() {
 
 		It("removes exposed ports", func() {
 			cliConnection := newMockCliConnection()
-			cliConnection.exposedPorts = []int{1234, 2112}
+			portManager := newMockPortManager()
+			portManager.exposedPorts = []int{1234, 2112, 9090}
+			portManager.openedPorts = []int{2112, 9090}
 
 			registrationFetcher := newMockRegistrationFetcher()
 			registrationFetcher.registrations["app-guid"] = []registrations.Registration{
@@ -215,13 +215,114 @@ var _ = Describe("Unregister", func() {
 					NumberOfBindings: 1,
 				},
 			}
-			err := command.UnregisterMetricsEndpoint(registrationFetcher, cliConnection, "app-name", "", "")
+			err := command.UnregisterMetricsEndpoint(newSpyWriter(), registrationFetcher, cliConnection, portManager, "app-name", "", "", command.UnregisterOptions{})
 			Expect(err).ToNot(HaveOccurred())
-			expectToReceivePutCurlForAppAndPort(cliConnection.cliCommandsCalled, "app-guid", []string{"1234"})
+			Expect(portManager.setPortsCalled).To(Receive(Equal([]int{1234, 9090})))
+			Expect(portManager.setOpenedPortsCalled).To(Receive(Equal([]int{9090})))
+		})
+
+		It("keeps the order of the remaining ports", func() {
+			cliConnection := newMockCliConnection()
+			portManager := newMockPortManager()
+			portManager.exposedPorts = []int{9090, 1234, 2112, 8080}
+			portManager.openedPorts = []int{2112}
+
+			registrationFetcher := newMockRegistrationFetcher()
+			registrationFetcher.registrations["app-guid"] = []registrations.Registration{
+				{Name: "service1", Type: "secure-endpoint", Config: ":2112/metrics", NumberOfBindings: 1},
+			}
+			err := command.UnregisterMetricsEndpoint(newSpyWriter(), registrationFetcher, cliConnection, portManager, "app-name", "", "", command.UnregisterOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(portManager.setPortsCalled).To(Receive(Equal([]int{9090, 1234, 8080})))
+			Expect(portManager.setPortsCalled).ToNot(Receive())
+		})
+
+		It("leaves ports the plugin didn't open exposed", func() {
+			cliConnection := newMockCliConnection()
+			portManager := newMockPortManager()
+			portManager.exposedPorts = []int{1234, 2112}
+
+			registrationFetcher := newMockRegistrationFetcher()
+			registrationFetcher.registrations["app-guid"] = []registrations.Registration{
+				{
+					Name:             "service1",
+					Type:             "secure-endpoint",
+					Config:           ":2112/metrics",
+					NumberOfBindings: 1,
+				},
+			}
+			err := command.UnregisterMetricsEndpoint(newSpyWriter(), registrationFetcher, cliConnection, portManager, "app-name", "", "", command.UnregisterOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cliConnection.cliCommandsCalled).To(Receive(ContainElement("unbind-service")))
+			Expect(portManager.setPortsCalled).ToNot(Receive())
+			Expect(portManager.setOpenedPortsCalled).ToNot(Receive())
+		})
+
+		It("closes the ports of removed endpoints where the Cloud Controller can't record opened ports", func() {
+			cliConnection := newMockCliConnection()
+			portManager := newMockPortManager()
+			portManager.exposedPorts = []int{8080, 2112}
+			portManager.getOpenedPortsError = command.ErrPortOwnershipUnavailable
+			portManager.setOpenedPortsError = command.ErrPortOwnershipUnavailable
+
+			registrationFetcher := newMockRegistrationFetcher()
+			registrationFetcher.registrations["app-guid"] = []registrations.Registration{
+				{Name: "service1", Type: "secure-endpoint", Config: ":2112/metrics", NumberOfBindings: 1},
+			}
+			err := command.UnregisterMetricsEndpoint(newSpyWriter(), registrationFetcher, cliConnection, portManager, "app-name", "", "", command.UnregisterOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(portManager.setPortsCalled).To(Receive(Equal([]int{8080})))
+			Expect(portManager.setOpenedPortsCalled).ToNot(Receive())
+		})
+
+		It("doesn't read ports when no removed endpoint has one", func() {
+			cliConnection := newMockCliConnection()
+			portManager := newMockPortManager()
+			portManager.getPortsError = errors.New("unexpected")
+			portManager.getOpenedPortsError = errors.New("unexpected")
+
+			registrationFetcher := newMockRegistrationFetcher()
+			registrationFetcher.registrations["app-guid"] = []registrations.Registration{
+				{Name: "service1", Type: "metrics-endpoint", Config: "app-host.app-domain/app-path/metrics", NumberOfBindings: 1},
+			}
+			err := command.UnregisterMetricsEndpoint(newSpyWriter(), registrationFetcher, cliConnection, portManager, "app-name", "", "", command.UnregisterOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cliConnection.cliCommandsCalled).To(Receive(Equal([]string{"unbind-service", "app-name", "service1"})))
+			Expect(cliConnection.cliCommandsCalled).To(Receive(Equal([]string{"delete-service", "service1", "-f"})))
+		})
+
+		It("returns an error if reading the opened ports fails", func() {
+			cliConnection := newMockCliConnection()
+			portManager := newMockPortManager()
+			portManager.getOpenedPortsError = errors.New("expected")
+			registrationFetcher := newMockRegistrationFetcher()
+			registrationFetcher.registrations["app-guid"] = []registrations.Registration{
+				{Name: "service1", Type: "secure-endpoint", Config: ":2112/metrics", NumberOfBindings: 1},
+			}
+
+			err := command.UnregisterMetricsEndpoint(newSpyWriter(), registrationFetcher, cliConnection, portManager, "app-name", "", "", command.UnregisterOptions{})
+			Expect(err).To(MatchError("expected"))
+			Expect(cliConnection.cliCommandsCalled).ToNot(Receive())
+		})
+
+		It("returns an error if recording the closed ports fails", func() {
+			cliConnection := newMockCliConnection()
+			portManager := newMockPortManager()
+			portManager.exposedPorts = []int{2112}
+			portManager.openedPorts = []int{2112}
+			portManager.setOpenedPortsError = errors.New("expected")
+			registrationFetcher := newMockRegistrationFetcher()
+			registrationFetcher.registrations["app-guid"] = []registrations.Registration{
+				{Name: "service1", Type: "secure-endpoint", Config: ":2112/metrics", NumberOfBindings: 1},
+			}
+
+			err := command.UnregisterMetricsEndpoint(newSpyWriter(), registrationFetcher, cliConnection, portManager, "app-name", "", "", command.UnregisterOptions{})
+			Expect(err).To(MatchError("expected"))
 		})
 
 		It("deletes service if no more apps bound", func() {
 			cliConnection := newMockCliConnection()
+			portManager := newMockPortManager()
 			registrationFetcher := newMockRegistrationFetcher()
 			registrationFetcher.registrations["app-guid"] = []registrations.Registration{
 				{
@@ -232,7 +333,7 @@ var _ = Describe("Unregister", func() {
 				},
 			}
 
-			err := command.UnregisterMetricsEndpoint(registrationFetcher, cliConnection, "app-name", "", "")
+			err := command.UnregisterMetricsEndpoint(newSpyWriter(), registrationFetcher, cliConnection, portManager, "app-name", "", "", command.UnregisterOptions{})
 			Expect(err).ToNot(HaveOccurred())
 
 			Expect(cliConnection.cliCommandsCalled).To(Receive(ConsistOf(
@@ -250,7 +351,9 @@ var _ = Describe("Unregister", func() {
 
 		It("only unbinds specified service if path and port are set", func() {
 			cliConnection := newMockCliConnection()
-			cliConnection.exposedPorts = []int{8080, 9090}
+			portManager := newMockPortManager()
+			portManager.exposedPorts = []int{8080, 9090}
+			portManager.openedPorts = []int{9090}
 			registrationFetcher := newMockRegistrationFetcher()
 			registrationFetcher.registrations["app-guid"] = []registrations.Registration{
 				{
@@ -268,11 +371,14 @@ var _ = Describe("Unregister", func() {
 			}
 
 			err := command.UnregisterMetricsEndpoint(
+				newSpyWriter(),
 				registrationFetcher,
 				cliConnection,
+				portManager,
 				"app-name",
 				"/metrics",
 				"9090",
+				command.UnregisterOptions{},
 			)
 			Expect(err).ToNot(HaveOccurred())
 
@@ -287,12 +393,15 @@ var _ = Describe("Unregister", func() {
 				"app-name",
 				"service2",
 			)))
-			expectToReceivePutCurlForAppAndPort(cliConnection.cliCommandsCalled, "app-guid", []string{"8080", "9090"})
+			// another registration still uses the port
+			Expect(portManager.setPortsCalled).ToNot(Receive())
 		})
 
 		It("only unbinds specified service if path is set", func() {
 			cliConnection := newMockCliConnection()
-			cliConnection.exposedPorts = []int{8080, 9090}
+			portManager := newMockPortManager()
+			portManager.exposedPorts = []int{8080, 9090}
+			portManager.openedPorts = []int{9090}
 			registrationFetcher := newMockRegistrationFetcher()
 			registrationFetcher.registrations["app-guid"] = []registrations.Registration{
 				{
@@ -310,11 +419,14 @@ var _ = Describe("Unregister", func() {
 			}
 
 			err := command.UnregisterMetricsEndpoint(
+				newSpyWriter(),
 				registrationFetcher,
 				cliConnection,
+				portManager,
 				"app-name",
 				":9090/metrics",
 				"",
+				command.UnregisterOptions{},
 			)
 			Expect(err).ToNot(HaveOccurred())
 
@@ -330,15 +442,17 @@ var _ = Describe("Unregister", func() {
 				"service2",
 			)))
 
-			expectToReceivePutCurlForAppAndPort(cliConnection.cliCommandsCalled, "app-guid", []string{"8080", "9090"})
+			// another registration still uses the port
+			Expect(portManager.setPortsCalled).ToNot(Receive())
 		})
 
 		It("doesn't unbind services if registration fetcher doesn't find any", func() {
 			cliConnection := newMockCliConnection()
+			portManager := newMockPortManager()
 			registrationFetcher := newMockRegistrationFetcher()
 			registrationFetcher.registrations["app-guid"] = nil
 
-			err := command.UnregisterMetricsEndpoint(registrationFetcher, cliConnection, "app-name", "", "")
+			err := command.UnregisterMetricsEndpoint(newSpyWriter(), registrationFetcher, cliConnection, portManager, "app-name", "", "", command.UnregisterOptions{})
 			Expect(err).ToNot(HaveOccurred())
 
 			Expect(cliConnection.cliCommandsCalled).ShouldNot(Receive(ContainElement("unbind-service")))
@@ -346,14 +460,16 @@ var _ = Describe("Unregister", func() {
 
 		It("returns error if getting app info fails", func() {
 			cliConnection := newMockCliConnection()
+			portManager := newMockPortManager()
 			cliConnection.getAppError = errors.New("expected")
 			registrationFetcher := newMockRegistrationFetcher()
 
-			Expect(command.UnregisterMetricsEndpoint(registrationFetcher, cliConnection, "app-name", "", "")).ToNot(Succeed())
+			Expect(command.UnregisterMetricsEndpoint(newSpyWriter(), registrationFetcher, cliConnection, portManager, "app-name", "", "", command.UnregisterOptions{})).ToNot(Succeed())
 		})
 
 		It("returns error if unbinding service fails", func() {
 			cliConnection := newMockCliConnection()
+			portManager := newMockPortManager()
 			cliConnection.cliErrorCommand = "unbind-service"
 			registrationFetcher := newMockRegistrationFetcher()
 			registrationFetcher.registrations["app-guid"] = []registrations.Registration{
@@ -365,11 +481,12 @@ var _ = Describe("Unregister", func() {
 				},
 			}
 
-			Expect(command.UnregisterMetricsEndpoint(registrationFetcher, cliConnection, "app-name", "", "")).ToNot(Succeed())
+			Expect(command.UnregisterMetricsEndpoint(newSpyWriter(), registrationFetcher, cliConnection, portManager, "app-name", "", "", command.UnregisterOptions{})).ToNot(Succeed())
 		})
 
 		It("returns error if deleting service fails", func() {
 			cliConnection := newMockCliConnection()
+			portManager := newMockPortManager()
 			cliConnection.cliErrorCommand = "delete-service"
 			registrationFetcher := newMockRegistrationFetcher()
 			registrationFetcher.registrations["app-guid"] = []registrations.Registration{
@@ -381,42 +498,132 @@ var _ = Describe("Unregister", func() {
 				},
 			}
 
-			Expect(command.UnregisterMetricsEndpoint(registrationFetcher, cliConnection, "app-name", "", "")).ToNot(Succeed())
+			Expect(command.UnregisterMetricsEndpoint(newSpyWriter(), registrationFetcher, cliConnection, portManager, "app-name", "", "", command.UnregisterOptions{})).ToNot(Succeed())
 		})
 
 		It("returns an error if registration fetcher returns an error", func() {
 			cliConnection := newMockCliConnection()
+			portManager := newMockPortManager()
 			registrationFetcher := newMockRegistrationFetcher()
 			registrationFetcher.fetchError = errors.New("expected")
 
-			Expect(command.UnregisterMetricsEndpoint(registrationFetcher, cliConnection, "app-name", "", "")).ToNot(Succeed())
+			Expect(command.UnregisterMetricsEndpoint(newSpyWriter(), registrationFetcher, cliConnection, portManager, "app-name", "", "", command.UnregisterOptions{})).ToNot(Succeed())
 		})
 
 		It("returns an error if unregistering the port returns an error", func() {
 			cliConnection := newMockCliConnection()
+			portManager := newMockPortManager()
 			registrationFetcher := newMockRegistrationFetcher()
-			cliConnection.getAppsInfoError = errors.New("cf doesn't want to speak to you rn")
+			registrationFetcher.registrations["app-guid"] = []registrations.Registration{
+				{Name: "service1", Type: "secure-endpoint", Config: ":2112/metrics", NumberOfBindings: 1},
+			}
+			portManager.getPortsError = errors.New("cf doesn't want to speak to you rn")
 
-			Expect(command.UnregisterMetricsEndpoint(registrationFetcher, cliConnection, "app-name", "2112", "")).ToNot(Succeed())
+			Expect(command.UnregisterMetricsEndpoint(newSpyWriter(), registrationFetcher, cliConnection, portManager, "app-name", "/metrics", "2112", command.UnregisterOptions{})).ToNot(Succeed())
+		})
+
+		It("doesn't unbind or change ports if reading ports fails", func() {
+			cliConnection := newMockCliConnection()
+			portManager := newMockPortManager()
+			portManager.getPortsError = errors.New("expected")
+			registrationFetcher := newMockRegistrationFetcher()
+			registrationFetcher.registrations["app-guid"] = []registrations.Registration{
+				{
+					Name:             "service1",
+					Type:             "secure-endpoint",
+					Config:           ":2112/metrics",
+					NumberOfBindings: 1,
+				},
+			}
+
+			Expect(command.UnregisterMetricsEndpoint(newSpyWriter(), registrationFetcher, cliConnection, portManager, "app-name", "", "", command.UnregisterOptions{})).ToNot(Succeed())
+			Expect(cliConnection.cliCommandsCalled).ToNot(Receive())
+			Expect(portManager.setPortsCalled).ToNot(Receive())
+		})
+
+		Context("when continuing on errors", func() {
+			var (
+				cliConnection       *mockCliConnection
+				portManager         *mockPortManager
+				registrationFetcher *mockRegistrationFetcher
+				writer              *spyWriter
+				opts                = command.UnregisterOptions{ContinueOnError: true}
+			)
+
+			BeforeEach(func() {
+				cliConnection = newMockCliConnection()
+				portManager = newMockPortManager()
+				portManager.exposedPorts = []int{8080, 2112, 9090}
+				portManager.openedPorts = []int{2112, 9090}
+				registrationFetcher = newMockRegistrationFetcher()
+				registrationFetcher.registrations["app-guid"] = []registrations.Registration{
+					{Name: "secure-endpoint-2112-metrics", Type: "secure-endpoint", Config: ":2112/metrics", NumberOfBindings: 1},
+					{Name: "secure-endpoint-9090-metrics", Type: "secure-endpoint", Config: ":9090/metrics", NumberOfBindings: 1},
+				}
+				writer = newSpyWriter()
+			})
+
+			It("removes the ports of services it unbound even if deleting them fails", func() {
+				cliConnection.cliErrorCommand = "delete-service"
+
+				err := command.UnregisterMetricsEndpoint(writer, registrationFetcher, cliConnection, portManager, "app-name", "", "", opts)
+				Expect(err).To(MatchError("2 of 6 operations failed"))
+
+				Expect(cliConnection.cliCommandsCalled).To(Receive(Equal([]string{"unbind-service", "app-name", "secure-endpoint-2112-metrics"})))
+				Expect(cliConnection.cliCommandsCalled).To(Receive(Equal([]string{"delete-service", "secure-endpoint-2112-metrics", "-f"})))
+				Expect(cliConnection.cliCommandsCalled).To(Receive(Equal([]string{"unbind-service", "app-name", "secure-endpoint-9090-metrics"})))
+				Expect(cliConnection.cliCommandsCalled).To(Receive(Equal([]string{"delete-service", "secure-endpoint-9090-metrics", "-f"})))
+				Expect(portManager.setPortsCalled).To(Receive(Equal([]int{8080})))
+				Expect(portManager.setOpenedPortsCalled).To(Receive(BeEmpty()))
+
+				Expect(writer.lines()).To(Equal([]string{
+					"Operation            Target                        Result",
+					"unbind-service       secure-endpoint-2112-metrics  ok",
+					"delete-service       secure-endpoint-2112-metrics  failed: error",
+					"unbind-service       secure-endpoint-9090-metrics  ok",
+					"delete-service       secure-endpoint-9090-metrics  failed: error",
+					"set ports            app-name                      ok",
+					"record opened ports  app-name                      ok",
+					"",
+				}))
+			})
+
+			It("keeps the ports of services it couldn't unbind", func() {
+				cliConnection.cliErrorCommand = "unbind-service"
+
+				err := command.UnregisterMetricsEndpoint(writer, registrationFetcher, cliConnection, portManager, "app-name", "", "", opts)
+				Expect(err).To(MatchError("2 of 2 operations failed"))
+
+				Expect(portManager.setPortsCalled).ToNot(Receive())
+			})
+
+			It("reports a failure to set ports", func() {
+				portManager.setPortsError = errors.New("expected")
+
+				err := command.UnregisterMetricsEndpoint(writer, registrationFetcher, cliConnection, portManager, "app-name", "", "", opts)
+				Expect(err).To(MatchError("1 of 5 operations failed"))
+				Expect(writer.lines()).To(ContainElement("set ports       app-name                      failed: expected"))
+				Expect(portManager.setOpenedPortsCalled).ToNot(Receive())
+			})
+
+			It("prints a summary when everything succeeds", func() {
+				err := command.UnregisterMetricsEndpoint(writer, registrationFetcher, cliConnection, portManager, "app-name", "", "", opts)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(writer.lines()).To(HaveLen(8))
+			})
+
+			It("tries every log format", func() {
+				registrationFetcher.registrations["app-guid"] = []registrations.Registration{
+					{Name: "structured-format-json", Type: "structured-format", Config: "json", NumberOfBindings: 2},
+					{Name: "structured-format-dogstatsd", Type: "structured-format", Config: "DogStatsD", NumberOfBindings: 2},
+				}
+				cliConnection.cliErrorCommand = "unbind-service"
+
+				err := command.UnregisterLogFormat(writer, registrationFetcher, cliConnection, "app-name", "", opts)
+				Expect(err).To(MatchError("2 of 2 operations failed"))
+				Expect(cliConnection.cliCommandsCalled).To(Receive(Equal([]string{"unbind-service", "app-name", "structured-format-json"})))
+				Expect(cliConnection.cliCommandsCalled).To(Receive(Equal([]string{"unbind-service", "app-name", "structured-format-dogstatsd"})))
+			})
 		})
 	})
 })
-
-func expectToReceivePutCurlForAppAndPort(called chan []string, appGuid string, ports []string) {
-	Eventually(called).Should(Receive(And(
-		matchCurl(
-			fmt.Sprintf("/v2/apps/%s", appGuid),
-			"-X",
-			"PUT",
-			"-d",
-		), ContainElement(
-			WithTransform(removeSingleQuotes, MatchUnorderedJSON(
-				fmt.Sprintf("{\"ports\":[%s]}", strings.Join(ports, ",")),
-			)),
-		),
-	)))
-}
-
-func removeSingleQuotes(s string) interface{} {
-	return strings.ReplaceAll(s, "'", "")
-}
@@ -0,0 +1,179 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/pivotal-cf/metric-registrar-cli/registrations"
+)
+
+// UpdateEndpointOptions pick the endpoint to update by its current path
+// and/or port and give its new ones. A new value that isn't given keeps the
+// current one. An endpoint without a port is insecure.
+type UpdateEndpointOptions struct {
+	FromPath string
+	FromPort string
+	ToPath   string
+	ToPort   string
+}
+
+// UpdateMetricsEndpoint moves an app's metrics endpoint to another path or
+// port. The new endpoint is registered before the old one is removed so that
+// the app is scraped throughout, and the old port is only closed once nothing
+// scrapes it.
+func UpdateMetricsEndpoint(writer io.Writer, fetcher registrationFetcher, cliConn cliCommandRunner, portManager portManager, appName string, opts UpdateEndpointOptions) error {
+	if opts.FromPath == "" && opts.FromPort == "" {
+		return fmt.Errorf("need to pass --from-path or --from-port")
+	}
+	if opts.ToPath == "" && opts.ToPort == "" {
+		return fmt.Errorf("need to pass --to-path or --to-port")
+	}
+
+	app, err := cliConn.GetApp(appName)
+	if err != nil {
+		return err
+	}
+
+	existing, err := getAllMetricsRegistrations(fetcher, app.Guid)
+	if err != nil {
+		return err
+	}
+
+	old, err := findEndpointToUpdate(existing, appName, opts)
+	if err != nil {
+		return err
+	}
+	oldPort, oldPath := parseEndpointConfig(old.Config)
+
+	newPort := oldPort
+	if opts.ToPort != "" {
+		newPort, err = strconv.Atoi(opts.ToPort)
+		if err != nil {
+			return fmt.Errorf("invalid --to-port %q", opts.ToPort)
+		}
+	}
+	newPath := oldPath
+	if opts.ToPath != "" {
+		newPath = opts.ToPath
+	} else if old.Type == metricsEndpoint && newPort != 0 {
+		// an insecure endpoint can name one of the app's routes, a secure
+		// one only has the path on that route
+		if old.Config == "" {
+			return fmt.Errorf("can't parse the endpoint of %s, pass --to-path", old.Name)
+		}
+		route, err := validateRouteForApp(old.Config, app, false)
+		if err != nil {
+			return err
+		}
+		newPath = route.Path
+		if newPath == "" {
+			newPath = "/"
+		}
+	}
+
+	secure := newPort != 0
+	validRoute, err := validateRouteForApp(newPath, app, secure)
+	if err != nil {
+		return err
+	}
+
+	serviceProtocol, config := metricsEndpoint, newPath
+	if secure {
+		serviceProtocol, config = secureEndpoint, ":"+strconv.Itoa(newPort)+validRoute.Path
+	}
+	if serviceProtocol == old.Type && config == old.Config {
+		return fmt.Errorf("%s is already registered for %s://%s", appName, serviceProtocol, config)
+	}
+
+	undo := &rollback{}
+	if secure {
+		exposed, err := exposePortForApp(portManager, app.Guid, newPort)
+		if exposed {
+			undo.add(fmt.Sprintf("remove port %d from %s", newPort, appName), func() error {
+				return unexposePortForApp(portManager, app.Guid, newPort)
+			})
+		}
+		if err != nil {
+			return undo.run(writer, appName, err)
+		}
+	}
+
+	serviceName, err := findRegistration(fetcher, app.Guid, serviceProtocol, config)
+	if err != nil {
+		return undo.run(writer, appName, err)
+	}
+	if serviceName == "" {
+		_, err = ensureServiceAndBind(cliConn, fetcher, undo, appName, serviceProtocol, config)
+		if err != nil {
+			return undo.run(writer, appName, err)
+		}
+	}
+
+	// the new endpoint is registered, so failures from here on leave both
+	// endpoints scraped rather than rolling back
+	_, err = removeRegistration(fetcher, appName, old, cliConn, &unregisterReport{})
+	if err != nil {
+		return err
+	}
+
+	if oldPort != 0 && oldPort != newPort && !portStillScraped(existing, old, oldPort) {
+		err = closeOpenedPort(portManager, app.Guid, oldPort)
+		if err != nil {
+			return err
+		}
+	}
+
+	summary := "Updated %s from %s://%s to %s://%s\n"
+	if isDryRun(cliConn) {
+		summary = "Would update %s from %s://%s to %s://%s\n"
+	}
+	_, err = fmt.Fprintf(writer, summary, appName, old.Type, old.Config, serviceProtocol, config)
+	return err
+}
+
+// findEndpointToUpdate returns the one metrics endpoint matching the given
+// path and port.
+func findEndpointToUpdate(existing []registrations.Registration, appName string, opts UpdateEndpointOptions) (registrations.Registration, error) {
+	var matches []registrations.Registration
+	for _, r := range existing {
+		port, path := parseEndpointConfig(r.Config)
+		if opts.FromPort != "" && opts.FromPort != strconv.Itoa(port) {
+			continue
+		}
+		if opts.FromPath != "" && opts.FromPath != path {
+			continue
+		}
+		matches = append(matches, r)
+	}
+
+	switch len(matches) {
+	case 0:
+		return registrations.Registration{}, fmt.Errorf("no metrics endpoint of %s matches --from-path %q and --from-port %q", appName, opts.FromPath, opts.FromPort)
+	case 1:
+		return matches[0], nil
+	}
+	return registrations.Registration{}, fmt.Errorf("%d metrics endpoints of %s match, pass both --from-path and --from-port", len(matches), appName)
+}
+
+func portStillScraped(existing []registrations.Registration, removed registrations.Registration, port int) bool {
+	for _, r := range existing {
+		if r.Name != removed.Name && getPortFromConfig(r.Config) == port {
+			return true
+		}
+	}
+	return false
+}
+
+// closeOpenedPort closes port if the plugin opened it.
+func closeOpenedPort(portManager portManager, guid string, port int) error {
+	openedPorts, err := openedPortsForApp(portManager, guid, []int{port})
+	if err != nil {
+		return err
+	}
+	if !containsPort(openedPorts, port) {
+		return nil
+	}
+
+	return unexposePortForApp(portManager, guid, port)
+}
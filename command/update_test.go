@@ -0,0 +1,208 @@
+package command_test
+
+import (
+	"errors"
+
+	"github.com/pivotal-cf/metric-registrar-cli/command"
+	"github.com/pivotal-cf/metric-registrar-cli/registrations"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("UpdateMetricsEndpoint", func() {
+	var (
+		writer              *spyWriter
+		registrationFetcher *mockRegistrationFetcher
+		portManager         *mockPortManager
+		cliConn             *mockCliConnection
+	)
+
+	BeforeEach(func() {
+		writer = newSpyWriter()
+		registrationFetcher = newMockRegistrationFetcher()
+		registrationFetcher.registrations["app-guid"] = []registrations.Registration{
+			{Name: "old-endpoint", Type: "secure-endpoint", Config: ":2112/metrics", NumberOfBindings: 1},
+		}
+		portManager = newMockPortManager()
+		portManager.exposedPorts = []int{8080, 2112}
+		portManager.openedPorts = []int{2112}
+		cliConn = newMockCliConnection()
+	})
+
+	It("registers the new port before removing the old one", func() {
+		err := command.UpdateMetricsEndpoint(writer, registrationFetcher, cliConn, portManager, "app-name", command.UpdateEndpointOptions{
+			FromPort: "2112",
+			ToPort:   "9090",
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(portManager.setPortsCalled).To(Receive(Equal([]int{8080, 2112, 9090})))
+		Expect(cliConn.cliCommandsCalled).To(Receive(Equal([]string{"create-user-provided-service", "secure-endpoint-9090-metrics-c2c2c8882dc35988", "-l", "secure-endpoint://:9090/metrics"})))
+		Expect(cliConn.cliCommandsCalled).To(Receive(Equal([]string{"bind-service", "app-name", "secure-endpoint-9090-metrics-c2c2c8882dc35988"})))
+		Expect(cliConn.cliCommandsCalled).To(Receive(Equal([]string{"unbind-service", "app-name", "old-endpoint"})))
+		Expect(cliConn.cliCommandsCalled).To(Receive(Equal([]string{"delete-service", "old-endpoint", "-f"})))
+		Expect(portManager.setPortsCalled).To(Receive(Equal([]int{8080, 9090})))
+		Expect(portManager.openedPorts).To(Equal([]int{9090}))
+		Expect(writer.lines()).To(Equal([]string{
+			"Updated app-name from secure-endpoint://:2112/metrics to secure-endpoint://:9090/metrics",
+			"",
+		}))
+	})
+
+	It("keeps the port when only the path changes", func() {
+		err := command.UpdateMetricsEndpoint(writer, registrationFetcher, cliConn, portManager, "app-name", command.UpdateEndpointOptions{
+			FromPath: "/metrics",
+			ToPath:   "/v2/metrics",
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(cliConn.cliCommandsCalled).To(Receive(Equal([]string{"create-user-provided-service", "secure-endpoint-2112-v2-metrics-9ba401ff174c8680", "-l", "secure-endpoint://:2112/v2/metrics"})))
+		Expect(cliConn.cliCommandsCalled).To(Receive(Equal([]string{"bind-service", "app-name", "secure-endpoint-2112-v2-metrics-9ba401ff174c8680"})))
+		Expect(cliConn.cliCommandsCalled).To(Receive(Equal([]string{"unbind-service", "app-name", "old-endpoint"})))
+		Expect(portManager.setPortsCalled).ToNot(Receive())
+		Expect(portManager.openedPorts).To(Equal([]int{2112}))
+	})
+
+	It("moves an insecure endpoint to a secure one", func() {
+		registrationFetcher.registrations["app-guid"] = []registrations.Registration{
+			{Name: "old-endpoint", Type: "metrics-endpoint", Config: "/metrics", NumberOfBindings: 2},
+		}
+		portManager.exposedPorts = []int{8080}
+		portManager.openedPorts = nil
+
+		err := command.UpdateMetricsEndpoint(writer, registrationFetcher, cliConn, portManager, "app-name", command.UpdateEndpointOptions{
+			FromPath: "/metrics",
+			ToPort:   "2112",
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(portManager.setPortsCalled).To(Receive(Equal([]int{8080, 2112})))
+		Expect(cliConn.cliCommandsCalled).To(Receive(Equal([]string{"create-user-provided-service", "secure-endpoint-2112-metrics-fdae1312e3715d76", "-l", "secure-endpoint://:2112/metrics"})))
+		Expect(cliConn.cliCommandsCalled).To(Receive(Equal([]string{"bind-service", "app-name", "secure-endpoint-2112-metrics-fdae1312e3715d76"})))
+		Expect(cliConn.cliCommandsCalled).To(Receive(Equal([]string{"unbind-service", "app-name", "old-endpoint"})))
+		Expect(cliConn.cliCommandsCalled).ToNot(Receive())
+		Expect(portManager.setPortsCalled).ToNot(Receive())
+	})
+
+	It("keeps the path on the route when moving a route-based insecure endpoint to a secure one", func() {
+		registrationFetcher.registrations["app-guid"] = []registrations.Registration{
+			{Name: "old-endpoint", Type: "metrics-endpoint", Config: "app-host.app-domain/app-path/metrics", NumberOfBindings: 1},
+		}
+		portManager.exposedPorts = []int{8080}
+		portManager.openedPorts = nil
+
+		err := command.UpdateMetricsEndpoint(writer, registrationFetcher, cliConn, portManager, "app-name", command.UpdateEndpointOptions{
+			FromPath: "app-host.app-domain/app-path/metrics",
+			ToPort:   "2112",
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(portManager.setPortsCalled).To(Receive(Equal([]int{8080, 2112})))
+		Expect(cliConn.cliCommandsCalled).To(Receive(Equal([]string{"create-user-provided-service", "secure-endpoint-2112-app-path-metrics-167665f106aa68b1", "-l", "secure-endpoint://:2112/app-path/metrics"})))
+		Expect(cliConn.cliCommandsCalled).To(Receive(Equal([]string{"bind-service", "app-name", "secure-endpoint-2112-app-path-metrics-167665f106aa68b1"})))
+		Expect(cliConn.cliCommandsCalled).To(Receive(Equal([]string{"unbind-service", "app-name", "old-endpoint"})))
+		Expect(cliConn.cliCommandsCalled).To(Receive(Equal([]string{"delete-service", "old-endpoint", "-f"})))
+		Expect(writer.lines()).To(Equal([]string{
+			"Updated app-name from metrics-endpoint://app-host.app-domain/app-path/metrics to secure-endpoint://:2112/app-path/metrics",
+			"",
+		}))
+	})
+
+	It("leaves the old port open if the plugin didn't open it", func() {
+		portManager.openedPorts = nil
+
+		err := command.UpdateMetricsEndpoint(writer, registrationFetcher, cliConn, portManager, "app-name", command.UpdateEndpointOptions{
+			FromPort: "2112",
+			ToPort:   "9090",
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(portManager.setPortsCalled).To(Receive(Equal([]int{8080, 2112, 9090})))
+		Expect(portManager.setPortsCalled).ToNot(Receive())
+	})
+
+	It("leaves the old port open while another endpoint uses it", func() {
+		registrationFetcher.registrations["app-guid"] = append(registrationFetcher.registrations["app-guid"],
+			registrations.Registration{Name: "other-endpoint", Type: "secure-endpoint", Config: ":2112/other", NumberOfBindings: 1},
+		)
+
+		err := command.UpdateMetricsEndpoint(writer, registrationFetcher, cliConn, portManager, "app-name", command.UpdateEndpointOptions{
+			FromPort: "2112",
+			FromPath: "/metrics",
+			ToPort:   "9090",
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(portManager.setPortsCalled).To(Receive(Equal([]int{8080, 2112, 9090})))
+		Expect(portManager.setPortsCalled).ToNot(Receive())
+	})
+
+	It("rolls back the new endpoint and keeps the old one if binding fails", func() {
+		cliConn.cliErrorCommand = "bind-service"
+
+		err := command.UpdateMetricsEndpoint(writer, registrationFetcher, cliConn, portManager, "app-name", command.UpdateEndpointOptions{
+			FromPort: "2112",
+			ToPort:   "9090",
+		})
+		Expect(err).To(MatchError("error"))
+
+		Expect(cliConn.cliCommandsCalled).To(Receive(Equal([]string{"create-user-provided-service", "secure-endpoint-9090-metrics-c2c2c8882dc35988", "-l", "secure-endpoint://:9090/metrics"})))
+		Expect(cliConn.cliCommandsCalled).To(Receive(Equal([]string{"bind-service", "app-name", "secure-endpoint-9090-metrics-c2c2c8882dc35988"})))
+		Expect(cliConn.cliCommandsCalled).To(Receive(Equal([]string{"delete-service", "secure-endpoint-9090-metrics-c2c2c8882dc35988", "-f"})))
+		Expect(cliConn.cliCommandsCalled).ToNot(Receive())
+		Expect(portManager.exposedPorts).To(Equal([]int{8080, 2112}))
+		Expect(portManager.openedPorts).To(Equal([]int{2112}))
+	})
+
+	It("returns an error if no endpoint matches", func() {
+		err := command.UpdateMetricsEndpoint(writer, registrationFetcher, cliConn, portManager, "app-name", command.UpdateEndpointOptions{
+			FromPort: "9090",
+			ToPort:   "2112",
+		})
+		Expect(err).To(MatchError(`no metrics endpoint of app-name matches --from-path "" and --from-port "9090"`))
+		Expect(cliConn.cliCommandsCalled).ToNot(Receive())
+	})
+
+	It("returns an error if more than one endpoint matches", func() {
+		registrationFetcher.registrations["app-guid"] = append(registrationFetcher.registrations["app-guid"],
+			registrations.Registration{Name: "other-endpoint", Type: "secure-endpoint", Config: ":2112/other", NumberOfBindings: 1},
+		)
+
+		err := command.UpdateMetricsEndpoint(writer, registrationFetcher, cliConn, portManager, "app-name", command.UpdateEndpointOptions{
+			FromPort: "2112",
+			ToPort:   "9090",
+		})
+		Expect(err).To(MatchError("2 metrics endpoints of app-name match, pass both --from-path and --from-port"))
+	})
+
+	It("returns an error if nothing would change", func() {
+		err := command.UpdateMetricsEndpoint(writer, registrationFetcher, cliConn, portManager, "app-name", command.UpdateEndpointOptions{
+			FromPort: "2112",
+			ToPath:   "/metrics",
+		})
+		Expect(err).To(MatchError("app-name is already registered for secure-endpoint://:2112/metrics"))
+		Expect(cliConn.cliCommandsCalled).ToNot(Receive())
+	})
+
+	It("requires the endpoint to update and a change", func() {
+		Expect(command.UpdateMetricsEndpoint(writer, registrationFetcher, cliConn, portManager, "app-name", command.UpdateEndpointOptions{
+			ToPort: "9090",
+		})).To(MatchError("need to pass --from-path or --from-port"))
+
+		Expect(command.UpdateMetricsEndpoint(writer, registrationFetcher, cliConn, portManager, "app-name", command.UpdateEndpointOptions{
+			FromPort: "2112",
+		})).To(MatchError("need to pass --to-path or --to-port"))
+	})
+
+	It("returns an error if getting the registrations fails", func() {
+		registrationFetcher.fetchError = errors.New("expected")
+
+		err := command.UpdateMetricsEndpoint(writer, registrationFetcher, cliConn, portManager, "app-name", command.UpdateEndpointOptions{
+			FromPort: "2112",
+			ToPort:   "9090",
+		})
+		Expect(err).To(MatchError("expected"))
+		Expect(cliConn.cliCommandsCalled).ToNot(Receive())
+	})
+})
@@ -0,0 +1,69 @@
+package ports
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// OpenedPortsAnnotation lists, comma separated, the ports of an app that the
+// plugin exposed. Ports the app exposed for other reasons are never listed.
+const OpenedPortsAnnotation = "metric-registrar.pivotal.io/opened-ports"
+
+type appMetadata struct {
+	Metadata struct {
+		Annotations map[string]*string `json:"annotations"`
+	} `json:"metadata"`
+}
+
+// Ownership records which ports the plugin opened in an annotation on the
+// Cloud Controller v3 app.
+type Ownership struct {
+	client client
+}
+
+func NewOwnership(client client) *Ownership {
+	return &Ownership{client: client}
+}
+
+func (o *Ownership) GetOpenedPortsForApp(guid string) ([]int, error) {
+	var app appMetadata
+	err := o.client.Get(fmt.Sprintf("/v3/apps/%s", guid), &app)
+	if err != nil {
+		return nil, err
+	}
+
+	value := app.Metadata.Annotations[OpenedPortsAnnotation]
+	if value == nil || *value == "" {
+		return nil, nil
+	}
+
+	var ports []int
+	for _, s := range strings.Split(*value, ",") {
+		p, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %q in annotation %s of app %s", s, OpenedPortsAnnotation, guid)
+		}
+		ports = append(ports, p)
+	}
+	return ports, nil
+}
+
+// SetOpenedPortsForApp replaces the recorded ports. The annotation is removed
+// when there are none.
+func (o *Ownership) SetOpenedPortsForApp(guid string, ports []int) error {
+	var value *string
+	if len(ports) > 0 {
+		var s []string
+		for _, p := range ports {
+			s = append(s, strconv.Itoa(p))
+		}
+		joined := strings.Join(s, ",")
+		value = &joined
+	}
+
+	var body appMetadata
+	body.Metadata.Annotations = map[string]*string{OpenedPortsAnnotation: value}
+	return o.client.Do(http.MethodPatch, fmt.Sprintf("/v3/apps/%s", guid), body, nil)
+}
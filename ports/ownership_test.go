@@ -0,0 +1,73 @@
+package ports_test
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/pivotal-cf/metric-registrar-cli/cloudcontroller"
+	"github.com/pivotal-cf/metric-registrar-cli/ports"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Ownership", func() {
+	It("reads the opened ports from the app's annotation", func() {
+		client := newMockClient(nil)
+		client.response = `{"metadata": {"annotations": {"metric-registrar.pivotal.io/opened-ports": "2112, 9090", "other": "x"}}}`
+
+		p, err := ports.NewOwnership(client).GetOpenedPortsForApp("app-guid")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(p).To(Equal([]int{2112, 9090}))
+		Expect(client.requests).To(Receive(matchRequest(http.MethodGet, "/v3/apps/app-guid")))
+	})
+
+	It("returns no ports when the app isn't annotated", func() {
+		client := newMockClient(nil)
+		client.response = `{"metadata": {"annotations": {}}}`
+
+		p, err := ports.NewOwnership(client).GetOpenedPortsForApp("app-guid")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(p).To(BeEmpty())
+	})
+
+	It("returns an error if the annotation is invalid", func() {
+		client := newMockClient(nil)
+		client.response = `{"metadata": {"annotations": {"metric-registrar.pivotal.io/opened-ports": "2112,http"}}}`
+
+		_, err := ports.NewOwnership(client).GetOpenedPortsForApp("app-guid")
+		Expect(err).To(MatchError(ContainSubstring(`invalid port "http"`)))
+	})
+
+	It("returns the Cloud Controller error", func() {
+		client := newMockClient(nil)
+		client.err = cloudcontroller.NotFoundError{}
+
+		_, err := ports.NewOwnership(client).GetOpenedPortsForApp("app-guid")
+		Expect(errors.As(err, &cloudcontroller.NotFoundError{})).To(BeTrue())
+	})
+
+	It("records the opened ports in the app's annotation", func() {
+		client := newMockClient(nil)
+
+		err := ports.NewOwnership(client).SetOpenedPortsForApp("app-guid", []int{2112, 9090})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(client.requests).To(Receive(matchRequest(
+			http.MethodPatch,
+			"/v3/apps/app-guid",
+			`{"metadata":{"annotations":{"metric-registrar.pivotal.io/opened-ports":"2112,9090"}}}`,
+		)))
+	})
+
+	It("removes the annotation when no ports are open", func() {
+		client := newMockClient(nil)
+
+		err := ports.NewOwnership(client).SetOpenedPortsForApp("app-guid", nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(client.requests).To(Receive(matchRequest(
+			http.MethodPatch,
+			"/v3/apps/app-guid",
+			`{"metadata":{"annotations":{"metric-registrar.pivotal.io/opened-ports":null}}}`,
+		)))
+	})
+})
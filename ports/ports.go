@@ -1,13 +1,13 @@
 package ports
 
 import (
-	"encoding/json"
 	"fmt"
-	"strings"
+	"net/http"
 )
 
-type cliConn interface {
-	CliCommandWithoutTerminalOutput(args ...string) ([]string, error)
+type client interface {
+	Get(path string, v interface{}) error
+	Do(method, path string, body, v interface{}) error
 }
 
 type Response struct {
@@ -18,28 +18,26 @@ type Entity struct {
 	Ports []int `json:"ports"`
 }
 
-func GetPortsForApp(cliConn cliConn, guid string) ([]int, error) {
-	appsEndpoint := fmt.Sprintf("/v2/apps/%s", guid)
-	output, err := cliConn.CliCommandWithoutTerminalOutput("curl", appsEndpoint)
-	if err != nil {
-		return []int{}, err
-	}
-	joined := strings.Join(output, "")
-	response := Response{}
-	err = json.Unmarshal([]byte(joined), &response)
-	return response.Entity.Ports, err
+// Manager reads and writes app ports through the Cloud Controller v2 apps
+// endpoint.
+type Manager struct {
+	client client
 }
 
-func SetPortsForApp(cliConn cliConn, guid string, ports []int) error {
-	appsEndpoint := fmt.Sprintf("/v2/apps/%s", guid)
+func NewManager(client client) *Manager {
+	return &Manager{client: client}
+}
 
-	newPortsEntity := Entity{Ports: ports}
-	portsBody, err := json.Marshal(newPortsEntity)
+func (m *Manager) GetPortsForApp(guid string) ([]int, error) {
+	response := Response{}
+	err := m.client.Get(fmt.Sprintf("/v2/apps/%s", guid), &response)
 	if err != nil {
-		return err
+		return []int{}, err
 	}
+	return response.Entity.Ports, nil
+}
 
-	wrappedPortsBody := fmt.Sprintf("'%s'", string(portsBody))
-	_, err = cliConn.CliCommandWithoutTerminalOutput("curl", appsEndpoint, "-X", "PUT", "-d", wrappedPortsBody)
-	return err
+func (m *Manager) SetPortsForApp(guid string, ports []int) error {
+	appsEndpoint := fmt.Sprintf("/v2/apps/%s", guid)
+	return m.client.Do(http.MethodPut, appsEndpoint, Entity{Ports: ports}, nil)
 }
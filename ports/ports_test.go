@@ -1,8 +1,12 @@
 package ports_test
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
-	"strings"
+	"net/http"
+
+	"github.com/pivotal-cf/metric-registrar-cli/cloudcontroller"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -10,65 +14,97 @@ import (
 	"github.com/pivotal-cf/metric-registrar-cli/ports"
 )
 
-var _ = Describe("Ports", func() {
+var _ = Describe("Manager", func() {
 	It("gets the exposed ports of the application", func() {
 		p1 := []int{1234, 2345}
-		cliConn := newMockCliConnection(p1)
-		p2, err := ports.GetPortsForApp(cliConn, "app-guid")
+		client := newMockClient(p1)
+		p2, err := ports.NewManager(client).GetPortsForApp("app-guid")
 		Expect(err).ToNot(HaveOccurred())
 		Expect(p2).To(Equal(p1))
-		expectToReceiveGetCurlForAppAndPort(cliConn.cliCommandsCalled, "app-guid")
+		Expect(client.requests).To(Receive(matchRequest(http.MethodGet, "/v2/apps/app-guid")))
 	})
 
 	It("sets the exposed ports of the application", func() {
 		p1 := []int{1234, 5678}
-		cliConn := newMockCliConnection([]int{})
-		err := ports.SetPortsForApp(cliConn, "app-guid", p1)
+		client := newMockClient([]int{})
+		err := ports.NewManager(client).SetPortsForApp("app-guid", p1)
 		Expect(err).ToNot(HaveOccurred())
-		expectToReceivePutCurlForAppAndPort(cliConn.cliCommandsCalled, "app-guid", p1)
+		Expect(client.requests).To(Receive(matchRequest(http.MethodPut, "/v2/apps/app-guid", `{"ports":[1234,5678]}`)))
+	})
+
+	It("returns the Cloud Controller error instead of empty ports", func() {
+		client := newMockClient(nil)
+		client.err = cloudcontroller.NotFoundError{}
+
+		p, err := ports.NewManager(client).GetPortsForApp("app-guid")
+		Expect(errors.As(err, &cloudcontroller.NotFoundError{})).To(BeTrue())
+		Expect(p).To(BeEmpty())
+	})
+
+	It("returns the Cloud Controller error when setting ports fails", func() {
+		client := newMockClient(nil)
+		client.err = cloudcontroller.NotAuthorizedError{}
+
+		err := ports.NewManager(client).SetPortsForApp("app-guid", []int{8080})
+		Expect(errors.As(err, &cloudcontroller.NotAuthorizedError{})).To(BeTrue())
 	})
 })
 
-type mockCliConnection struct {
-	curlResponses     []string
-	curlErrors        map[string]error
-	cliCommandsCalled chan []string
+type request struct {
+	method string
+	path   string
+	body   string
+}
+
+type mockClient struct {
+	response       string
+	pagedResponses []string
+	err            error
+	requests       chan request
 }
 
-func newMockCliConnection(ports []int) *mockCliConnection {
-	stringResponse := fmt.Sprintf(`{"entity": {"ports": %v}}`, transformIntSlice(ports))
-	response := strings.Split(stringResponse, "\n")
+func newMockClient(ports []int) *mockClient {
+	body, err := json.Marshal(ports)
+	Expect(err).ToNot(HaveOccurred())
 
-	return &mockCliConnection{
-		cliCommandsCalled: make(chan []string, 10),
-		curlResponses:     response,
-		curlErrors:        map[string]error{},
+	return &mockClient{
+		requests: make(chan request, 10),
+		response: fmt.Sprintf(`{"entity": {"ports": %s}}`, body),
 	}
 }
-func (c *mockCliConnection) CliCommandWithoutTerminalOutput(args ...string) ([]string, error) {
-	c.cliCommandsCalled <- args
-	Expect(args[0]).To(Equal("curl"))
-	return c.curlResponses, nil
-}
 
-func expectToReceivePutCurlForAppAndPort(called chan []string, appGuid string, ports []int) {
-	Eventually(called).Should(Receive(matchCurl(
-		fmt.Sprintf("/v2/apps/%s", appGuid),
-		"-X",
-		"PUT",
-		"-d",
-		fmt.Sprintf("'{\"ports\":%s}'", transformIntSlice(ports)),
-	)))
+func (c *mockClient) Get(path string, v interface{}) error {
+	return c.Do(http.MethodGet, path, nil, v)
 }
 
-func expectToReceiveGetCurlForAppAndPort(called chan []string, appGuid string) {
-	Eventually(called).Should(Receive(matchCurl(fmt.Sprintf("/v2/apps/%s", appGuid))))
-}
+func (c *mockClient) Do(method, path string, body, v interface{}) error {
+	r := request{method: method, path: path}
+	if body != nil {
+		b, err := json.Marshal(body)
+		Expect(err).ToNot(HaveOccurred())
+		r.body = string(b)
+	}
+	c.requests <- r
 
-func matchCurl(args ...string) types.GomegaMatcher {
-	return Equal(append([]string{"curl"}, args...))
+	if c.err != nil {
+		return c.err
+	}
+
+	resp := c.response
+	if method == http.MethodGet && len(c.pagedResponses) > 0 {
+		resp = c.pagedResponses[0]
+		c.pagedResponses = c.pagedResponses[1:]
+	}
+	if v == nil {
+		return nil
+	}
+	return json.Unmarshal([]byte(resp), v)
 }
 
-func transformIntSlice(i []int) string {
-	return strings.Replace(fmt.Sprintf("%v", i), " ", ",", -1)
+func matchRequest(method, path string, body ...string) types.GomegaMatcher {
+	r := request{method: method, path: path}
+	if len(body) > 0 {
+		r.body = body[0]
+	}
+	return Equal(r)
 }
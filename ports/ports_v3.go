@@ -0,0 +1,262 @@
+package ports
+
+import (
+	"fmt"
+	"net/http"
+)
+
+type routesResponse struct {
+	Pagination struct {
+		Next *struct {
+			Href string `json:"href"`
+		} `json:"next"`
+	} `json:"pagination"`
+	Resources []route `json:"resources"`
+}
+
+type route struct {
+	Guid         string        `json:"guid"`
+	URL          string        `json:"url"`
+	Destinations []destination `json:"destinations"`
+}
+
+type destination struct {
+	Guid string         `json:"guid,omitempty"`
+	App  destinationApp `json:"app"`
+	Port int            `json:"port"`
+}
+
+type destinationApp struct {
+	Guid    string             `json:"guid"`
+	Process destinationProcess `json:"process"`
+}
+
+type destinationProcess struct {
+	Type string `json:"type"`
+}
+
+type destinationsBody struct {
+	Destinations []destination `json:"destinations"`
+}
+
+type relationship struct {
+	Data struct {
+		Guid string `json:"guid"`
+	} `json:"data"`
+}
+
+type newRouteBody struct {
+	Host          string `json:"host"`
+	Relationships struct {
+		Domain relationship `json:"domain"`
+		Space  relationship `json:"space"`
+	} `json:"relationships"`
+}
+
+type spaceRelationship struct {
+	Relationships struct {
+		Space relationship `json:"space"`
+	} `json:"relationships"`
+}
+
+type guidsResponse struct {
+	Resources []struct {
+		Guid string `json:"guid"`
+	} `json:"resources"`
+}
+
+// InternalDomain is the domain of the routes the plugin maps opened ports on.
+// Routes on it aren't reachable from outside the foundation.
+const InternalDomain = "apps.internal"
+
+// V3Manager exposes app ports as route destination ports through the Cloud
+// Controller v3 API. It is used where the v2 apps endpoint is unavailable.
+// Ports are only ever mapped on an internal route owned by the plugin, the
+// destinations of the app's other routes are never changed. Removing a port
+// mapped on one of them fails rather than leaving it exposed.
+type V3Manager struct {
+	client client
+}
+
+func NewV3Manager(client client) *V3Manager {
+	return &V3Manager{client: client}
+}
+
+func (m *V3Manager) GetPortsForApp(guid string) ([]int, error) {
+	routes, err := m.getRoutes(guid)
+	if err != nil {
+		return nil, err
+	}
+
+	var ports []int
+	seen := map[int]bool{}
+	for _, r := range routes {
+		for _, d := range r.Destinations {
+			if d.App.Guid != guid || seen[d.Port] {
+				continue
+			}
+			seen[d.Port] = true
+			ports = append(ports, d.Port)
+		}
+	}
+
+	return ports, nil
+}
+
+func (m *V3Manager) SetPortsForApp(guid string, ports []int) error {
+	routes, err := m.getRoutes(guid)
+	if err != nil {
+		return err
+	}
+
+	desired := map[int]bool{}
+	for _, p := range ports {
+		desired[p] = true
+	}
+
+	var internal *route
+	exposed := map[int]bool{}
+	var stale []string
+	for i, r := range routes {
+		owned := r.URL == internalURL(guid)
+		if owned {
+			internal = &routes[i]
+		}
+		for _, d := range r.Destinations {
+			if d.App.Guid != guid {
+				continue
+			}
+			exposed[d.Port] = true
+			if desired[d.Port] {
+				continue
+			}
+			if !owned {
+				return fmt.Errorf("unable to remove port %d of app %s: it is mapped on route %s, which the plugin doesn't own", d.Port, guid, r.URL)
+			}
+			stale = append(stale, fmt.Sprintf("/v3/routes/%s/destinations/%s", r.Guid, d.Guid))
+		}
+	}
+
+	// add new destinations before removing old ones so a port that moves is
+	// never unreachable
+	var missing []int
+	for _, p := range ports {
+		if !exposed[p] {
+			missing = append(missing, p)
+			exposed[p] = true
+		}
+	}
+	if len(missing) > 0 {
+		routeGuid := ""
+		if internal != nil {
+			routeGuid = internal.Guid
+		} else {
+			routeGuid, err = m.internalRoute(guid)
+			if err != nil {
+				return fmt.Errorf("unable to expose ports %v: %s", missing, err)
+			}
+		}
+
+		err = m.addDestinations(routeGuid, guid, missing)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, path := range stale {
+		err := m.client.Do(http.MethodDelete, path, nil, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *V3Manager) addDestinations(routeGuid, appGuid string, ports []int) error {
+	var body destinationsBody
+	for _, p := range ports {
+		body.Destinations = append(body.Destinations, destination{
+			App: destinationApp{
+				Guid:    appGuid,
+				Process: destinationProcess{Type: "web"},
+			},
+			Port: p,
+		})
+	}
+
+	path := fmt.Sprintf("/v3/routes/%s/destinations", routeGuid)
+	return m.client.Do(http.MethodPost, path, body, nil)
+}
+
+// internalRoute finds or creates the plugin's internal route for the app. A
+// route is left behind when its last destination is removed, so it is reused
+// rather than created again.
+func (m *V3Manager) internalRoute(appGuid string) (string, error) {
+	var app spaceRelationship
+	err := m.client.Get(fmt.Sprintf("/v3/apps/%s", appGuid), &app)
+	if err != nil {
+		return "", err
+	}
+	spaceGuid := app.Relationships.Space.Data.Guid
+
+	var domains guidsResponse
+	err = m.client.Get("/v3/domains?names="+InternalDomain, &domains)
+	if err != nil {
+		return "", err
+	}
+	if len(domains.Resources) == 0 {
+		return "", fmt.Errorf("the %s domain is not available on this foundation", InternalDomain)
+	}
+	domainGuid := domains.Resources[0].Guid
+
+	var existing guidsResponse
+	path := fmt.Sprintf("/v3/routes?hosts=%s&domain_guids=%s&space_guids=%s", internalHost(appGuid), domainGuid, spaceGuid)
+	err = m.client.Get(path, &existing)
+	if err != nil {
+		return "", err
+	}
+	if len(existing.Resources) > 0 {
+		return existing.Resources[0].Guid, nil
+	}
+
+	body := newRouteBody{Host: internalHost(appGuid)}
+	body.Relationships.Domain.Data.Guid = domainGuid
+	body.Relationships.Space.Data.Guid = spaceGuid
+
+	var created route
+	err = m.client.Do(http.MethodPost, "/v3/routes", body, &created)
+	if err != nil {
+		return "", err
+	}
+	return created.Guid, nil
+}
+
+func internalHost(appGuid string) string {
+	return "metrics-" + appGuid
+}
+
+func internalURL(appGuid string) string {
+	return internalHost(appGuid) + "." + InternalDomain
+}
+
+func (m *V3Manager) getRoutes(guid string) ([]route, error) {
+	var routes []route
+
+	path := fmt.Sprintf("/v3/apps/%s/routes", guid)
+	for path != "" {
+		var page routesResponse
+		err := m.client.Get(path, &page)
+		if err != nil {
+			return nil, err
+		}
+		routes = append(routes, page.Resources...)
+
+		path = ""
+		if page.Pagination.Next != nil {
+			path = page.Pagination.Next.Href
+		}
+	}
+
+	return routes, nil
+}
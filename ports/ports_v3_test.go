@@ -0,0 +1,261 @@
+package ports_test
+
+import (
+	"errors"
+	"net/http"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/metric-registrar-cli/cloudcontroller"
+	"github.com/pivotal-cf/metric-registrar-cli/ports"
+)
+
+var _ = Describe("V3Manager", func() {
+	It("gets the destination ports of the application", func() {
+		client := newMockV3Client(appRoutes)
+
+		p, err := ports.NewV3Manager(client).GetPortsForApp("app-guid")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(p).To(Equal([]int{8080, 2112}))
+		Expect(client.requests).To(Receive(matchRequest(http.MethodGet, "/v3/apps/app-guid/routes")))
+	})
+
+	It("follows pagination links", func() {
+		client := newMockV3Client(appRoutesPage0, appRoutes)
+
+		p, err := ports.NewV3Manager(client).GetPortsForApp("app-guid")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(p).To(Equal([]int{9090, 8080, 2112}))
+		Expect(client.requests).To(Receive(matchRequest(http.MethodGet, "/v3/apps/app-guid/routes")))
+		Expect(client.requests).To(Receive(matchRequest(http.MethodGet, "https://api.example.com/v3/apps/app-guid/routes?page=2")))
+	})
+
+	It("adds destinations for new ports to the internal route and removes destinations for dropped ports", func() {
+		client := newMockV3Client(appRoutes)
+
+		err := ports.NewV3Manager(client).SetPortsForApp("app-guid", []int{8080, 9090})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(client.requests).To(Receive(matchRequest(http.MethodGet, "/v3/apps/app-guid/routes")))
+		Expect(client.requests).To(Receive(matchRequest(
+			http.MethodPost,
+			"/v3/routes/internal-route-guid/destinations",
+			`{"destinations":[{"app":{"guid":"app-guid","process":{"type":"web"}},"port":9090}]}`,
+		)))
+		Expect(client.requests).To(Receive(matchRequest(
+			http.MethodDelete,
+			"/v3/routes/internal-route-guid/destinations/destination-2112",
+		)))
+		Expect(client.requests).ToNot(Receive())
+	})
+
+	It("doesn't modify destinations when nothing changes", func() {
+		client := newMockV3Client(appRoutes)
+
+		err := ports.NewV3Manager(client).SetPortsForApp("app-guid", []int{2112, 8080})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(client.requests).To(Receive(matchRequest(http.MethodGet, "/v3/apps/app-guid/routes")))
+		Expect(client.requests).ToNot(Receive())
+	})
+
+	It("never changes the destinations of the app's public routes", func() {
+		client := newMockV3Client(
+			publicRouteOnly,
+			`{"relationships": {"space": {"data": {"guid": "space-guid"}}}}`,
+			`{"resources": [{"guid": "internal-domain-guid"}]}`,
+			noResources,
+		)
+		client.response = `{"guid": "new-route-guid"}`
+
+		err := ports.NewV3Manager(client).SetPortsForApp("app-guid", []int{8080, 9090, 2112})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(client.requests).To(Receive(matchRequest(http.MethodGet, "/v3/apps/app-guid/routes")))
+		Expect(client.requests).To(Receive(matchRequest(http.MethodGet, "/v3/apps/app-guid")))
+		Expect(client.requests).To(Receive(matchRequest(http.MethodGet, "/v3/domains?names=apps.internal")))
+		Expect(client.requests).To(Receive(matchRequest(
+			http.MethodGet,
+			"/v3/routes?hosts=metrics-app-guid&domain_guids=internal-domain-guid&space_guids=space-guid",
+		)))
+		Expect(client.requests).To(Receive(matchRequest(
+			http.MethodPost,
+			"/v3/routes",
+			`{"host":"metrics-app-guid","relationships":{"domain":{"data":{"guid":"internal-domain-guid"}},"space":{"data":{"guid":"space-guid"}}}}`,
+		)))
+		Expect(client.requests).To(Receive(matchRequest(
+			http.MethodPost,
+			"/v3/routes/new-route-guid/destinations",
+			`{"destinations":[{"app":{"guid":"app-guid","process":{"type":"web"}},"port":2112}]}`,
+		)))
+		Expect(client.requests).ToNot(Receive())
+	})
+
+	It("returns an error instead of removing a port mapped on a route the plugin doesn't own", func() {
+		client := newMockV3Client(appRoutes)
+
+		err := ports.NewV3Manager(client).SetPortsForApp("app-guid", []int{9090})
+		Expect(err).To(MatchError("unable to remove port 8080 of app app-guid: it is mapped on route app.example.com, which the plugin doesn't own"))
+
+		Expect(client.requests).To(Receive(matchRequest(http.MethodGet, "/v3/apps/app-guid/routes")))
+		Expect(client.requests).ToNot(Receive())
+	})
+
+	It("reuses the internal route of apps without routes", func() {
+		client := newMockV3Client(
+			noResources,
+			`{"relationships": {"space": {"data": {"guid": "space-guid"}}}}`,
+			`{"resources": [{"guid": "internal-domain-guid"}]}`,
+			`{"resources": [{"guid": "existing-route-guid"}]}`,
+		)
+
+		err := ports.NewV3Manager(client).SetPortsForApp("app-guid", []int{2112})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(client.requests).To(Receive(matchRequest(http.MethodGet, "/v3/apps/app-guid/routes")))
+		Expect(client.requests).To(Receive(matchRequest(http.MethodGet, "/v3/apps/app-guid")))
+		Expect(client.requests).To(Receive(matchRequest(http.MethodGet, "/v3/domains?names=apps.internal")))
+		Expect(client.requests).To(Receive(matchRequest(
+			http.MethodGet,
+			"/v3/routes?hosts=metrics-app-guid&domain_guids=internal-domain-guid&space_guids=space-guid",
+		)))
+		Expect(client.requests).To(Receive(matchRequest(
+			http.MethodPost,
+			"/v3/routes/existing-route-guid/destinations",
+			`{"destinations":[{"app":{"guid":"app-guid","process":{"type":"web"}},"port":2112}]}`,
+		)))
+		Expect(client.requests).ToNot(Receive())
+	})
+
+	It("returns an error if the foundation has no internal domain", func() {
+		client := newMockV3Client(
+			noResources,
+			`{"relationships": {"space": {"data": {"guid": "space-guid"}}}}`,
+			noResources,
+		)
+
+		err := ports.NewV3Manager(client).SetPortsForApp("app-guid", []int{2112})
+		Expect(err).To(MatchError("unable to expose ports [2112]: the apps.internal domain is not available on this foundation"))
+	})
+
+	It("returns an error if getting routes fails", func() {
+		client := newMockV3Client(appRoutes)
+		client.err = errors.New("expected")
+
+		_, err := ports.NewV3Manager(client).GetPortsForApp("app-guid")
+		Expect(err).To(HaveOccurred())
+		Expect(ports.NewV3Manager(client).SetPortsForApp("app-guid", []int{1234})).ToNot(Succeed())
+	})
+
+	It("doesn't change destinations if reading routes returns a Cloud Controller error", func() {
+		client := newMockV3Client(appRoutes)
+		client.err = cloudcontroller.NotFoundError{}
+
+		err := ports.NewV3Manager(client).SetPortsForApp("app-guid", []int{1234})
+		Expect(errors.As(err, &cloudcontroller.NotFoundError{})).To(BeTrue())
+		Expect(client.requests).To(Receive(matchRequest(http.MethodGet, "/v3/apps/app-guid/routes")))
+		Expect(client.requests).ToNot(Receive())
+	})
+
+	It("returns an error if routes are invalid JSON", func() {
+		client := newMockV3Client(`{invalid]`)
+
+		_, err := ports.NewV3Manager(client).GetPortsForApp("app-guid")
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+func newMockV3Client(routePages ...string) *mockClient {
+	return &mockClient{
+		requests:       make(chan request, 10),
+		pagedResponses: routePages,
+	}
+}
+
+const (
+	appRoutes = `{
+  "pagination": {
+    "next": null
+  },
+  "resources": [
+    {
+      "guid": "route-guid",
+      "url": "app.example.com",
+      "destinations": [
+        {
+          "guid": "destination-8080",
+          "app": {"guid": "app-guid", "process": {"type": "web"}},
+          "port": 8080
+        },
+        {
+          "guid": "other-app-destination",
+          "app": {"guid": "other-app-guid", "process": {"type": "web"}},
+          "port": 3000
+        }
+      ]
+    },
+    {
+      "guid": "internal-route-guid",
+      "url": "metrics-app-guid.apps.internal",
+      "destinations": [
+        {
+          "guid": "destination-2112",
+          "app": {"guid": "app-guid", "process": {"type": "web"}},
+          "port": 2112
+        }
+      ]
+    }
+  ]
+}`
+
+	publicRouteOnly = `{
+  "pagination": {
+    "next": null
+  },
+  "resources": [
+    {
+      "guid": "route-guid",
+      "url": "app.example.com",
+      "destinations": [
+        {
+          "guid": "destination-8080",
+          "app": {"guid": "app-guid", "process": {"type": "web"}},
+          "port": 8080
+        },
+        {
+          "guid": "destination-9090",
+          "app": {"guid": "app-guid", "process": {"type": "web"}},
+          "port": 9090
+        }
+      ]
+    }
+  ]
+}`
+
+	noResources = `{"pagination": {"next": null}, "resources": []}`
+
+	appRoutesPage0 = `{
+  "pagination": {
+    "next": {
+      "href": "https://api.example.com/v3/apps/app-guid/routes?page=2"
+    }
+  },
+  "resources": [
+    {
+      "guid": "other-route-guid",
+      "destinations": [
+        {
+          "guid": "destination-9090",
+          "app": {"guid": "app-guid", "process": {"type": "web"}},
+          "port": 9090
+        },
+        {
+          "guid": "destination-8080-other",
+          "app": {"guid": "app-guid", "process": {"type": "web"}},
+          "port": 8080
+        }
+      ]
+    }
+  ]
+}`
+)
@@ -0,0 +1,79 @@
+package registrations
+
+import (
+	"sync"
+)
+
+const DefaultConcurrency = 8
+
+type Option func(*options)
+
+type options struct {
+	concurrency int
+}
+
+// WithConcurrency limits how many binding lookups run at the same time.
+func WithConcurrency(n int) Option {
+	return func(o *options) {
+		o.concurrency = n
+	}
+}
+
+func newOptions(opts []Option) options {
+	o := options{concurrency: DefaultConcurrency}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.concurrency < 1 {
+		o.concurrency = 1
+	}
+	return o
+}
+
+// forEach calls fn for every index in [0, n) on at most limit goroutines.
+// After the first error no more calls are started and that error is returned.
+func forEach(limit, n int, fn func(i int) error) error {
+	jobs := make(chan int)
+	done := make(chan struct{})
+
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+
+	for w := 0; w < limit && w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				err := fn(i)
+				if err != nil {
+					once.Do(func() {
+						firstErr = err
+						close(done)
+					})
+				}
+			}
+		}()
+	}
+
+dispatch:
+	for i := 0; i < n; i++ {
+		select {
+		case <-done:
+			break dispatch
+		default:
+		}
+
+		select {
+		case jobs <- i:
+		case <-done:
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	return firstErr
+}
@@ -0,0 +1,9 @@
+package registrations
+
+import "time"
+
+// WithClock replaces the function the marker reads the creation time from.
+func (m *Marker) WithClock(now func() time.Time) *Marker {
+	m.now = now
+	return m
+}
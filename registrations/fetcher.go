@@ -4,17 +4,26 @@ import (
 	"encoding/json"
 	"fmt"
 	"strings"
+	"time"
 
 	plugin_models "code.cloudfoundry.org/cli/plugin/models"
 )
 
+type client interface {
+	Get(path string, v interface{}) error
+}
+
 type cliConn interface {
-	CliCommandWithoutTerminalOutput(args ...string) ([]string, error)
 	GetCurrentSpace() (plugin_models.Space, error)
 }
 
 type servicesResponse struct {
-	Entity serviceEntity `json:"entity"`
+	Metadata serviceMetadata `json:"metadata"`
+	Entity   serviceEntity   `json:"entity"`
+}
+
+type serviceMetadata struct {
+	CreatedAt time.Time `json:"created_at"`
 }
 
 type serviceEntity struct {
@@ -28,7 +37,8 @@ type bindingsResponse struct {
 }
 
 type bindingEntity struct {
-	AppGuid string `json:"app_guid"`
+	AppGuid            string `json:"app_guid"`
+	ServiceInstanceUrl string `json:"service_instance_url"`
 }
 
 type Registration struct {
@@ -36,53 +46,197 @@ type Registration struct {
 	Type             string
 	Config           string
 	NumberOfBindings int
+
+	// CreatedAt is when the service instance was created, or zero if the
+	// Cloud Controller didn't say.
+	CreatedAt time.Time
+
+	// Managed is true for service instances the plugin labelled when it
+	// created them. The v2 API has no labels, so it is always false there.
+	Managed bool
 }
 
+// v2 caps page sizes at 100 results
+const v2ResultsPerPage = 100
+
 type Fetcher struct {
+	client  client
 	cliConn cliConn
+	options options
 }
 
-func NewFetcher(conn cliConn) *Fetcher {
-	return &Fetcher{cliConn: conn}
+func NewFetcher(client client, conn cliConn, opts ...Option) *Fetcher {
+	return &Fetcher{
+		client:  client,
+		cliConn: conn,
+		options: newOptions(opts),
+	}
 }
 
 func (f *Fetcher) FetchAll(registrationTypes ...string) (map[string][]Registration, error) {
-	services, err := f.getServices()
+	matching, bindings, err := f.servicesWithBindings(registrationTypes)
 	if err != nil {
 		return nil, err
 	}
 
 	registrations := make(map[string][]Registration)
+	for i, s := range matching {
+		r, _ := s.registration()
+		r.NumberOfBindings = len(bindings[i])
+		for _, binding := range bindings[i] {
+			registrations[binding.Entity.AppGuid] = append(registrations[binding.Entity.AppGuid], r)
+		}
+	}
+
+	return registrations, nil
+}
+
+// FetchInstances returns the space's service instances of the given
+// registration types whether or not any app is bound to them. Bindings aren't
+// counted.
+func (f *Fetcher) FetchInstances(registrationTypes ...string) ([]Registration, error) {
+	services, err := f.getServices()
+	if err != nil {
+		return nil, err
+	}
+
+	var registrations []Registration
 	for _, s := range services {
-		r, ok := registration(s.Entity)
-		if !ok {
-			continue
+		r, ok := s.registration()
+		if ok && containsType(registrationTypes, r.Type) {
+			registrations = append(registrations, r)
 		}
-		for _, rt := range registrationTypes {
-			if r.Type != rt {
-				continue
-			}
-			bindings, err := f.serviceBindings(s.Entity.ServiceBindingsUrl)
-			if err != nil {
-				return nil, err
-			}
-			r.NumberOfBindings = len(bindings)
-			for _, binding := range bindings {
-				registrations[binding.Entity.AppGuid] = append(registrations[binding.Entity.AppGuid], r)
-			}
+	}
+	return registrations, nil
+}
+
+// FetchUnbound returns the space's service instances of the given
+// registration types that no app is bound to. FetchAll can't report them
+// because it indexes registrations by app.
+func (f *Fetcher) FetchUnbound(registrationTypes ...string) ([]Registration, error) {
+	matching, bindings, err := f.servicesWithBindings(registrationTypes)
+	if err != nil {
+		return nil, err
+	}
+
+	var registrations []Registration
+	for i, s := range matching {
+		if len(bindings[i]) == 0 {
+			r, _ := s.registration()
+			registrations = append(registrations, r)
 		}
 	}
+	return registrations, nil
+}
+
+// FetchUnparseable returns the space's service instances that look like
+// registrations of the given types, by their name or the start of their drain
+// URL, but whose drain URL can't be parsed. They are keyed by the guids of
+// the apps bound to them like in FetchAll. Their Type is empty and their
+// Config is the whole drain URL.
+func (f *Fetcher) FetchUnparseable(registrationTypes ...string) (map[string][]Registration, error) {
+	services, err := f.getServices()
+	if err != nil {
+		return nil, err
+	}
 
+	var matching []servicesResponse
+	for _, s := range services {
+		if unparseable(s.Entity.Name, s.Entity.DrainUrl, registrationTypes) {
+			matching = append(matching, s)
+		}
+	}
+
+	bindings, err := f.bindingsOf(matching)
+	if err != nil {
+		return nil, err
+	}
+
+	registrations := make(map[string][]Registration)
+	for i, s := range matching {
+		r := Registration{Name: s.Entity.Name, Config: s.Entity.DrainUrl, NumberOfBindings: len(bindings[i])}
+		for _, binding := range bindings[i] {
+			registrations[binding.Entity.AppGuid] = append(registrations[binding.Entity.AppGuid], r)
+		}
+	}
 	return registrations, nil
 }
 
+// servicesWithBindings returns the space's services of the given
+// registration types and, at the same index, their bindings.
+func (f *Fetcher) servicesWithBindings(registrationTypes []string) ([]servicesResponse, [][]bindingsResponse, error) {
+	services, err := f.getServices()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var matching []servicesResponse
+	for _, s := range services {
+		r, ok := s.registration()
+		if !ok || !containsType(registrationTypes, r.Type) {
+			continue
+		}
+		matching = append(matching, s)
+	}
+
+	bindings, err := f.bindingsOf(matching)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return matching, bindings, nil
+}
+
+// bindingsOf returns the bindings of each of services at the same index.
+func (f *Fetcher) bindingsOf(services []servicesResponse) ([][]bindingsResponse, error) {
+	bindings := make([][]bindingsResponse, len(services))
+	err := forEach(f.options.concurrency, len(services), func(i int) error {
+		var err error
+		bindings[i], err = f.serviceBindings(services[i].Entity.ServiceBindingsUrl)
+		return err
+	})
+	return bindings, err
+}
+
+// Fetch starts from the app's own bindings so that its cost doesn't depend on
+// the number of services in the space.
 func (f *Fetcher) Fetch(appGuid, registrationType string) ([]Registration, error) {
-	registrations, err := f.FetchAll(registrationType)
+	bindings, err := f.serviceBindings(fmt.Sprintf("/v2/apps/%s/service_bindings", appGuid))
 	if err != nil {
 		return nil, err
 	}
 
-	return registrations[appGuid], nil
+	var registrations []Registration
+	for _, b := range bindings {
+		if !strings.HasPrefix(b.Entity.ServiceInstanceUrl, "/v2/user_provided_service_instances/") {
+			continue
+		}
+
+		var s servicesResponse
+		err := f.client.Get(b.Entity.ServiceInstanceUrl, &s)
+		if err != nil {
+			return nil, err
+		}
+
+		r, ok := s.registration()
+		if !ok || r.Type != registrationType {
+			continue
+		}
+
+		r.NumberOfBindings, err = f.countBindings(s.Entity.ServiceBindingsUrl)
+		if err != nil {
+			return nil, err
+		}
+		registrations = append(registrations, r)
+	}
+
+	return registrations, nil
+}
+
+func (f *Fetcher) countBindings(serviceBindingsUrl string) (int, error) {
+	var page paginatedResp
+	err := f.client.Get(serviceBindingsUrl+"?results-per-page=1", &page)
+	return page.TotalResults, err
 }
 
 func (f *Fetcher) getServices() (services []servicesResponse, err error) {
@@ -91,7 +245,7 @@ func (f *Fetcher) getServices() (services []servicesResponse, err error) {
 		return services, err
 	}
 
-	path := fmt.Sprintf("/v2/user_provided_service_instances?q=space_guid:%s", space.Guid)
+	path := fmt.Sprintf("/v2/user_provided_service_instances?q=space_guid:%s&results-per-page=%d", space.Guid, v2ResultsPerPage)
 	err = f.getPagedResource(path, func(messages json.RawMessage) error {
 		var page []servicesResponse
 
@@ -105,21 +259,42 @@ func (f *Fetcher) getServices() (services []servicesResponse, err error) {
 	return services, err
 }
 
-func registration(e serviceEntity) (Registration, bool) {
-	drainUrlComponents := strings.Split(e.DrainUrl, "://")
+func (s servicesResponse) registration() (Registration, bool) {
+	r, ok := registration(s.Entity.Name, s.Entity.DrainUrl)
+	r.CreatedAt = s.Metadata.CreatedAt
+	return r, ok
+}
+
+// unparseable reports whether a service was meant as a registration of one
+// of registrationTypes but registration rejects its drain URL.
+func unparseable(name, drainUrl string, registrationTypes []string) bool {
+	if _, ok := registration(name, drainUrl); ok {
+		return false
+	}
+	for _, t := range registrationTypes {
+		if strings.HasPrefix(name, t) || strings.HasPrefix(drainUrl, t) {
+			return true
+		}
+	}
+	return false
+}
+
+func registration(name, drainUrl string) (Registration, bool) {
+	drainUrlComponents := strings.Split(drainUrl, "://")
 	if len(drainUrlComponents) != 2 {
 		return Registration{}, false
 	}
 
 	return Registration{
-		Name:   e.Name,
+		Name:   name,
 		Type:   drainUrlComponents[0],
 		Config: drainUrlComponents[1],
 	}, true
 }
 
 func (f *Fetcher) serviceBindings(serviceBindingsUrl string) (bindings []bindingsResponse, err error) {
-	err = f.getPagedResource(serviceBindingsUrl, func(messages json.RawMessage) error {
+	path := fmt.Sprintf("%s?results-per-page=%d", serviceBindingsUrl, v2ResultsPerPage)
+	err = f.getPagedResource(path, func(messages json.RawMessage) error {
 		var page []bindingsResponse
 
 		err := json.Unmarshal(messages, &page)
@@ -135,8 +310,9 @@ func (f *Fetcher) serviceBindings(serviceBindingsUrl string) (bindings []binding
 type accumulator func(json.RawMessage) error
 
 type paginatedResp struct {
-	Resources json.RawMessage `json:"resources"`
-	NextUrl   *string         `json:"next_url"`
+	TotalResults int             `json:"total_results"`
+	Resources    json.RawMessage `json:"resources"`
+	NextUrl      *string         `json:"next_url"`
 }
 
 func (f *Fetcher) getPagedResource(path string, a accumulator) error {
@@ -152,13 +328,8 @@ func (f *Fetcher) getPagedResource(path string, a accumulator) error {
 }
 
 func (f *Fetcher) getPage(path string, a accumulator) (string, error) {
-	resp, err := f.cliConn.CliCommandWithoutTerminalOutput("curl", path)
-	if err != nil {
-		return "", err
-	}
-
 	var page paginatedResp
-	err = json.Unmarshal([]byte(strings.Join(resp, "")), &page)
+	err := f.client.Get(path, &page)
 	if err != nil {
 		return "", err
 	}
@@ -1,8 +1,13 @@
 package registrations_test
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
+	"net/url"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/pivotal-cf/metric-registrar-cli/registrations"
 
@@ -14,8 +19,8 @@ import (
 var _ = Describe("Fetcher", func() {
 	Describe("FetchAll", func() {
 		It("Fetches registrations", func() {
-			cliConn := newMockCliConnection()
-			fetcher := registrations.NewFetcher(cliConn)
+			client := newMockClient()
+			fetcher := registrations.NewFetcher(client, client)
 
 			s, err := fetcher.FetchAll("structured-format")
 			Expect(err).ToNot(HaveOccurred())
@@ -34,11 +39,12 @@ var _ = Describe("Fetcher", func() {
 		})
 
 		It("handles paging", func() {
-			cliConn := newMockCliConnection()
-			fetcher := registrations.NewFetcher(cliConn)
+			client := newMockClient()
+			// binding pages are queued in order, so look them up one at a time
+			fetcher := registrations.NewFetcher(client, client, registrations.WithConcurrency(1))
 
-			cliConn.curlResponses["user_provided_service_instances"] = []string{validServicesPage0, validServices}
-			cliConn.curlResponses["service_bindings"] = []string{
+			client.responses["user_provided_service_instances"] = []string{validServicesPage0, validServices}
+			client.responses["service_bindings"] = []string{
 				validBindingsPage0, validBindings,
 				validBindings,
 			}
@@ -61,37 +67,139 @@ var _ = Describe("Fetcher", func() {
 			)))
 		})
 
-		DescribeTable("errors", func(modify func(*mockCliConnection)) {
-			cliConn := newMockCliConnection()
-			modify(cliConn)
-			fetcher := registrations.NewFetcher(cliConn)
+		It("keeps registrations in service order when fetching bindings concurrently", func() {
+			client := newMockClient()
+			client.responses["user_provided_service_instances"] = []string{manyServices(60)}
+			fetcher := registrations.NewFetcher(client, client, registrations.WithConcurrency(4))
+
+			s, err := fetcher.FetchAll("structured-format")
+			Expect(err).ToNot(HaveOccurred())
+
+			var names []string
+			for _, r := range s["app-guid"] {
+				names = append(names, r.Name)
+			}
+			Expect(names).To(HaveLen(60))
+			for i, name := range names {
+				Expect(name).To(Equal(fmt.Sprintf("structured-format-service-%d", i)))
+			}
+		})
+
+		It("stops fetching bindings after the first error", func() {
+			client := newMockClient()
+			client.responses["user_provided_service_instances"] = []string{manyServices(60)}
+			client.errors["service_bindings"] = errors.New("expected")
+			fetcher := registrations.NewFetcher(client, client, registrations.WithConcurrency(4))
+
+			_, err := fetcher.FetchAll("structured-format")
+			Expect(err).To(MatchError("expected"))
+			Expect(client.calls["service_bindings"]).To(BeNumerically("<", 60))
+		})
+
+		DescribeTable("errors", func(modify func(*mockClient)) {
+			client := newMockClient()
+			modify(client)
+			fetcher := registrations.NewFetcher(client, client)
 
 			_, err := fetcher.FetchAll("structured-format")
 			Expect(err).To(HaveOccurred())
 		},
-			Entry("getting space fails", func(cliConn *mockCliConnection) {
-				cliConn.getCurrentSpaceError = errors.New("expected")
+			Entry("getting space fails", func(client *mockClient) {
+				client.getCurrentSpaceError = errors.New("expected")
 			}),
-			Entry("getting service instances fails", func(cliConn *mockCliConnection) {
-				cliConn.curlErrors["user_provided_service_instances"] = errors.New("expected")
+			Entry("getting service instances fails", func(client *mockClient) {
+				client.errors["user_provided_service_instances"] = errors.New("expected")
 			}),
-			Entry("getting service instances returns invalid JSON", func(cliConn *mockCliConnection) {
-				cliConn.curlResponses["user_provided_service_instances"] = []string{`{invalid]`}
+			Entry("getting service instances returns invalid JSON", func(client *mockClient) {
+				client.responses["user_provided_service_instances"] = []string{`{invalid]`}
 			}),
 
-			Entry("getting service bindings fails", func(cliConn *mockCliConnection) {
-				cliConn.curlErrors["service_bindings"] = errors.New("expected")
+			Entry("getting service bindings fails", func(client *mockClient) {
+				client.errors["service_bindings"] = errors.New("expected")
 			}),
-			Entry("getting service bindings returns invalid JSON", func(cliConn *mockCliConnection) {
-				cliConn.curlResponses["service_bindings"] = []string{`{invalid]`}
+			Entry("getting service bindings returns invalid JSON", func(client *mockClient) {
+				client.responses["service_bindings"] = []string{`{invalid]`}
 			}),
 		)
 	})
 
+	Describe("FetchInstances", func() {
+		It("fetches instances whether or not they are bound", func() {
+			client := newMockClient()
+			fetcher := registrations.NewFetcher(client, client)
+
+			s, err := fetcher.FetchInstances("structured-format")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(s).To(Equal([]registrations.Registration{
+				{Name: "structured-format-service", Type: "structured-format", Config: "json"},
+				{Name: "unbound-structured-format-service", Type: "structured-format", Config: "json", CreatedAt: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)},
+			}))
+			Expect(client.calls["service_bindings"]).To(BeZero())
+		})
+
+		It("returns an error if getting the services fails", func() {
+			client := newMockClient()
+			client.errors["user_provided_service_instances"] = errors.New("expected")
+			fetcher := registrations.NewFetcher(client, client)
+
+			_, err := fetcher.FetchInstances("structured-format")
+			Expect(err).To(MatchError("expected"))
+		})
+	})
+
+	Describe("FetchUnbound", func() {
+		It("fetches only instances without bindings", func() {
+			client := newMockClient()
+			fetcher := registrations.NewFetcher(client, client)
+
+			s, err := fetcher.FetchUnbound("structured-format")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(s).To(Equal([]registrations.Registration{
+				{Name: "unbound-structured-format-service", Type: "structured-format", Config: "json", CreatedAt: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)},
+			}))
+		})
+
+		It("returns an error if getting the bindings fails", func() {
+			client := newMockClient()
+			client.errors["service_bindings"] = errors.New("expected")
+			fetcher := registrations.NewFetcher(client, client)
+
+			_, err := fetcher.FetchUnbound("structured-format")
+			Expect(err).To(MatchError("expected"))
+		})
+	})
+
+	Describe("FetchUnparseable", func() {
+		It("fetches bound instances that look like registrations but can't be parsed", func() {
+			client := newMockClient()
+			client.responses["user_provided_service_instances"] = []string{unparseableServices}
+			fetcher := registrations.NewFetcher(client, client)
+
+			s, err := fetcher.FetchUnparseable("metrics-endpoint", "secure-endpoint")
+			Expect(err).ToNot(HaveOccurred())
+
+			broken := registrations.Registration{Name: "metrics-endpoint-broken", Config: "metrics-endpoint:/metrics", NumberOfBindings: 2}
+			Expect(s).To(Equal(map[string][]registrations.Registration{
+				"app-guid": {broken},
+				"other":    {broken},
+			}))
+		})
+
+		It("returns an error if getting the services fails", func() {
+			client := newMockClient()
+			client.errors["user_provided_service_instances"] = errors.New("expected")
+			fetcher := registrations.NewFetcher(client, client)
+
+			_, err := fetcher.FetchUnparseable("metrics-endpoint")
+			Expect(err).To(MatchError("expected"))
+		})
+	})
+
 	Describe("Fetch", func() {
-		It("Fetches registrations", func() {
-			cliConn := newMockCliConnection()
-			fetcher := registrations.NewFetcher(cliConn)
+		It("Fetches registrations from the app's bindings", func() {
+			client := newMockClient()
+			client.errors["user_provided_service_instances"] = errors.New("space-wide lookup is not expected")
+			fetcher := registrations.NewFetcher(client, client)
 
 			s, err := fetcher.Fetch("app-guid", "structured-format")
 			Expect(err).ToNot(HaveOccurred())
@@ -104,13 +212,12 @@ var _ = Describe("Fetcher", func() {
 		})
 
 		It("handles paging", func() {
-			cliConn := newMockCliConnection()
-			fetcher := registrations.NewFetcher(cliConn)
+			client := newMockClient()
+			fetcher := registrations.NewFetcher(client, client)
 
-			cliConn.curlResponses["user_provided_service_instances"] = []string{validServicesPage0, validServices}
-			cliConn.curlResponses["service_bindings"] = []string{
-				validBindingsPage0, validBindings,
-				validBindings,
+			client.responses["app_service_bindings"] = []string{validAppBindingsPage0, validAppBindings}
+			client.responses["user_provided_service_instance"] = []string{
+				validServiceInstance0, validServiceInstance, otherServiceInstance,
 			}
 
 			s, err := fetcher.Fetch("app-guid", "structured-format")
@@ -120,7 +227,7 @@ var _ = Describe("Fetcher", func() {
 					Name:             "structured-format-service-0",
 					Type:             "structured-format",
 					Config:           "json",
-					NumberOfBindings: 3,
+					NumberOfBindings: 2,
 				},
 				registrations.Registration{
 					Name:             "structured-format-service",
@@ -131,51 +238,56 @@ var _ = Describe("Fetcher", func() {
 			))
 		})
 
-		DescribeTable("errors", func(modify func(*mockCliConnection)) {
-			cliConn := newMockCliConnection()
-			modify(cliConn)
-			fetcher := registrations.NewFetcher(cliConn)
+		DescribeTable("errors", func(modify func(*mockClient)) {
+			client := newMockClient()
+			modify(client)
+			fetcher := registrations.NewFetcher(client, client)
 
 			_, err := fetcher.Fetch("app-guid", "structured-format")
 			Expect(err).To(HaveOccurred())
 		},
-			Entry("getting space fails", func(cliConn *mockCliConnection) {
-				cliConn.getCurrentSpaceError = errors.New("expected")
+			Entry("getting app bindings fails", func(client *mockClient) {
+				client.errors["app_service_bindings"] = errors.New("expected")
 			}),
-			Entry("getting service instances fails", func(cliConn *mockCliConnection) {
-				cliConn.curlErrors["user_provided_service_instances"] = errors.New("expected")
+			Entry("getting app bindings returns invalid JSON", func(client *mockClient) {
+				client.responses["app_service_bindings"] = []string{`{invalid]`}
 			}),
-			Entry("getting service instances returns invalid JSON", func(cliConn *mockCliConnection) {
-				cliConn.curlResponses["user_provided_service_instances"] = []string{`{invalid]`}
+			Entry("getting a service instance fails", func(client *mockClient) {
+				client.errors["user_provided_service_instance"] = errors.New("expected")
 			}),
-
-			Entry("getting service bindings fails", func(cliConn *mockCliConnection) {
-				cliConn.curlErrors["service_bindings"] = errors.New("expected")
+			Entry("getting a service instance returns invalid JSON", func(client *mockClient) {
+				client.responses["user_provided_service_instance"] = []string{`{invalid]`}
 			}),
-			Entry("getting service bindings returns invalid JSON", func(cliConn *mockCliConnection) {
-				cliConn.curlResponses["service_bindings"] = []string{`{invalid]`}
+			Entry("counting service bindings fails", func(client *mockClient) {
+				client.errors["binding_counts"] = errors.New("expected")
 			}),
 		)
 	})
 })
 
-type mockCliConnection struct {
+type mockClient struct {
+	mu                   sync.Mutex
 	getCurrentSpaceError error
-	curlResponses        map[string][]string
-	curlErrors           map[string]error
+	responses            map[string][]string
+	errors               map[string]error
+	calls                map[string]int
 }
 
-func newMockCliConnection() *mockCliConnection {
-	return &mockCliConnection{
-		curlResponses: map[string][]string{
+func newMockClient() *mockClient {
+	return &mockClient{
+		responses: map[string][]string{
 			"user_provided_service_instances": {validServices},
 			"service_bindings":                {validBindings},
+			"app_service_bindings":            {validAppBindings},
+			"user_provided_service_instance":  {validServiceInstance, otherServiceInstance},
+			"binding_counts":                  {validBindingCount},
 		},
-		curlErrors: map[string]error{},
+		errors: map[string]error{},
+		calls:  map[string]int{},
 	}
 }
 
-func (c *mockCliConnection) GetCurrentSpace() (plugin_models.Space, error) {
+func (c *mockClient) GetCurrentSpace() (plugin_models.Space, error) {
 	return plugin_models.Space{
 		SpaceFields: plugin_models.SpaceFields{
 			Guid: "space-guid",
@@ -183,26 +295,82 @@ func (c *mockCliConnection) GetCurrentSpace() (plugin_models.Space, error) {
 	}, c.getCurrentSpaceError
 }
 
-func (c *mockCliConnection) CliCommandWithoutTerminalOutput(args ...string) ([]string, error) {
-	Expect(args[0]).To(Equal("curl"))
-	pathWithQuery := args[1]
+func (c *mockClient) Get(pathWithQuery string, v interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	u, err := url.Parse(pathWithQuery)
+	Expect(err).ToNot(HaveOccurred())
+	pathWithQuery = u.RequestURI()
 
-	parts := strings.Split(strings.Split(pathWithQuery, "?")[0], "/")
-	resource := parts[len(parts)-1]
+	path := u.Path
+	resource := resourceFor(pathWithQuery)
+	c.calls[resource]++
 
 	switch resource {
 	case "user_provided_service_instances":
-		Expect(pathWithQuery).To(Equal("/v2/user_provided_service_instances?q=space_guid:space-guid"))
+		Expect(pathWithQuery).To(HavePrefix("/v2/user_provided_service_instances?q=space_guid:space-guid&results-per-page=100"))
+	case "app_service_bindings":
+		Expect(path).To(Equal("/v2/apps/app-guid/service_bindings"))
+	case "app_service_credential_bindings":
+		Expect(pathWithQuery).To(HavePrefix("/v3/service_credential_bindings?type=app&app_guids=app-guid&include=service_instance"))
 	case "service_bindings":
-		if pathWithQuery != "/v2/user_provided_service_instances/guid/service_bindings" {
-			return strings.Split(emptyBindings, "\n"), c.curlErrors[resource]
+		if path != "/v2/user_provided_service_instances/guid/service_bindings" {
+			return c.respond(emptyBindings, c.errors[resource], v)
 		}
+	case "service_instances":
+		Expect(pathWithQuery).To(HavePrefix("/v3/service_instances?type=user-provided&space_guids=space-guid"))
+	case "service_credential_bindings":
+		Expect(pathWithQuery).To(HavePrefix("/v3/service_credential_bindings?type=app&service_instance_guids="))
 	}
 
-	resp := c.curlResponses[resource][0]
-	c.curlResponses[resource] = c.curlResponses[resource][1:]
+	// the last queued response is reused for any further requests
+	resp := c.responses[resource][0]
+	if len(c.responses[resource]) > 1 {
+		c.responses[resource] = c.responses[resource][1:]
+	}
+
+	return c.respond(resp, c.errors[resource], v)
+}
 
-	return strings.Split(resp, "\n"), c.curlErrors[resource]
+func (c *mockClient) respond(resp string, err error, v interface{}) error {
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(resp), v)
+}
+
+func manyServices(n int) string {
+	var resources []string
+	for i := 0; i < n; i++ {
+		resources = append(resources, fmt.Sprintf(`{
+      "entity": {
+        "name": "structured-format-service-%d",
+        "syslog_drain_url": "structured-format://json",
+        "service_bindings_url": "/v2/user_provided_service_instances/guid/service_bindings"
+      }
+    }`, i))
+	}
+
+	return fmt.Sprintf(`{"next_url": null, "resources": [%s]}`, strings.Join(resources, ","))
+}
+
+func resourceFor(pathWithQuery string) string {
+	path := strings.Split(pathWithQuery, "?")[0]
+	parts := strings.Split(path, "/")
+
+	switch {
+	case strings.HasPrefix(path, "/v2/apps/"):
+		return "app_service_bindings"
+	case len(parts) == 4 && parts[2] == "user_provided_service_instances":
+		return "user_provided_service_instance"
+	case strings.Contains(pathWithQuery, "app_guids="):
+		return "app_service_credential_bindings"
+	case strings.HasSuffix(pathWithQuery, "per_page=1"), strings.HasSuffix(pathWithQuery, "results-per-page=1"):
+		return "binding_counts"
+	}
+
+	return parts[len(parts)-1]
 }
 
 const (
@@ -217,6 +385,9 @@ const (
       }
     },
     {
+      "metadata": {
+        "created_at": "2026-01-02T03:04:05Z"
+      },
       "entity": {
         "name": "unbound-structured-format-service",
         "syslog_drain_url": "structured-format://json",
@@ -231,9 +402,42 @@ const (
       }
     }
   ]
+}`
+	unparseableServices = `{
+  "next_url": null,
+  "resources": [
+    {
+      "entity": {
+        "name": "metrics-endpoint-broken",
+        "syslog_drain_url": "metrics-endpoint:/metrics",
+        "service_bindings_url": "/v2/user_provided_service_instances/guid/service_bindings"
+      }
+    },
+    {
+      "entity": {
+        "name": "unbound-drain",
+        "syslog_drain_url": "secure-endpoint:2112/metrics",
+        "service_bindings_url": "/empty/service_bindings"
+      }
+    },
+    {
+      "entity": {
+        "name": "structured-format-service",
+        "syslog_drain_url": "structured-format://json",
+        "service_bindings_url": "/v2/user_provided_service_instances/guid/service_bindings"
+      }
+    },
+    {
+      "entity": {
+        "name": "unrelated-service",
+        "syslog_drain_url": "not a drain",
+        "service_bindings_url": "/v2/user_provided_service_instances/guid/service_bindings"
+      }
+    }
+  ]
 }`
 	validServicesPage0 = `{
-  "next_url": "/v2/user_provided_service_instances?q=space_guid:space-guid",
+  "next_url": "/v2/user_provided_service_instances?q=space_guid:space-guid&results-per-page=100&page=2",
   "resources": [
     {
       "entity": {
@@ -272,6 +476,75 @@ const (
   ]
 }`
 
+	validAppBindings = `{
+  "next_url": null,
+  "resources": [
+    {
+      "entity": {
+        "app_guid": "app-guid",
+        "service_instance_url": "/v2/user_provided_service_instances/guid"
+      }
+    },
+    {
+      "entity": {
+        "app_guid": "app-guid",
+        "service_instance_url": "/v2/service_instances/managed-guid"
+      }
+    },
+    {
+      "entity": {
+        "app_guid": "app-guid",
+        "service_instance_url": "/v2/user_provided_service_instances/other-guid"
+      }
+    }
+  ]
+}`
+
+	validAppBindingsPage0 = `{
+  "next_url": "/v2/apps/app-guid/service_bindings?page=2",
+  "resources": [
+    {
+      "entity": {
+        "app_guid": "app-guid",
+        "service_instance_url": "/v2/user_provided_service_instances/guid-0"
+      }
+    }
+  ]
+}`
+
+	validServiceInstance = `{
+  "entity": {
+    "name": "structured-format-service",
+    "syslog_drain_url": "structured-format://json",
+    "service_bindings_url": "/v2/user_provided_service_instances/guid/service_bindings"
+  }
+}`
+
+	validServiceInstance0 = `{
+  "entity": {
+    "name": "structured-format-service-0",
+    "syslog_drain_url": "structured-format://json",
+    "service_bindings_url": "/v2/user_provided_service_instances/guid-0/service_bindings"
+  }
+}`
+
+	otherServiceInstance = `{
+  "entity": {
+    "name": "other-valid-service",
+    "syslog_drain_url": "not-structured-format://json",
+    "service_bindings_url": "/v2/user_provided_service_instances/other-guid/service_bindings"
+  }
+}`
+
+	validBindingCount = `{
+  "total_results": 2,
+  "next_url": "/v2/user_provided_service_instances/guid/service_bindings?page=2&results-per-page=1",
+  "pagination": {
+    "total_results": 2
+  },
+  "resources": []
+}`
+
 	emptyBindings = `{
       "resources": []
     }`
@@ -0,0 +1,355 @@
+package registrations
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// Cloud Controller rejects very long query strings, so binding lookups
+	// are batched by service instance guid.
+	serviceInstanceGuidsPerRequest = 50
+
+	// v3 caps page sizes at 5000 resources
+	v3PerPage = 5000
+)
+
+type v3ServiceInstance struct {
+	Guid      string     `json:"guid"`
+	Name      string     `json:"name"`
+	Type      string     `json:"type"`
+	DrainUrl  string     `json:"syslog_drain_url"`
+	CreatedAt time.Time  `json:"created_at"`
+	Metadata  v3Metadata `json:"metadata"`
+}
+
+func (s v3ServiceInstance) registration() (Registration, bool) {
+	r, ok := s.Metadata.registration(s.Name, s.DrainUrl)
+	r.CreatedAt = s.CreatedAt
+	return r, ok
+}
+
+type v3Binding struct {
+	Relationships v3BindingRelationships `json:"relationships"`
+}
+
+type v3BindingRelationships struct {
+	App             v3Relationship `json:"app"`
+	ServiceInstance v3Relationship `json:"service_instance"`
+}
+
+type v3Relationship struct {
+	Data struct {
+		Guid string `json:"guid"`
+	} `json:"data"`
+}
+
+type V3Fetcher struct {
+	client  client
+	cliConn cliConn
+	options options
+}
+
+func NewV3Fetcher(client client, conn cliConn, opts ...Option) *V3Fetcher {
+	return &V3Fetcher{
+		client:  client,
+		cliConn: conn,
+		options: newOptions(opts),
+	}
+}
+
+func (f *V3Fetcher) FetchAll(registrationTypes ...string) (map[string][]Registration, error) {
+	matching, err := f.matchingServiceInstances(registrationTypes)
+	if err != nil {
+		return nil, err
+	}
+
+	appGuidsByInstance, err := f.boundAppGuids(matching)
+	if err != nil {
+		return nil, err
+	}
+
+	registrations := make(map[string][]Registration)
+	for _, s := range matching {
+		r, _ := s.registration()
+		appGuids := appGuidsByInstance[s.Guid]
+		r.NumberOfBindings = len(appGuids)
+		for _, appGuid := range appGuids {
+			registrations[appGuid] = append(registrations[appGuid], r)
+		}
+	}
+
+	return registrations, nil
+}
+
+// FetchInstances returns the space's service instances of the given
+// registration types whether or not any app is bound to them. Bindings aren't
+// counted.
+func (f *V3Fetcher) FetchInstances(registrationTypes ...string) ([]Registration, error) {
+	instances, err := f.getServiceInstances()
+	if err != nil {
+		return nil, err
+	}
+
+	var registrations []Registration
+	for _, s := range instances {
+		r, ok := s.registration()
+		if ok && containsType(registrationTypes, r.Type) {
+			registrations = append(registrations, r)
+		}
+	}
+	return registrations, nil
+}
+
+// FetchUnbound returns the space's service instances of the given
+// registration types that no app is bound to. FetchAll can't report them
+// because it indexes registrations by app.
+func (f *V3Fetcher) FetchUnbound(registrationTypes ...string) ([]Registration, error) {
+	matching, err := f.matchingServiceInstances(registrationTypes)
+	if err != nil {
+		return nil, err
+	}
+
+	appGuidsByInstance, err := f.boundAppGuids(matching)
+	if err != nil {
+		return nil, err
+	}
+
+	var registrations []Registration
+	for _, s := range matching {
+		if len(appGuidsByInstance[s.Guid]) == 0 {
+			r, _ := s.registration()
+			registrations = append(registrations, r)
+		}
+	}
+	return registrations, nil
+}
+
+// FetchUnparseable returns the space's service instances that look like
+// registrations of the given types, by their name or the start of their drain
+// URL, but whose drain URL can't be parsed. They are keyed by the guids of
+// the apps bound to them like in FetchAll. Their Type is empty and their
+// Config is the whole drain URL.
+func (f *V3Fetcher) FetchUnparseable(registrationTypes ...string) (map[string][]Registration, error) {
+	instances, err := f.getServiceInstances()
+	if err != nil {
+		return nil, err
+	}
+
+	var matching []v3ServiceInstance
+	for _, s := range instances {
+		if _, ok := s.registration(); !ok && unparseable(s.Name, s.DrainUrl, registrationTypes) {
+			matching = append(matching, s)
+		}
+	}
+
+	appGuidsByInstance, err := f.boundAppGuids(matching)
+	if err != nil {
+		return nil, err
+	}
+
+	registrations := make(map[string][]Registration)
+	for _, s := range matching {
+		appGuids := appGuidsByInstance[s.Guid]
+		r := Registration{Name: s.Name, Config: s.DrainUrl, CreatedAt: s.CreatedAt, NumberOfBindings: len(appGuids)}
+		for _, appGuid := range appGuids {
+			registrations[appGuid] = append(registrations[appGuid], r)
+		}
+	}
+	return registrations, nil
+}
+
+func (f *V3Fetcher) matchingServiceInstances(registrationTypes []string) ([]v3ServiceInstance, error) {
+	instances, err := f.getServiceInstances()
+	if err != nil {
+		return nil, err
+	}
+
+	var matching []v3ServiceInstance
+	for _, s := range instances {
+		r, ok := s.registration()
+		if ok && containsType(registrationTypes, r.Type) {
+			matching = append(matching, s)
+		}
+	}
+	return matching, nil
+}
+
+// Fetch starts from the app's own bindings so that its cost doesn't depend on
+// the number of services in the space.
+func (f *V3Fetcher) Fetch(appGuid, registrationType string) ([]Registration, error) {
+	var bindings []v3Binding
+	instances := make(map[string]v3ServiceInstance)
+
+	path := fmt.Sprintf("/v3/service_credential_bindings?type=app&app_guids=%s&include=service_instance&per_page=%d", appGuid, v3PerPage)
+	err := f.getPagedResource(path, func(page v3PaginatedResp) error {
+		var resources []v3Binding
+		err := json.Unmarshal(page.Resources, &resources)
+		if err != nil {
+			return err
+		}
+		bindings = append(bindings, resources...)
+
+		for _, s := range page.Included.ServiceInstances {
+			instances[s.Guid] = s
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var registrations []Registration
+	for _, b := range bindings {
+		s, ok := instances[b.Relationships.ServiceInstance.Data.Guid]
+		if !ok || s.Type != "user-provided" {
+			continue
+		}
+
+		r, ok := s.registration()
+		if !ok || r.Type != registrationType {
+			continue
+		}
+
+		r.NumberOfBindings, err = f.countBindings(s.Guid)
+		if err != nil {
+			return nil, err
+		}
+		registrations = append(registrations, r)
+	}
+
+	return registrations, nil
+}
+
+func (f *V3Fetcher) countBindings(serviceInstanceGuid string) (int, error) {
+	path := fmt.Sprintf("/v3/service_credential_bindings?type=app&service_instance_guids=%s&per_page=1", serviceInstanceGuid)
+
+	var page v3PaginatedResp
+	err := f.client.Get(path, &page)
+	return page.Pagination.TotalResults, err
+}
+
+func (f *V3Fetcher) getServiceInstances() (instances []v3ServiceInstance, err error) {
+	space, err := f.cliConn.GetCurrentSpace()
+	if err != nil {
+		return instances, err
+	}
+
+	path := fmt.Sprintf("/v3/service_instances?type=user-provided&space_guids=%s&per_page=%d", space.Guid, v3PerPage)
+	err = f.getPagedResource(path, func(page v3PaginatedResp) error {
+		var resources []v3ServiceInstance
+
+		err := json.Unmarshal(page.Resources, &resources)
+		if err != nil {
+			return err
+		}
+		instances = append(instances, resources...)
+		return nil
+	})
+	return instances, err
+}
+
+func (f *V3Fetcher) boundAppGuids(instances []v3ServiceInstance) (map[string][]string, error) {
+	var batches [][]string
+	for start := 0; start < len(instances); start += serviceInstanceGuidsPerRequest {
+		end := start + serviceInstanceGuidsPerRequest
+		if end > len(instances) {
+			end = len(instances)
+		}
+
+		var guids []string
+		for _, s := range instances[start:end] {
+			guids = append(guids, s.Guid)
+		}
+		batches = append(batches, guids)
+	}
+
+	bindings := make([][]v3Binding, len(batches))
+	err := forEach(f.options.concurrency, len(batches), func(i int) error {
+		path := fmt.Sprintf(
+			"/v3/service_credential_bindings?type=app&service_instance_guids=%s&per_page=%d",
+			strings.Join(batches[i], ","),
+			v3PerPage,
+		)
+		return f.getPagedResource(path, func(page v3PaginatedResp) error {
+			var resources []v3Binding
+
+			err := json.Unmarshal(page.Resources, &resources)
+			if err != nil {
+				return err
+			}
+			bindings[i] = append(bindings[i], resources...)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	appGuids := make(map[string][]string)
+	for _, batch := range bindings {
+		for _, b := range batch {
+			instanceGuid := b.Relationships.ServiceInstance.Data.Guid
+			appGuids[instanceGuid] = append(appGuids[instanceGuid], b.Relationships.App.Data.Guid)
+		}
+	}
+
+	return appGuids, nil
+}
+
+type v3PaginatedResp struct {
+	Pagination struct {
+		TotalResults int `json:"total_results"`
+		Next         *struct {
+			Href string `json:"href"`
+		} `json:"next"`
+	} `json:"pagination"`
+	Resources json.RawMessage `json:"resources"`
+	Included  struct {
+		ServiceInstances []v3ServiceInstance `json:"service_instances"`
+	} `json:"included"`
+}
+
+type v3Accumulator func(v3PaginatedResp) error
+
+func (f *V3Fetcher) getPagedResource(path string, a v3Accumulator) error {
+	var err error
+	for path != "" {
+		path, err = f.getPage(path, a)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (f *V3Fetcher) getPage(path string, a v3Accumulator) (string, error) {
+	var page v3PaginatedResp
+	err := f.client.Get(path, &page)
+	if err != nil {
+		return "", err
+	}
+
+	err = a(page)
+	if err != nil {
+		return "", err
+	}
+
+	if page.Pagination.Next == nil {
+		return "", nil
+	}
+
+	return page.Pagination.Next.Href, nil
+}
+
+func containsType(registrationTypes []string, t string) bool {
+	for _, rt := range registrationTypes {
+		if rt == t {
+			return true
+		}
+	}
+	return false
+}
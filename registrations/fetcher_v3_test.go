@@ -0,0 +1,406 @@
+package registrations_test
+
+import (
+	"errors"
+	"time"
+
+	"github.com/pivotal-cf/metric-registrar-cli/registrations"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("V3Fetcher", func() {
+	Describe("FetchAll", func() {
+		It("Fetches registrations", func() {
+			client := newMockV3Client()
+			fetcher := registrations.NewV3Fetcher(client, client)
+
+			s, err := fetcher.FetchAll("structured-format")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(s).To(HaveKeyWithValue("app-guid", ConsistOf(registrations.Registration{
+				Name:             "structured-format-service",
+				Type:             "structured-format",
+				Config:           "json",
+				NumberOfBindings: 2,
+			})))
+			Expect(s).To(HaveKeyWithValue("other", ConsistOf(registrations.Registration{
+				Name:             "structured-format-service",
+				Type:             "structured-format",
+				Config:           "json",
+				NumberOfBindings: 2,
+			})))
+			Expect(s).To(HaveLen(2))
+		})
+
+		It("handles paging", func() {
+			client := newMockV3Client()
+			fetcher := registrations.NewV3Fetcher(client, client)
+
+			client.responses["service_instances"] = []string{validV3ServicesPage0, validV3Services}
+			client.responses["service_credential_bindings"] = []string{validV3BindingsPage0, validV3Bindings}
+
+			s, err := fetcher.FetchAll("structured-format")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(s).To(HaveKeyWithValue("app-guid", ConsistOf(
+				registrations.Registration{
+					Name:             "structured-format-service-0",
+					Type:             "structured-format",
+					Config:           "json",
+					NumberOfBindings: 1,
+				},
+				registrations.Registration{
+					Name:             "structured-format-service",
+					Type:             "structured-format",
+					Config:           "json",
+					NumberOfBindings: 2,
+				},
+			)))
+		})
+
+		It("doesn't request bindings when no service instances match", func() {
+			client := newMockV3Client()
+			fetcher := registrations.NewV3Fetcher(client, client)
+			client.errors["service_credential_bindings"] = errors.New("unexpected")
+
+			s, err := fetcher.FetchAll("metrics-endpoint")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(s).To(BeEmpty())
+		})
+
+		DescribeTable("errors", func(modify func(*mockClient)) {
+			client := newMockV3Client()
+			modify(client)
+			fetcher := registrations.NewV3Fetcher(client, client)
+
+			_, err := fetcher.FetchAll("structured-format")
+			Expect(err).To(HaveOccurred())
+		},
+			Entry("getting space fails", func(client *mockClient) {
+				client.getCurrentSpaceError = errors.New("expected")
+			}),
+			Entry("getting service instances fails", func(client *mockClient) {
+				client.errors["service_instances"] = errors.New("expected")
+			}),
+			Entry("getting service instances returns invalid JSON", func(client *mockClient) {
+				client.responses["service_instances"] = []string{`{invalid]`}
+			}),
+			Entry("getting service bindings fails", func(client *mockClient) {
+				client.errors["service_credential_bindings"] = errors.New("expected")
+			}),
+			Entry("getting service bindings returns invalid JSON", func(client *mockClient) {
+				client.responses["service_credential_bindings"] = []string{`{invalid]`}
+			}),
+		)
+	})
+
+	Describe("FetchInstances", func() {
+		It("fetches instances whether or not they are bound", func() {
+			client := newMockV3Client()
+			fetcher := registrations.NewV3Fetcher(client, client)
+
+			s, err := fetcher.FetchInstances("structured-format")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(s).To(Equal([]registrations.Registration{
+				{Name: "structured-format-service", Type: "structured-format", Config: "json"},
+				{Name: "unbound-structured-format-service", Type: "structured-format", Config: "json", CreatedAt: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)},
+			}))
+			Expect(client.calls["service_credential_bindings"]).To(BeZero())
+		})
+
+		It("returns an error if getting the service instances fails", func() {
+			client := newMockV3Client()
+			client.errors["service_instances"] = errors.New("expected")
+			fetcher := registrations.NewV3Fetcher(client, client)
+
+			_, err := fetcher.FetchInstances("structured-format")
+			Expect(err).To(MatchError("expected"))
+		})
+	})
+
+	Describe("FetchUnbound", func() {
+		It("fetches only instances without bindings", func() {
+			client := newMockV3Client()
+			fetcher := registrations.NewV3Fetcher(client, client)
+
+			s, err := fetcher.FetchUnbound("structured-format")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(s).To(Equal([]registrations.Registration{
+				{Name: "unbound-structured-format-service", Type: "structured-format", Config: "json", CreatedAt: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)},
+			}))
+		})
+
+		It("returns an error if getting the bindings fails", func() {
+			client := newMockV3Client()
+			client.errors["service_credential_bindings"] = errors.New("expected")
+			fetcher := registrations.NewV3Fetcher(client, client)
+
+			_, err := fetcher.FetchUnbound("structured-format")
+			Expect(err).To(MatchError("expected"))
+		})
+	})
+
+	Describe("FetchUnparseable", func() {
+		It("fetches bound instances that look like registrations but can't be parsed", func() {
+			client := newMockV3Client()
+			client.responses["service_instances"] = []string{unparseableV3Services}
+			fetcher := registrations.NewV3Fetcher(client, client)
+
+			s, err := fetcher.FetchUnparseable("structured-format", "metrics-endpoint")
+			Expect(err).ToNot(HaveOccurred())
+
+			broken := registrations.Registration{Name: "broken-drain", Config: "structured-format:json", NumberOfBindings: 2}
+			Expect(s).To(Equal(map[string][]registrations.Registration{
+				"app-guid": {broken},
+				"other":    {broken},
+			}))
+		})
+
+		It("returns an error if getting the bindings fails", func() {
+			client := newMockV3Client()
+			client.responses["service_instances"] = []string{unparseableV3Services}
+			client.errors["service_credential_bindings"] = errors.New("expected")
+			fetcher := registrations.NewV3Fetcher(client, client)
+
+			_, err := fetcher.FetchUnparseable("structured-format")
+			Expect(err).To(MatchError("expected"))
+		})
+	})
+
+	Describe("Fetch", func() {
+		It("Fetches registrations from the app's bindings", func() {
+			client := newMockV3Client()
+			client.errors["service_instances"] = errors.New("space-wide lookup is not expected")
+			fetcher := registrations.NewV3Fetcher(client, client)
+
+			s, err := fetcher.Fetch("app-guid", "structured-format")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(s).To(ConsistOf(registrations.Registration{
+				Name:             "structured-format-service",
+				Type:             "structured-format",
+				Config:           "json",
+				NumberOfBindings: 2,
+			}))
+		})
+
+		It("handles paging", func() {
+			client := newMockV3Client()
+			client.responses["app_service_credential_bindings"] = []string{validV3AppBindingsPage0, validV3AppBindings}
+			fetcher := registrations.NewV3Fetcher(client, client)
+
+			s, err := fetcher.Fetch("app-guid", "structured-format")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(s).To(ConsistOf(
+				registrations.Registration{
+					Name:             "structured-format-service-0",
+					Type:             "structured-format",
+					Config:           "json",
+					NumberOfBindings: 2,
+				},
+				registrations.Registration{
+					Name:             "structured-format-service",
+					Type:             "structured-format",
+					Config:           "json",
+					NumberOfBindings: 2,
+				},
+			))
+		})
+
+		DescribeTable("errors", func(modify func(*mockClient)) {
+			client := newMockV3Client()
+			modify(client)
+			fetcher := registrations.NewV3Fetcher(client, client)
+
+			_, err := fetcher.Fetch("app-guid", "structured-format")
+			Expect(err).To(HaveOccurred())
+		},
+			Entry("getting app bindings fails", func(client *mockClient) {
+				client.errors["app_service_credential_bindings"] = errors.New("expected")
+			}),
+			Entry("getting app bindings returns invalid JSON", func(client *mockClient) {
+				client.responses["app_service_credential_bindings"] = []string{`{invalid]`}
+			}),
+			Entry("counting service bindings fails", func(client *mockClient) {
+				client.errors["binding_counts"] = errors.New("expected")
+			}),
+		)
+	})
+})
+
+func newMockV3Client() *mockClient {
+	return &mockClient{
+		responses: map[string][]string{
+			"service_instances":               {validV3Services},
+			"service_credential_bindings":     {validV3Bindings},
+			"app_service_credential_bindings": {validV3AppBindings},
+			"binding_counts":                  {validBindingCount},
+		},
+		errors: map[string]error{},
+		calls:  map[string]int{},
+	}
+}
+
+const (
+	validV3Services = `{
+  "pagination": {
+    "next": null
+  },
+  "resources": [
+    {
+      "guid": "guid",
+      "name": "structured-format-service",
+      "syslog_drain_url": "structured-format://json"
+    },
+    {
+      "guid": "unbound-guid",
+      "name": "unbound-structured-format-service",
+      "syslog_drain_url": "structured-format://json",
+      "created_at": "2026-01-02T03:04:05Z"
+    },
+    {
+      "guid": "other-guid",
+      "name": "other-valid-service",
+      "syslog_drain_url": "not-structured-format://json"
+    }
+  ]
+}`
+	unparseableV3Services = `{
+  "pagination": {
+    "next": null
+  },
+  "resources": [
+    {
+      "guid": "guid",
+      "name": "broken-drain",
+      "syslog_drain_url": "structured-format:json"
+    },
+    {
+      "guid": "other-guid",
+      "name": "unrelated-service",
+      "syslog_drain_url": "not a drain"
+    }
+  ]
+}`
+	validV3ServicesPage0 = `{
+  "pagination": {
+    "next": {
+      "href": "https://api.example.com/v3/service_instances?type=user-provided&space_guids=space-guid&page=2"
+    }
+  },
+  "resources": [
+    {
+      "guid": "guid-0",
+      "name": "structured-format-service-0",
+      "syslog_drain_url": "structured-format://json"
+    }
+  ]
+}`
+
+	validV3Bindings = `{
+  "pagination": {
+    "next": null
+  },
+  "resources": [
+    {
+      "relationships": {
+        "app": {"data": {"guid": "app-guid"}},
+        "service_instance": {"data": {"guid": "guid"}}
+      }
+    },
+    {
+      "relationships": {
+        "app": {"data": {"guid": "other"}},
+        "service_instance": {"data": {"guid": "guid"}}
+      }
+    }
+  ]
+}`
+	validV3BindingsPage0 = `{
+  "pagination": {
+    "next": {
+      "href": "https://api.example.com/v3/service_credential_bindings?type=app&service_instance_guids=guid-0,guid,unbound-guid&page=2"
+    }
+  },
+  "resources": [
+    {
+      "relationships": {
+        "app": {"data": {"guid": "app-guid"}},
+        "service_instance": {"data": {"guid": "guid-0"}}
+      }
+    }
+  ]
+}`
+
+	validV3AppBindings = `{
+  "pagination": {
+    "next": null
+  },
+  "resources": [
+    {
+      "relationships": {
+        "app": {"data": {"guid": "app-guid"}},
+        "service_instance": {"data": {"guid": "guid"}}
+      }
+    },
+    {
+      "relationships": {
+        "app": {"data": {"guid": "app-guid"}},
+        "service_instance": {"data": {"guid": "managed-guid"}}
+      }
+    },
+    {
+      "relationships": {
+        "app": {"data": {"guid": "app-guid"}},
+        "service_instance": {"data": {"guid": "other-guid"}}
+      }
+    }
+  ],
+  "included": {
+    "service_instances": [
+      {
+        "guid": "guid",
+        "name": "structured-format-service",
+        "type": "user-provided",
+        "syslog_drain_url": "structured-format://json"
+      },
+      {
+        "guid": "managed-guid",
+        "name": "managed-service",
+        "type": "managed",
+        "syslog_drain_url": "structured-format://json"
+      },
+      {
+        "guid": "other-guid",
+        "name": "other-valid-service",
+        "type": "user-provided",
+        "syslog_drain_url": "not-structured-format://json"
+      }
+    ]
+  }
+}`
+	validV3AppBindingsPage0 = `{
+  "pagination": {
+    "next": {
+      "href": "https://api.example.com/v3/service_credential_bindings?type=app&app_guids=app-guid&include=service_instance&page=2"
+    }
+  },
+  "resources": [
+    {
+      "relationships": {
+        "app": {"data": {"guid": "app-guid"}},
+        "service_instance": {"data": {"guid": "guid-0"}}
+      }
+    }
+  ],
+  "included": {
+    "service_instances": [
+      {
+        "guid": "guid-0",
+        "name": "structured-format-service-0",
+        "type": "user-provided",
+        "syslog_drain_url": "structured-format://json"
+      }
+    ]
+  }
+}`
+)
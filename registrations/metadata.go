@@ -0,0 +1,98 @@
+package registrations
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Labels and annotations the plugin puts on the service instances it creates,
+// so that they can be told apart from services that merely have a drain URL
+// that looks like a registration.
+const (
+	TypeLabel               = "metric-registrar.pivotal.io/type"
+	PluginVersionAnnotation = "metric-registrar.pivotal.io/plugin-version"
+	CreatedByAnnotation     = "metric-registrar.pivotal.io/created-by"
+	CreatedAtAnnotation     = "metric-registrar.pivotal.io/created-at"
+)
+
+type v3Metadata struct {
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// registration reads the registration from the plugin's label when there is
+// one. The drain URL then only provides the config.
+func (m v3Metadata) registration(name, drainUrl string) (Registration, bool) {
+	registrationType := m.Labels[TypeLabel]
+	if registrationType == "" {
+		return registration(name, drainUrl)
+	}
+
+	return Registration{
+		Name:    name,
+		Type:    registrationType,
+		Config:  strings.TrimPrefix(drainUrl, registrationType+"://"),
+		Managed: true,
+	}, true
+}
+
+type markerClient interface {
+	Get(path string, v interface{}) error
+	Do(method, path string, body, v interface{}) error
+}
+
+// Marker labels and annotates the service instances the plugin creates.
+type Marker struct {
+	client  markerClient
+	cliConn cliConn
+	version string
+	creator string
+	now     func() time.Time
+}
+
+func NewMarker(client markerClient, conn cliConn, version, creator string) *Marker {
+	return &Marker{
+		client:  client,
+		cliConn: conn,
+		version: version,
+		creator: creator,
+		now:     time.Now,
+	}
+}
+
+// Mark records on the named service instance of the targeted space that the
+// plugin created it for a registration of the given type.
+func (m *Marker) Mark(serviceName, registrationType string) error {
+	space, err := m.cliConn.GetCurrentSpace()
+	if err != nil {
+		return err
+	}
+
+	var page struct {
+		Resources []v3ServiceInstance `json:"resources"`
+	}
+	path := fmt.Sprintf("/v3/service_instances?names=%s&space_guids=%s", url.QueryEscape(serviceName), space.Guid)
+	err = m.client.Get(path, &page)
+	if err != nil {
+		return err
+	}
+	if len(page.Resources) == 0 {
+		return fmt.Errorf("service %s not found", serviceName)
+	}
+
+	var body struct {
+		Metadata v3Metadata `json:"metadata"`
+	}
+	body.Metadata = v3Metadata{
+		Labels: map[string]string{TypeLabel: registrationType},
+		Annotations: map[string]string{
+			PluginVersionAnnotation: m.version,
+			CreatedByAnnotation:     m.creator,
+			CreatedAtAnnotation:     m.now().UTC().Format(time.RFC3339),
+		},
+	}
+	return m.client.Do(http.MethodPatch, "/v3/service_instances/"+page.Resources[0].Guid, body, nil)
+}
@@ -0,0 +1,132 @@
+package registrations_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	plugin_models "code.cloudfoundry.org/cli/plugin/models"
+	"github.com/pivotal-cf/metric-registrar-cli/registrations"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Metadata", func() {
+	Describe("Marker", func() {
+		var client *mockMarkerClient
+
+		BeforeEach(func() {
+			client = &mockMarkerClient{
+				requests: make(chan markerRequest, 10),
+				response: `{"resources": [{"guid": "service-guid", "name": "secure-endpoint-2112-metrics"}]}`,
+			}
+		})
+
+		It("labels and annotates the service instance", func() {
+			created := time.Date(2026, 10, 17, 1, 2, 3, 0, time.FixedZone("CEST", 2*60*60))
+			marker := registrations.NewMarker(client, client, "1.2.3", "user@example.com").WithClock(func() time.Time { return created })
+
+			Expect(marker.Mark("secure-endpoint-2112-metrics", "secure-endpoint")).To(Succeed())
+
+			Expect(client.requests).To(Receive(Equal(markerRequest{
+				method: http.MethodGet,
+				path:   "/v3/service_instances?names=secure-endpoint-2112-metrics&space_guids=space-guid",
+			})))
+			Expect(client.requests).To(Receive(Equal(markerRequest{
+				method: http.MethodPatch,
+				path:   "/v3/service_instances/service-guid",
+				body: `{"metadata":{"labels":{"metric-registrar.pivotal.io/type":"secure-endpoint"},"annotations":{` +
+					`"metric-registrar.pivotal.io/created-at":"2026-10-16T23:02:03Z",` +
+					`"metric-registrar.pivotal.io/created-by":"user@example.com",` +
+					`"metric-registrar.pivotal.io/plugin-version":"1.2.3"}}}`,
+			})))
+		})
+
+		It("returns an error if the service instance doesn't exist", func() {
+			client.response = `{"resources": []}`
+
+			err := registrations.NewMarker(client, client, "1.2.3", "user").Mark("missing", "secure-endpoint")
+			Expect(err).To(MatchError("service missing not found"))
+		})
+
+		It("returns the Cloud Controller error", func() {
+			client.err = errors.New("expected")
+
+			err := registrations.NewMarker(client, client, "1.2.3", "user").Mark("service", "secure-endpoint")
+			Expect(err).To(MatchError("expected"))
+		})
+	})
+
+	It("prefers the plugin's label to the drain URL", func() {
+		client := newMockV3Client()
+		client.responses["service_instances"] = []string{`{
+  "pagination": {"next": null},
+  "resources": [
+    {
+      "guid": "managed-guid",
+      "name": "managed",
+      "syslog_drain_url": "metrics-endpoint://app.example.com/metrics",
+      "metadata": {"labels": {"metric-registrar.pivotal.io/type": "metrics-endpoint"}}
+    },
+    {
+      "guid": "hand-made-guid",
+      "name": "hand-made",
+      "syslog_drain_url": "metrics-endpoint://app.example.com/other"
+    },
+    {
+      "guid": "mislabelled-guid",
+      "name": "mislabelled",
+      "syslog_drain_url": "syslog://logs.example.com",
+      "metadata": {"labels": {"metric-registrar.pivotal.io/type": "structured-format"}}
+    }
+  ]
+}`}
+
+		s, err := registrations.NewV3Fetcher(client, client).FetchInstances("metrics-endpoint")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(s).To(Equal([]registrations.Registration{
+			{Name: "managed", Type: "metrics-endpoint", Config: "app.example.com/metrics", Managed: true},
+			{Name: "hand-made", Type: "metrics-endpoint", Config: "app.example.com/other"},
+		}))
+	})
+})
+
+type markerRequest struct {
+	method string
+	path   string
+	body   string
+}
+
+type mockMarkerClient struct {
+	requests chan markerRequest
+	response string
+	err      error
+}
+
+func (c *mockMarkerClient) Get(path string, v interface{}) error {
+	return c.Do(http.MethodGet, path, nil, v)
+}
+
+func (c *mockMarkerClient) Do(method, path string, body, v interface{}) error {
+	r := markerRequest{method: method, path: path}
+	if body != nil {
+		b, err := json.Marshal(body)
+		Expect(err).ToNot(HaveOccurred())
+		r.body = string(b)
+	}
+	c.requests <- r
+
+	if c.err != nil {
+		return c.err
+	}
+	if v == nil {
+		return nil
+	}
+	return json.Unmarshal([]byte(c.response), v)
+}
+
+func (c *mockMarkerClient) GetCurrentSpace() (plugin_models.Space, error) {
+	return plugin_models.Space{SpaceFields: plugin_models.SpaceFields{Guid: "space-guid"}}, nil
+}